@@ -3,6 +3,7 @@ package routes
 import (
 	"encoding/json"
 	"log"
+	"time"
 
 	"musick-server/internal/app/services"
 
@@ -10,18 +11,37 @@ import (
 )
 
 type LoginRequest struct {
-	Token string `json:"token"` // JWT from Supabase
+	Token  string `json:"token"`  // JWT from Supabase
+	Locale string `json:"locale"` // optional, e.g. "zh-TW"; error messages use it
 }
 
 type LoginResponse struct {
-	Success  bool   `json:"success"`
-	Message  string `json:"message"`
-	UserID   string `json:"user_id,omitempty"`
-	UserName string `json:"user_name,omitempty"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	UserID    string `json:"user_id,omitempty"`
+	UserName  string `json:"user_name,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// ResumeToken restores this session on a new connection (route 13)
+	// for ResumeWindow seconds after a disconnect.
+	ResumeToken  string `json:"resume_token,omitempty"`
+	ResumeWindow int    `json:"resume_window,omitempty"`
 }
 
+type RefreshTokenRequest struct {
+	Token string `json:"token"` // new JWT for the same user
+}
+
+type RefreshTokenResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// RegisterAuthRoutes wires login (10) and token refresh (11).
+// Route 12 is server-initiated: services.TokenNotice when a token is expiring or expired.
 func RegisterAuthRoutes(s *easytcp.Server) {
 	s.AddRoute(10, handleLogin)
+	s.AddRoute(11, handleRefreshToken)
 }
 
 func handleLogin(ctx easytcp.Context) {
@@ -29,7 +49,7 @@ func handleLogin(ctx easytcp.Context) {
 
 	var loginReq LoginRequest
 	if err := json.Unmarshal(req.Data(), &loginReq); err != nil {
-		sendError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
@@ -37,28 +57,72 @@ func handleLogin(ctx easytcp.Context) {
 	user, err := services.VerifyToken(loginReq.Token)
 	if err != nil {
 		log.Printf("token verification failed: %v", err)
-		sendError(ctx, "authentication failed")
+		sendError(ctx, errAuthFailed)
 		return
 	}
 
 	log.Printf("user authenticated: %s (%s)", user.Email, user.ID)
 
 	// Store session data for the connection's lifetime
-	services.StoreSession(ctx.Session(), user.ID, user.Email, user.GetUserName())
+	resumeToken := services.StoreSession(ctx.Session(), user.ID, user.Email, user.GetUserName(), loginReq.Locale, user.ExpiresAt)
 
 	resp := LoginResponse{
-		Success:  true,
-		Message:  "authenticated",
-		UserID:   user.ID,
-		UserName: user.GetUserName(),
+		Success:   true,
+		Message:   "authenticated",
+		UserID:    user.ID,
+		UserName:  user.GetUserName(),
+		ExpiresAt: formatExpiry(user.ExpiresAt),
+	}
+	if resumeToken != "" {
+		resp.ResumeToken = resumeToken
+		resp.ResumeWindow = int(services.ResumeWindow.Seconds())
 	}
 
 	respData, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), respData))
 }
 
-func sendError(ctx easytcp.Context, msg string) {
-	resp := LoginResponse{Success: false, Message: msg}
+func handleRefreshToken(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var refreshReq RefreshTokenRequest
+	if err := json.Unmarshal(req.Data(), &refreshReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	user, err := services.VerifyToken(refreshReq.Token)
+	if err != nil {
+		log.Printf("token refresh verification failed: %v", err)
+		sendError(ctx, errAuthFailed)
+		return
+	}
+
+	if err := services.RefreshSession(ctx.Session(), user.ID, user.Email, user.GetUserName(), user.ExpiresAt); err != nil {
+		log.Printf("token refresh rejected: %v", err)
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	resp := RefreshTokenResponse{
+		Success:   true,
+		Message:   "token refreshed",
+		ExpiresAt: formatExpiry(user.ExpiresAt),
+	}
+
 	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// formatExpiry renders a token expiry for responses; zero means no expiry.
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
 }
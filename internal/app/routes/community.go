@@ -74,43 +74,44 @@ type PostItem struct {
 }
 
 // RegisterCommunityRoutes wires post-related handlers.
-func RegisterCommunityRoutes(s *easytcp.Server) {
-	s.AddRoute(701, handleCreatePost)
-	s.AddRoute(702, handleDeletePost)
-	s.AddRoute(710, handleListPosts)
-	s.AddRoute(711, handleUpdatePost)
+func RegisterCommunityRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(701, h.handleCreatePost)
+	s.AddRoute(702, h.handleDeletePost)
+	s.AddRoute(710, h.handleListPosts)
+	s.AddRoute(711, h.handleUpdatePost)
 }
 
-func handleCreatePost(ctx easytcp.Context) {
+func (h *handler) handleCreatePost(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("701 create post: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendCreatePostError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var createReq CreatePostRequest
 	if err := json.Unmarshal(req.Data(), &createReq); err != nil {
-		sendCreatePostError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if createReq.UserID == "" || createReq.Title == "" || createReq.Body == "" {
-		sendCreatePostError(ctx, "user_id, title, and body are required")
+		sendError(ctx, missingFields("user_id", "title", "body"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != createReq.UserID {
-		sendCreatePostError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	post, err := services.CreateCommunityPost(createReq.UserID, createReq.Title, createReq.Body)
+	post, err := h.store.Posts.CreateCommunityPost(createReq.UserID, createReq.Title, createReq.Body)
 	if err != nil {
 		log.Printf("failed to create post: %v", err)
-		sendCreatePostError(ctx, "failed to create post")
+		sendError(ctx, storeFailure(err, "failed to create post"))
 		return
 	}
 
@@ -119,41 +120,35 @@ func handleCreatePost(ctx easytcp.Context) {
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendCreatePostError(ctx easytcp.Context, msg string) {
-	resp := CreatePostResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func handleDeletePost(ctx easytcp.Context) {
+func (h *handler) handleDeletePost(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("702 delete post: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendDeletePostError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var delReq DeletePostRequest
 	if err := json.Unmarshal(req.Data(), &delReq); err != nil {
-		sendDeletePostError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if delReq.UserID == "" || delReq.PostID == "" {
-		sendDeletePostError(ctx, "user_id and post_id are required")
+		sendError(ctx, missingFields("user_id", "post_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != delReq.UserID {
-		sendDeletePostError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	if err := services.DeleteCommunityPost(delReq.PostID, delReq.UserID); err != nil {
+	if err := h.store.Posts.DeleteCommunityPost(delReq.PostID, delReq.UserID); err != nil {
 		log.Printf("failed to delete post: %v", err)
-		sendDeletePostError(ctx, "failed to delete post")
+		sendError(ctx, storeFailure(err, "failed to delete post"))
 		return
 	}
 
@@ -162,47 +157,41 @@ func handleDeletePost(ctx easytcp.Context) {
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendDeletePostError(ctx easytcp.Context, msg string) {
-	resp := DeletePostResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func handleUpdatePost(ctx easytcp.Context) {
+func (h *handler) handleUpdatePost(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("711 update post: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendUpdatePostError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var updReq UpdatePostRequest
 	if err := json.Unmarshal(req.Data(), &updReq); err != nil {
-		sendUpdatePostError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if updReq.UserID == "" || updReq.PostID == "" {
-		sendUpdatePostError(ctx, "user_id and post_id are required")
+		sendError(ctx, missingFields("user_id", "post_id"))
 		return
 	}
 
 	if (updReq.Title == nil || *updReq.Title == "") && (updReq.Body == nil || *updReq.Body == "") {
-		sendUpdatePostError(ctx, "title or body must be provided")
+		sendError(ctx, invalidFields("title or body must be provided", "title", "empty", "body", "empty"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != updReq.UserID {
-		sendUpdatePostError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	post, err := services.UpdateCommunityPost(updReq.PostID, updReq.UserID, updReq.Title, updReq.Body)
+	post, err := h.store.Posts.UpdateCommunityPost(updReq.PostID, updReq.UserID, updReq.Title, updReq.Body)
 	if err != nil {
 		log.Printf("failed to update post: %v", err)
-		sendUpdatePostError(ctx, "failed to update post")
+		sendError(ctx, storeFailure(err, "failed to update post"))
 		return
 	}
 
@@ -211,43 +200,48 @@ func handleUpdatePost(ctx easytcp.Context) {
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendUpdatePostError(ctx easytcp.Context, msg string) {
-	resp := UpdatePostResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func handleListPosts(ctx easytcp.Context) {
+func (h *handler) handleListPosts(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("710 list posts: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendListPostsError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var lpReq ListPostsRequest
 	if err := json.Unmarshal(req.Data(), &lpReq); err != nil {
-		sendListPostsError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if lpReq.UserID == "" {
-		sendListPostsError(ctx, "user_id is required")
+		sendError(ctx, missingFields("user_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != lpReq.UserID {
-		sendListPostsError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	resp, apiErr := h.listPosts(lpReq)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
 		return
 	}
 
-	posts, hasMore, err := services.ListCommunityPosts(lpReq.BeforeID, lpReq.Limit, lpReq.IncludeAttachment)
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// listPosts backs route 710 and GET /api/v1/posts.
+func (h *handler) listPosts(lpReq ListPostsRequest) (*ListPostsResponse, *APIError) {
+	posts, hasMore, err := h.store.Posts.ListCommunityPosts(lpReq.BeforeID, lpReq.Limit, lpReq.IncludeAttachment)
 	if err != nil {
 		log.Printf("failed to list posts: %v", err)
-		sendListPostsError(ctx, "failed to list posts")
-		return
+		return nil, storeFailure(err, "failed to list posts")
 	}
 
 	items := make([]PostItem, 0, len(posts))
@@ -275,13 +269,5 @@ func handleListPosts(ctx easytcp.Context) {
 		last := posts[len(posts)-1]
 		resp.NextBefore = last.CreatedAt.Format(time.RFC3339)
 	}
-
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
-}
-
-func sendListPostsError(ctx easytcp.Context, msg string) {
-	resp := ListPostsResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+	return &resp, nil
 }
@@ -17,7 +17,7 @@ func handleEcho(ctx easytcp.Context) {
 
 	if !services.IsAuthenticated(ctx.Session()) {
 		log.Printf("unauthenticated session attempted to use echo route")
-		ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), []byte("error: unauthenticated")))
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), req.Data()))
@@ -0,0 +1,199 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// ErrorCode is the stable, machine-readable reason a request failed.
+type ErrorCode string
+
+const (
+	CodeUnauthenticated     ErrorCode = "UNAUTHENTICATED"
+	CodeForbidden           ErrorCode = "FORBIDDEN"
+	CodeNotFound            ErrorCode = "NOT_FOUND"
+	CodeValidation          ErrorCode = "VALIDATION"
+	CodeConflict            ErrorCode = "CONFLICT"
+	CodeUpstreamUnavailable ErrorCode = "UPSTREAM_UNAVAILABLE"
+	CodeRateLimited         ErrorCode = "RATE_LIMITED"
+	CodeIncompatibleClient  ErrorCode = "INCOMPATIBLE_CLIENT"
+	CodeInternal            ErrorCode = "INTERNAL"
+)
+
+// FieldError points a validation failure at one request field.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// APIError is the error part of the response envelope. Key identifies the
+// message for client-side translation; the rendered text goes in the
+// envelope's message, in the session's locale when a translation exists.
+type APIError struct {
+	Code    ErrorCode    `json:"code"`
+	Key     string       `json:"message_key"`
+	Details []FieldError `json:"details,omitempty"`
+
+	message string        // English text
+	args    []interface{} // arguments for the translated template
+}
+
+// ErrorResponse is what every route sends on failure. success and message keep
+// the shape older clients already parse; error is only sent to connections
+// that negotiated the error_codes feature.
+type ErrorResponse struct {
+	Success bool      `json:"success"`
+	Message string    `json:"message"`
+	Error   *APIError `json:"error,omitempty"`
+}
+
+func newError(code ErrorCode, key, message string, args ...interface{}) *APIError {
+	return &APIError{Code: code, Key: key, message: message, args: args}
+}
+
+// Errors shared by most handlers.
+var (
+	errNotAuthenticated = newError(CodeUnauthenticated, "not_authenticated", "not authenticated")
+	errInvalidFormat    = newError(CodeValidation, "invalid_request_format", "invalid request format")
+	errUserMismatch     = newError(CodeForbidden, "user_id_mismatch", "user_id mismatch")
+	errAuthFailed       = newError(CodeUnauthenticated, "authentication_failed", "authentication failed")
+	errNothingToUpdate  = newError(CodeValidation, "nothing_to_update", "no fields to update")
+	errInvalidRole      = invalidFields("role must be editor, commenter, or viewer", "role", "one of editor, commenter, viewer")
+	errTargetNotMember  = newError(CodeNotFound, "not_found", "target is not a member of this room")
+	errRoomNotFound     = newError(CodeNotFound, "not_found", "room not found")
+
+	errInvalidNotePosition = invalidFields("step must be >= 0 and pitch must be > 0", "step", ">= 0", "pitch", "> 0")
+)
+
+// featureRequired rejects a route that only exists with a feature flag the
+// connection didn't negotiate on route 2.
+func featureRequired(feature string) *APIError {
+	return newError(CodeIncompatibleClient, "feature_not_negotiated", fmt.Sprintf("negotiate the %s feature in the handshake (route 2) first", feature), feature)
+}
+
+// missingFields reports required fields that were left empty, keeping the
+// "a, b, and c are required" wording clients have seen so far.
+func missingFields(fields ...string) *APIError {
+	var msg string
+	switch len(fields) {
+	case 1:
+		msg = fields[0] + " is required"
+	case 2:
+		msg = fields[0] + " and " + fields[1] + " are required"
+	default:
+		msg = strings.Join(fields[:len(fields)-1], ", ") + ", and " + fields[len(fields)-1] + " are required"
+	}
+	e := newError(CodeValidation, "missing_fields", msg, strings.Join(fields, ", "))
+	for _, f := range fields {
+		e.Details = append(e.Details, FieldError{Field: f, Reason: "required"})
+	}
+	return e
+}
+
+// invalidFields reports request fields with unacceptable values; reasons are
+// given as field, reason pairs.
+func invalidFields(message string, fieldReasons ...string) *APIError {
+	e := newError(CodeValidation, "invalid_fields", message)
+	for i := 0; i+1 < len(fieldReasons); i += 2 {
+		e.Details = append(e.Details, FieldError{Field: fieldReasons[i], Reason: fieldReasons[i+1]})
+	}
+	return e
+}
+
+// storeFailure classifies an error from the store or an external API: network
+// failures are UPSTREAM_UNAVAILABLE, anything else INTERNAL. The cause is
+// logged by the caller and never sent to the client.
+func storeFailure(err error, message string) *APIError {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return newError(CodeUpstreamUnavailable, "upstream_unavailable", message)
+	}
+	return newError(CodeInternal, "internal", message)
+}
+
+// translations holds localized message templates by language, then key.
+// Templates take the error's args; keys without a template fall back to the
+// English message.
+var translations = map[string]map[string]string{
+	"zh": {
+		"not_authenticated":      "未驗證身份",
+		"invalid_request_format": "無效的請求格式",
+		"user_id_mismatch":       "使用者不符",
+		"missing_fields":         "缺少必要欄位：%s",
+		"invalid_fields":         "欄位內容無效",
+		"not_room_member":        "你不是此房間的成員",
+		"forbidden":              "你的角色無法執行此操作",
+		"forbidden_target":       "無法對此成員執行此操作",
+		"not_found":              "找不到資料",
+		"authentication_failed":  "驗證失敗",
+		"nothing_to_update":      "沒有要更新的欄位",
+		"join_refused":           "無法加入此房間",
+		"conflict":               "資料已變更，請重新整理後再試",
+		"note_position_taken":    "該位置已有音符",
+		"note_changed":           "音符已被他人修改",
+		"nothing_to_undo":        "沒有可復原的操作",
+		"nothing_to_redo":        "沒有可重做的操作",
+		"history_stale":          "內容已被他人修改，已從記錄中移除此操作",
+		"snapshot_not_found":     "找不到此版本",
+		"resume_invalid":         "無法恢復連線，請重新登入",
+		"client_too_old":         "應用程式版本過舊，請更新後再試",
+		"client_too_new":         "伺服器尚未支援此應用程式版本，請稍後再試",
+		"incompatible_client":    "應用程式與伺服器不相容，請更新後再試",
+		"handshake_required":     "應用程式版本過舊，請更新後再試",
+		"feature_not_negotiated": "此連線未啟用 %s 功能",
+		"recognition_failed":     "辨識失敗",
+		"upstream_unavailable":   "服務暫時無法使用，請稍後再試",
+		"internal":               "伺服器錯誤",
+	},
+}
+
+// localize renders e in locale ("zh-TW", "zh", ...), falling back to English.
+func (e *APIError) localize(locale string) string {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if tmpl, ok := translations[lang][e.Key]; ok {
+		if len(e.args) > 0 {
+			return fmt.Sprintf(tmpl, e.args...)
+		}
+		return tmpl
+	}
+	return e.message
+}
+
+// sendError answers the current request with the error envelope.
+func sendError(ctx easytcp.Context, e *APIError) {
+	data, err := json.Marshal(errorEnvelope(ctx, e))
+	if err != nil {
+		log.Printf("encode error response: %v", err)
+		return
+	}
+	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+}
+
+// errorEnvelope renders e for the session's locale and marks the request as
+// failed for the request log; for routes that add fields to the envelope.
+// The error object is left out unless the connection negotiated error_codes;
+// handshake (2) failures always carry it, since only clients that know the
+// handshake send one.
+func errorEnvelope(ctx easytcp.Context, e *APIError) ErrorResponse {
+	locale := ""
+	if session := services.GetSession(ctx.Session()); session != nil {
+		locale = session.Locale
+	}
+	ctx.Set(ctxErrorCode, e.Code)
+	resp := ErrorResponse{Success: false, Message: e.localize(locale)}
+	if ctx.Request().ID() == 2 || services.ProtocolFor(ctx.Session()).Has(services.FeatureErrorCodes) {
+		resp.Error = e
+	}
+	return resp
+}
@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"errors"
+	"log"
+
+	"musick-server/internal/app/services"
+)
+
+// handler carries the dependencies shared by route handlers.
+type handler struct {
+	store *services.Store
+}
+
+// accessDenied maps a room authorization failure to the error sent to the client.
+func accessDenied(err error) *APIError {
+	switch {
+	case errors.Is(err, services.ErrNotRoomMember):
+		return newError(CodeForbidden, "not_room_member", err.Error())
+	case errors.Is(err, services.ErrForbidden):
+		return newError(CodeForbidden, "forbidden", err.Error())
+	case errors.Is(err, services.ErrSongNotInRoom),
+		errors.Is(err, services.ErrTrackNotInSong):
+		return newError(CodeNotFound, "not_found", err.Error())
+	}
+	log.Printf("room access check failed: %v", err)
+	return storeFailure(err, "failed to verify room access")
+}
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+type HandshakeRequest struct {
+	Version    int      `json:"version"`     // newest protocol version the client speaks
+	MinVersion int      `json:"min_version"` // oldest it still speaks; defaults to version
+	Codecs     []string `json:"codecs"`      // preferred first; defaults to ["json"]
+	Features   []string `json:"features"`    // feature flags the client understands
+	Client     string   `json:"client"`      // optional, e.g. "musick-flutter/1.4.0", for logs
+}
+
+type HandshakeResponse struct {
+	Success    bool     `json:"success"`
+	Message    string   `json:"message"`
+	Version    int      `json:"version"`
+	MinVersion int      `json:"min_version"`
+	MaxVersion int      `json:"max_version"`
+	Codec      string   `json:"codec"`
+	Features   []string `json:"features"`
+	// With the heartbeat feature: how often to ping (route 3) and after how
+	// much silence the server closes the connection, in seconds.
+	HeartbeatInterval int `json:"heartbeat_interval,omitempty"`
+	HeartbeatTimeout  int `json:"heartbeat_timeout,omitempty"`
+}
+
+// RegisterHandshakeRoutes wires the protocol handshake (2). Clients send it
+// first, before login; connections that skip it are treated as protocol 1.
+func RegisterHandshakeRoutes(s *easytcp.Server) {
+	s.AddRoute(2, handleHandshake)
+}
+
+func handleHandshake(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, newError(CodeConflict, "conflict", "handshake must come before login"))
+		return
+	}
+
+	var hsReq HandshakeRequest
+	if err := json.Unmarshal(req.Data(), &hsReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if hsReq.Version <= 0 {
+		sendError(ctx, missingFields("version"))
+		return
+	}
+
+	p, err := services.NegotiateProtocol(ctx.Session(), hsReq.MinVersion, hsReq.Version, hsReq.Codecs, hsReq.Features)
+	if err != nil {
+		sendError(ctx, incompatibleClient(err))
+		return
+	}
+
+	log.Printf("handshake from %s: client=%q protocol %d codec %s", ctx.Session().Conn().RemoteAddr(), hsReq.Client, p.Version, p.Codec)
+
+	minVersion, maxVersion := services.ProtocolVersionRange()
+	resp := HandshakeResponse{
+		Success:    true,
+		Message:    "ok",
+		Version:    p.Version,
+		MinVersion: minVersion,
+		MaxVersion: maxVersion,
+		Codec:      p.Codec,
+		Features:   []string{},
+	}
+	for _, f := range services.ServerFeatures() {
+		if p.Has(f) {
+			resp.Features = append(resp.Features, f)
+		}
+	}
+	if p.Has(services.FeatureHeartbeat) {
+		interval, timeout := services.HeartbeatSettings()
+		resp.HeartbeatInterval = int(interval.Seconds())
+		resp.HeartbeatTimeout = int(timeout.Seconds())
+	}
+	if p.Version < hsReq.Version {
+		resp.Message = fmt.Sprintf("downgraded to protocol %d", p.Version)
+	}
+
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// incompatibleClient maps a failed negotiation to the error sent to the client.
+func incompatibleClient(err error) *APIError {
+	key := "incompatible_client"
+	switch {
+	case errors.Is(err, services.ErrHandshakeTooOld):
+		key = "client_too_old"
+	case errors.Is(err, services.ErrHandshakeTooNew):
+		key = "client_too_new"
+	}
+	return newError(CodeIncompatibleClient, key, err.Error())
+}
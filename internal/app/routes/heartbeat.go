@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+type PingRequest struct {
+	// TS is an optional client timestamp echoed back, for measuring round trips.
+	TS int64 `json:"ts,omitempty"`
+}
+
+type PongResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	TS         int64  `json:"ts,omitempty"`
+	ServerTime int64  `json:"server_time"` // unix milliseconds
+}
+
+// RegisterHeartbeatRoutes wires the heartbeat ping (3). Clients that
+// negotiated the "heartbeat" feature send it every heartbeat_interval seconds
+// when otherwise idle; it is accepted before login too.
+func RegisterHeartbeatRoutes(s *easytcp.Server) {
+	s.AddRoute(3, handlePing)
+}
+
+func handlePing(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	var ping PingRequest
+	if len(req.Data()) > 0 {
+		if err := json.Unmarshal(req.Data(), &ping); err != nil {
+			sendError(ctx, errInvalidFormat)
+			return
+		}
+	}
+
+	resp := PongResponse{
+		Success:    true,
+		Message:    "pong",
+		TS:         ping.TS,
+		ServerTime: time.Now().UnixMilli(),
+	}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
@@ -0,0 +1,334 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// HistoryRequest undoes (620) or redoes (621) the caller's latest edit of a song.
+type HistoryRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+	SongID string `json:"song_id"`
+}
+
+// HistoryResponse tells what was undone or redone and how deep both stacks
+// are now, so clients can enable their buttons.
+type HistoryResponse struct {
+	Success   bool                  `json:"success"`
+	Message   string                `json:"message"`
+	Kind      string                `json:"kind,omitempty"` // notes, track_add, track_remove or song
+	Changes   []services.NoteChange `json:"changes,omitempty"`
+	Track     *services.Track       `json:"track,omitempty"`
+	Song      *services.Song        `json:"song,omitempty"`
+	UndoDepth int                   `json:"undo_depth"`
+	RedoDepth int                   `json:"redo_depth"`
+}
+
+var (
+	errNothingToUndo = newError(CodeNotFound, "nothing_to_undo", "nothing to undo")
+	errNothingToRedo = newError(CodeNotFound, "nothing_to_redo", "nothing to redo")
+	errHistoryStale  = newError(CodeConflict, "history_stale", "the song was changed since; that edit was dropped from your history")
+)
+
+// RegisterHistoryRoutes wires undo/redo handlers.
+func RegisterHistoryRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(620, func(ctx easytcp.Context) { h.handleHistory(ctx, false) })
+	s.AddRoute(621, func(ctx easytcp.Context) { h.handleHistory(ctx, true) })
+}
+
+func (h *handler) handleHistory(ctx easytcp.Context, redo bool) {
+	req := ctx.Request()
+	verb := "undo"
+	if redo {
+		verb = "redo"
+	}
+	log.Printf("%d %s: id=%d bytes=%d", req.ID(), verb, req.ID(), len(req.Data()))
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var hReq HistoryRequest
+	if err := json.Unmarshal(req.Data(), &hReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if hReq.UserID == "" || hReq.RoomID == "" || hReq.SongID == "" {
+		sendError(ctx, missingFields("user_id", "room_id", "song_id"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != hReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireSong(session, hReq.RoomID, hReq.SongID)
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	edit, ok := services.PopEdit(hReq.RoomID, hReq.SongID, hReq.UserID, redo)
+	if !ok {
+		if redo {
+			sendError(ctx, errNothingToRedo)
+		} else {
+			sendError(ctx, errNothingToUndo)
+		}
+		return
+	}
+
+	perm := services.PermEditNotes
+	switch edit.Kind {
+	case services.EditTrackAdd, services.EditTrackRemove:
+		perm = services.PermEditTracks
+	case services.EditSong:
+		perm = services.PermEditSongs
+	}
+	if err := member.Require(perm); err != nil {
+		services.PushEdit(hReq.RoomID, hReq.UserID, edit, redo)
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	resp, apiErr := h.applyEdit(hReq.RoomID, hReq.UserID, edit, redo)
+	if apiErr != nil {
+		// A stale edit can never apply again; anything else may be transient.
+		if apiErr != errHistoryStale {
+			services.PushEdit(hReq.RoomID, hReq.UserID, edit, redo)
+		}
+		sendError(ctx, apiErr)
+		return
+	}
+	services.PushEdit(hReq.RoomID, hReq.UserID, edit, !redo)
+	services.MarkSongEdited(hReq.SongID, hReq.UserID)
+
+	// Ensure this session is tracked in the room for broadcasts.
+	services.AddSessionToRoom(hReq.RoomID, ctx.Session())
+
+	resp.Success = true
+	resp.Message = "edit undone"
+	if redo {
+		resp.Message = "edit redone"
+	}
+	resp.Kind = edit.Kind
+	resp.UndoDepth, resp.RedoDepth = services.HistoryDepth(hReq.RoomID, hReq.SongID, hReq.UserID)
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// applyEdit reverts edit (or, for redo, makes it again) and broadcasts the
+// result on 603/606/512 like the original routes do. errHistoryStale means the
+// song no longer looks the way the edit left it.
+func (h *handler) applyEdit(roomID, userID string, edit services.Edit, redo bool) (*HistoryResponse, *APIError) {
+	resp := &HistoryResponse{}
+	switch edit.Kind {
+	case services.EditNotes:
+		changes, apiErr := h.applyNoteChanges(edit.SongID, userID, edit.Notes, redo)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		publishNoteChanges(roomID, edit.SongID, changes)
+		resp.Changes = changes
+
+	case services.EditTrackAdd, services.EditTrackRemove:
+		// Undoing an add and redoing a remove both take the track away.
+		remove := (edit.Kind == services.EditTrackAdd) != redo
+		if remove {
+			notes, err := h.store.Notes.ListNotesBySong(edit.SongID, edit.Track.ID)
+			if err != nil {
+				log.Printf("failed to list notes: %v", err)
+				return nil, storeFailure(err, "failed to list notes")
+			}
+			// The track may only take along the notes the edit knows about,
+			// and the delete only goes through if none of them moved since.
+			if len(notes) != len(edit.Notes) || !services.NoteChangesInPlace(notes, edit.Notes, true) {
+				return nil, errHistoryStale
+			}
+			versions := make(map[string]int, len(notes))
+			for _, n := range notes {
+				versions[n.ID] = n.Version
+			}
+			err = h.store.Tracks.DeleteTrackIfNotes(edit.Track.ID, edit.SongID, versions)
+			if errors.Is(err, services.ErrStaleVersion) || errors.Is(err, services.ErrNotFound) {
+				return nil, errHistoryStale
+			} else if err != nil {
+				log.Printf("failed to delete track: %v", err)
+				return nil, storeFailure(err, "failed to delete track")
+			}
+			services.InvalidateSongTracks(edit.SongID)
+			publishTrack(roomID, TrackBroadcast{Action: "off", TrackID: edit.Track.ID, SongID: edit.SongID})
+			resp.Track = edit.Track
+			resp.Changes = edit.Notes
+			break
+		}
+
+		track, err := h.store.Tracks.RestoreTrack(*edit.Track)
+		if errors.Is(err, services.ErrConflict) {
+			return nil, errHistoryStale
+		} else if err != nil {
+			log.Printf("failed to restore track: %v", err)
+			return nil, storeFailure(err, "failed to restore track")
+		}
+		var changes []services.NoteChange
+		if len(edit.Notes) > 0 {
+			var apiErr *APIError
+			if changes, apiErr = h.applyNoteChanges(edit.SongID, userID, edit.Notes, false); apiErr != nil {
+				if err := h.store.Tracks.DeleteTrack(track.ID, edit.SongID); err != nil {
+					log.Printf("failed to roll back restored track: %v", err)
+				}
+				services.InvalidateSongTracks(edit.SongID)
+				return nil, apiErr
+			}
+		}
+		publishTrack(roomID, TrackBroadcast{Action: "on", Track: track, TrackID: track.ID, SongID: track.SongID})
+		publishNoteChanges(roomID, edit.SongID, changes)
+		resp.Track = track
+		resp.Changes = changes
+
+	case services.EditSong:
+		from, to := edit.After, edit.Before
+		if redo {
+			from, to = edit.Before, edit.After
+		}
+		song, changed, apiErr := h.revertSong(edit.SongID, from, to)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if changed {
+			publishSong(roomID, userID, song)
+		}
+		resp.Song = song
+	}
+	return resp, nil
+}
+
+// applyNoteChanges reverts changes (or, for redo, makes them again) in one
+// batch, after checking nobody touched those notes since.
+func (h *handler) applyNoteChanges(songID, userID string, changes []services.NoteChange, redo bool) ([]services.NoteChange, *APIError) {
+	song, err := h.store.Songs.GetSong(songID)
+	if err != nil {
+		log.Printf("failed to fetch song: %v", err)
+		return nil, storeFailure(err, "failed to fetch song")
+	}
+	tracks, err := h.store.Tracks.ListTracksBySong(songID)
+	if err != nil {
+		log.Printf("failed to list tracks: %v", err)
+		return nil, storeFailure(err, "failed to list tracks")
+	}
+	notes, err := h.store.Notes.ListNotesBySong(songID, "")
+	if err != nil {
+		log.Printf("failed to list notes: %v", err)
+		return nil, storeFailure(err, "failed to list notes")
+	}
+	if !services.NoteChangesInPlace(notes, changes, redo) {
+		return nil, errHistoryStale
+	}
+
+	ops := services.NoteOpsFor(changes, redo)
+	if err := services.CheckNoteBatch(song, tracks, notes, ops); err != nil {
+		// A track or a grid setting changed under the edit.
+		log.Printf("history edit no longer applies: %v", err)
+		return nil, errHistoryStale
+	}
+	applied, err := h.store.Notes.ApplyNoteBatch(songID, userID, ops)
+	if errors.Is(err, services.ErrConflict) || errors.Is(err, services.ErrNotFound) || errors.Is(err, services.ErrStaleVersion) {
+		return nil, errHistoryStale
+	} else if err != nil {
+		log.Printf("failed to apply note history: %v", err)
+		return nil, storeFailure(err, "failed to apply note changes")
+	}
+	return applied, nil
+}
+
+// revertSong sets the settings that differ between from and to back to to,
+// provided the song still has from's values there. changed is false when
+// there was nothing to write.
+func (h *handler) revertSong(songID string, from, to *services.Song) (song *services.Song, changed bool, apiErr *APIError) {
+	cur, err := h.store.Songs.GetSong(songID)
+	if err != nil {
+		log.Printf("failed to fetch song: %v", err)
+		return nil, false, storeFailure(err, "failed to fetch song")
+	}
+
+	stale := false
+	str := func(f, t, c string) *string {
+		if f == t {
+			return nil
+		}
+		stale = stale || c != f
+		changed = true
+		return &t
+	}
+	num := func(f, t, c int) *int {
+		if f == t {
+			return nil
+		}
+		stale = stale || c != f
+		changed = true
+		return &t
+	}
+	title := str(from.Title, to.Title, cur.Title)
+	bpm := num(from.BPM, to.BPM, cur.BPM)
+	steps := num(from.Steps, to.Steps, cur.Steps)
+	beats := num(from.BeatsPerMeasure, to.BeatsPerMeasure, cur.BeatsPerMeasure)
+	scale := str(from.Scale, to.Scale, cur.Scale)
+	startPitch := num(from.StartPitch, to.StartPitch, cur.StartPitch)
+	octaves := num(from.OctaveRange, to.OctaveRange, cur.OctaveRange)
+	switch {
+	case stale:
+		return nil, false, errHistoryStale
+	case !changed:
+		return cur, false, nil
+	}
+
+	updated, err := h.store.Songs.UpdateSong(songID, title, bpm, steps, beats, scale, startPitch, octaves)
+	if err != nil {
+		log.Printf("failed to update song: %v", err)
+		return nil, false, storeFailure(err, "failed to update song")
+	}
+	return updated, true, nil
+}
+
+// publishNoteChanges broadcasts note changes on 603: one change in the shape
+// of 601/602/607 ("on", "off", "update"), several as one "batch".
+func publishNoteChanges(roomID, songID string, changes []services.NoteChange) {
+	if len(changes) == 0 {
+		return
+	}
+	bcast := NoteBroadcast{Action: "batch", SongID: songID, Changes: changes}
+	if len(changes) == 1 {
+		c := changes[0]
+		n := c.Note
+		switch {
+		case c.Before == nil:
+			bcast = NoteBroadcast{Action: "on", Note: n}
+		case c.Note == nil:
+			n = c.Before
+			bcast = NoteBroadcast{Action: "off"}
+		default:
+			bcast = NoteBroadcast{Action: "update", Note: n, Before: c.Before}
+		}
+		bcast.SongID, bcast.TrackID, bcast.Step, bcast.Pitch = songID, n.TrackID, n.Step, n.Pitch
+	}
+	if b, err := json.Marshal(bcast); err == nil {
+		services.PublishToRoom(roomID, easytcp.NewMessage(603, b))
+	}
+}
+
+func publishTrack(roomID string, bcast TrackBroadcast) {
+	if b, err := json.Marshal(bcast); err == nil {
+		services.PublishToRoom(roomID, easytcp.NewMessage(606, b))
+	}
+}
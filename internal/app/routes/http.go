@@ -0,0 +1,217 @@
+package routes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"musick-server/internal/app/services"
+)
+
+// HTTPPrefix is where the REST gateway is mounted.
+const HTTPPrefix = "/api/v1"
+
+// httpParam is a path or query parameter of a REST operation.
+type httpParam struct {
+	Name        string
+	In          string // "path" or "query"
+	Type        string // "string", "integer" or "boolean"
+	Description string
+}
+
+// httpEndpoint is one REST operation mirroring a TCP route. The table below
+// drives both the mux and the generated OpenAPI description.
+type httpEndpoint struct {
+	Method   string
+	Path     string // relative to HTTPPrefix, with {name} path parameters
+	Route    int    // TCP route with the same behaviour
+	Summary  string
+	Params   []httpParam
+	Response interface{} // success body, for the OpenAPI schema
+	serve    func(h *handler, r *http.Request, session *services.UserSession) (interface{}, *APIError)
+}
+
+var httpEndpoints = []httpEndpoint{
+	{
+		Method:   http.MethodGet,
+		Path:     "/rooms",
+		Route:    210,
+		Summary:  "List the rooms the caller belongs to",
+		Response: ListRoomsResponse{},
+		serve: func(h *handler, r *http.Request, session *services.UserSession) (interface{}, *APIError) {
+			return h.listRooms(session.UserID)
+		},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/rooms/public",
+		Route:   211,
+		Summary: "Search public rooms by name",
+		Params: []httpParam{
+			{Name: "name", In: "query", Type: "string", Description: "Substring of the room title; empty lists every public room"},
+		},
+		Response: FindPublicRoomsResponse{},
+		serve: func(h *handler, r *http.Request, session *services.UserSession) (interface{}, *APIError) {
+			return h.findPublicRooms(session.UserID, r.URL.Query().Get("name"))
+		},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/rooms/{room_id}/songs",
+		Route:   510,
+		Summary: "List the songs of a room the caller belongs to",
+		Params: []httpParam{
+			{Name: "room_id", In: "path", Type: "string"},
+		},
+		Response: SongListResponse{},
+		serve: func(h *handler, r *http.Request, session *services.UserSession) (interface{}, *APIError) {
+			return h.listSongs(session, r.PathValue("room_id"))
+		},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/rooms/{room_id}/songs/{song_id}/snapshots",
+		Route:   631,
+		Summary: "List a song's snapshots, newest first",
+		Params: []httpParam{
+			{Name: "room_id", In: "path", Type: "string"},
+			{Name: "song_id", In: "path", Type: "string"},
+		},
+		Response: SnapshotListResponse{},
+		serve: func(h *handler, r *http.Request, session *services.UserSession) (interface{}, *APIError) {
+			return h.listSnapshots(session, r.PathValue("room_id"), r.PathValue("song_id"))
+		},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/posts",
+		Route:   710,
+		Summary: "Community feed, newest first",
+		Params: []httpParam{
+			{Name: "before_id", In: "query", Type: "string", Description: "Return posts older than this one (next page)"},
+			{Name: "limit", In: "query", Type: "integer", Description: "Page size"},
+			{Name: "include_attachment", In: "query", Type: "boolean", Description: "Include post attachments"},
+		},
+		Response: ListPostsResponse{},
+		serve: func(h *handler, r *http.Request, session *services.UserSession) (interface{}, *APIError) {
+			q := r.URL.Query()
+			lpReq := ListPostsRequest{UserID: session.UserID, BeforeID: q.Get("before_id")}
+			var err error
+			if v := q.Get("limit"); v != "" {
+				if lpReq.Limit, err = strconv.Atoi(v); err != nil {
+					return nil, invalidFields("limit must be an integer", "limit", "integer")
+				}
+			}
+			if v := q.Get("include_attachment"); v != "" {
+				if lpReq.IncludeAttachment, err = strconv.ParseBool(v); err != nil {
+					return nil, invalidFields("include_attachment must be true or false", "include_attachment", "boolean")
+				}
+			}
+			return h.listPosts(lpReq)
+		},
+	},
+}
+
+// RegisterHTTPRoutes mounts the REST gateway and its OpenAPI description
+// (GET /api/v1/openapi.json) on mux. Requests authenticate with
+// "Authorization: Bearer <Supabase JWT>" and run the same code as the
+// matching TCP routes; errors use the TCP error envelope with an HTTP status.
+func RegisterHTTPRoutes(mux *http.ServeMux, store *services.Store) {
+	h := &handler{store: store}
+	for _, ep := range httpEndpoints {
+		mux.HandleFunc(ep.Method+" "+HTTPPrefix+ep.Path, h.serveHTTP(ep))
+	}
+	mux.HandleFunc("GET "+HTTPPrefix+"/openapi.json", serveOpenAPI)
+}
+
+func (h *handler) serveHTTP(ep httpEndpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		locale := httpLocale(r)
+		userID := "-"
+		outcome := "ok"
+		defer func() {
+			log.Printf("http %s %s user=%s %s in %s", r.Method, r.URL.Path, userID, outcome, time.Since(start).Round(time.Microsecond))
+		}()
+
+		session, apiErr := authenticateHTTP(r, locale)
+		var resp interface{}
+		if apiErr == nil {
+			userID = session.UserID
+			resp, apiErr = ep.serve(h, r, session)
+		}
+		if apiErr != nil {
+			outcome = string(apiErr.Code)
+			writeHTTPError(w, locale, apiErr)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// authenticateHTTP verifies the bearer token the same way route 10 does.
+func authenticateHTTP(r *http.Request, locale string) (*services.UserSession, *APIError) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.TrimSpace(token) == "" {
+		return nil, errNotAuthenticated
+	}
+	user, err := services.VerifyToken(strings.TrimSpace(token))
+	if err != nil {
+		log.Printf("http auth failed: %v", err)
+		return nil, errAuthFailed
+	}
+	if !user.ExpiresAt.IsZero() && time.Now().After(user.ExpiresAt) {
+		return nil, errAuthFailed
+	}
+	return services.NewRequestSession(user, locale), nil
+}
+
+// httpLocale takes the first language of Accept-Language.
+func httpLocale(r *http.Request) string {
+	lang, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	return strings.TrimSpace(lang)
+}
+
+// httpStatus maps an error code to the HTTP status of the REST gateway.
+func httpStatus(code ErrorCode) int {
+	switch code {
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeValidation, CodeIncompatibleClient:
+		return http.StatusBadRequest
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeUpstreamUnavailable:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+func writeHTTPError(w http.ResponseWriter, locale string, e *APIError) {
+	if e.Code == CodeUnauthenticated {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="musick"`)
+	}
+	writeJSON(w, httpStatus(e.Code), ErrorResponse{Success: false, Message: e.localize(locale), Error: e})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("encode http response: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,254 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+const (
+	defaultInviteTTL = 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+type CreateInviteRequest struct {
+	UserID    string `json:"user_id"`
+	RoomID    string `json:"room_id"`
+	ExpiresIn int    `json:"expires_in"` // seconds; default 1 day, max 30 days
+	MaxUses   int    `json:"max_uses"`   // 0 = unlimited
+	Role      string `json:"role"`       // optional preset: editor, commenter or viewer
+}
+
+type CreateInviteResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Invite  *services.Invite `json:"invite,omitempty"`
+}
+
+type ListInvitesRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+}
+
+type ListInvitesResponse struct {
+	Success bool              `json:"success"`
+	Message string            `json:"message"`
+	Invites []services.Invite `json:"invites,omitempty"`
+}
+
+type RevokeInviteRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+	Token  string `json:"token"`
+}
+
+type RevokeInviteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type RegenerateCodeRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+}
+
+type RegenerateCodeResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+// RegisterInviteRoutes wires invite and room code management handlers.
+func RegisterInviteRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(240, h.handleCreateInvite)
+	s.AddRoute(241, h.handleListInvites)
+	s.AddRoute(242, h.handleRevokeInvite)
+	s.AddRoute(243, h.handleRegenerateCode)
+}
+
+// requireManager checks that the session user may manage members of roomID and
+// returns the error to send otherwise.
+func (h *handler) requireManager(ctx easytcp.Context, userID, roomID string) *APIError {
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != userID {
+		return errUserMismatch
+	}
+	member, err := h.store.RequireMember(session, roomID)
+	if err == nil {
+		err = member.Require(services.PermManageMembers)
+	}
+	if err != nil {
+		return accessDenied(err)
+	}
+	return nil
+}
+
+func (h *handler) handleCreateInvite(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var ciReq CreateInviteRequest
+	if err := json.Unmarshal(req.Data(), &ciReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if ciReq.UserID == "" || ciReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+	if ciReq.MaxUses < 0 || ciReq.ExpiresIn < 0 {
+		sendError(ctx, invalidFields("max_uses and expires_in must not be negative", "max_uses", ">= 0", "expires_in", ">= 0"))
+		return
+	}
+	if ciReq.Role != "" && !services.IsAssignableRole(ciReq.Role) {
+		sendError(ctx, errInvalidRole)
+		return
+	}
+
+	if apiErr := h.requireManager(ctx, ciReq.UserID, ciReq.RoomID); apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	ttl := time.Duration(ciReq.ExpiresIn) * time.Second
+	if ttl == 0 {
+		ttl = defaultInviteTTL
+	}
+	if ttl > maxInviteTTL {
+		ttl = maxInviteTTL
+	}
+
+	invite, err := h.store.Invites.CreateInvite(ciReq.RoomID, ciReq.UserID, ciReq.Role, ciReq.MaxUses, time.Now().Add(ttl))
+	if err != nil {
+		log.Printf("failed to create invite: %v", err)
+		sendError(ctx, storeFailure(err, "failed to create invite"))
+		return
+	}
+
+	resp := CreateInviteResponse{Success: true, Message: "invite created", Invite: invite}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleListInvites(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var liReq ListInvitesRequest
+	if err := json.Unmarshal(req.Data(), &liReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if liReq.UserID == "" || liReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+
+	if apiErr := h.requireManager(ctx, liReq.UserID, liReq.RoomID); apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	invites, err := h.store.Invites.ListInvites(liReq.RoomID)
+	if err != nil {
+		log.Printf("failed to list invites: %v", err)
+		sendError(ctx, storeFailure(err, "failed to list invites"))
+		return
+	}
+
+	resp := ListInvitesResponse{Success: true, Message: "ok", Invites: invites}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleRevokeInvite(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var riReq RevokeInviteRequest
+	if err := json.Unmarshal(req.Data(), &riReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if riReq.UserID == "" || riReq.RoomID == "" || riReq.Token == "" {
+		sendError(ctx, missingFields("user_id", "room_id", "token"))
+		return
+	}
+
+	if apiErr := h.requireManager(ctx, riReq.UserID, riReq.RoomID); apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	err := h.store.Invites.RevokeInvite(riReq.RoomID, riReq.Token)
+	if errors.Is(err, services.ErrNotFound) {
+		sendError(ctx, newError(CodeNotFound, "not_found", services.ErrInviteNotFound.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to revoke invite: %v", err)
+		sendError(ctx, storeFailure(err, "failed to revoke invite"))
+		return
+	}
+
+	resp := RevokeInviteResponse{Success: true, Message: "invite revoked"}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleRegenerateCode(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var rcReq RegenerateCodeRequest
+	if err := json.Unmarshal(req.Data(), &rcReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if rcReq.UserID == "" || rcReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+
+	if apiErr := h.requireManager(ctx, rcReq.UserID, rcReq.RoomID); apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	code, err := h.store.Rooms.RegenerateRoomCode(rcReq.RoomID)
+	if err != nil {
+		log.Printf("failed to regenerate room code: %v", err)
+		sendError(ctx, storeFailure(err, "failed to regenerate room code"))
+		return
+	}
+
+	resp := RegenerateCodeResponse{Success: true, Message: "room code regenerated", Code: code}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
@@ -11,19 +12,21 @@ import (
 )
 
 type JoinRoomRequest struct {
-	Code   string `json:"code"`
-	UserID string `json:"user_id"`
+	Code        string `json:"code"`
+	InviteToken string `json:"invite_token"` // alternative to code; required for private rooms
+	UserID      string `json:"user_id"`
 }
 
 type JoinRoomResponse struct {
-	Success   bool   `json:"success"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id,omitempty"`
-	Code      string `json:"code,omitempty"`
-	Title     string `json:"title,omitempty"`
-	OwnerID   string `json:"owner_id,omitempty"`
-	IsPrivate bool   `json:"is_private,omitempty"`
-	CreatedAt string `json:"created_at,omitempty"`
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	RoomID      string `json:"room_id,omitempty"`
+	Code        string `json:"code,omitempty"`
+	Title       string `json:"title,omitempty"`
+	OwnerID     string `json:"owner_id,omitempty"`
+	IsPrivate   bool   `json:"is_private,omitempty"`
+	Description string `json:"description,omitempty"`
+	CreatedAt   string `json:"created_at,omitempty"`
 }
 
 type LeaveRoomRequest struct {
@@ -36,107 +39,117 @@ type LeaveRoomResponse struct {
 	Message string `json:"message"`
 }
 
-func RegisterJoinRoomRoutes(s *easytcp.Server) {
-	s.AddRoute(202, handleJoinRoom)
-	s.AddRoute(203, handleLeaveRoom)
+func RegisterJoinRoomRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(202, h.handleJoinRoom)
+	s.AddRoute(203, h.handleLeaveRoom)
 }
 
-func handleJoinRoom(ctx easytcp.Context) {
+func (h *handler) handleJoinRoom(ctx easytcp.Context) {
 	req := ctx.Request()
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendJoinRoomError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var jr JoinRoomRequest
 	if err := json.Unmarshal(req.Data(), &jr); err != nil {
-		sendJoinRoomError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
-	if jr.Code == "" || jr.UserID == "" {
-		sendJoinRoomError(ctx, "code and user_id are required")
+	if (jr.Code == "" && jr.InviteToken == "") || jr.UserID == "" {
+		sendError(ctx, invalidFields("code or invite_token, and user_id are required", "code", "required without invite_token", "user_id", "required"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != jr.UserID {
-		sendJoinRoomError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	room, err := services.JoinRoomByCode(jr.Code, jr.UserID)
+	var room *services.Room
+	var err error
+	if jr.InviteToken != "" {
+		room, err = h.store.Invites.RedeemInvite(jr.InviteToken, jr.UserID)
+	} else {
+		room, err = h.store.Rooms.JoinRoomByCode(jr.Code, jr.UserID)
+	}
+	switch {
+	case errors.Is(err, services.ErrBanned),
+		errors.Is(err, services.ErrInviteRequired),
+		errors.Is(err, services.ErrInviteExpired),
+		errors.Is(err, services.ErrInviteUsedUp):
+		sendError(ctx, newError(CodeForbidden, "join_refused", err.Error()))
+		return
+	case errors.Is(err, services.ErrInviteNotFound),
+		errors.Is(err, services.ErrNotFound):
+		sendError(ctx, newError(CodeNotFound, "not_found", "room or invite not found"))
+		return
+	}
 	if err != nil {
 		log.Printf("failed to join room: %v", err)
-		sendJoinRoomError(ctx, "failed to join room")
+		sendError(ctx, storeFailure(err, "failed to join room"))
 		return
 	}
 
 	// Track membership for broadcasts
+	services.InvalidateMembership(jr.UserID, room.ID)
 	services.AddSessionToRoom(room.ID, ctx.Session())
 
 	resp := JoinRoomResponse{
-		Success:   true,
-		Message:   "joined room",
-		RoomID:    room.ID,
-		Code:      room.Code,
-		Title:     room.Title,
-		OwnerID:   room.OwnerID,
-		IsPrivate: room.IsPrivate,
-		CreatedAt: room.CreatedAt.Format(time.RFC3339),
+		Success:     true,
+		Message:     "joined room",
+		RoomID:      room.ID,
+		Code:        room.Code,
+		Title:       room.Title,
+		OwnerID:     room.OwnerID,
+		IsPrivate:   room.IsPrivate,
+		Description: room.Description,
+		CreatedAt:   room.CreatedAt.Format(time.RFC3339),
 	}
 
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendJoinRoomError(ctx easytcp.Context, msg string) {
-	resp := JoinRoomResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func handleLeaveRoom(ctx easytcp.Context) {
+func (h *handler) handleLeaveRoom(ctx easytcp.Context) {
 	req := ctx.Request()
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendLeaveRoomError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var lr LeaveRoomRequest
 	if err := json.Unmarshal(req.Data(), &lr); err != nil {
-		sendLeaveRoomError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if lr.RoomID == "" || lr.UserID == "" {
-		sendLeaveRoomError(ctx, "room_id and user_id are required")
+		sendError(ctx, missingFields("room_id", "user_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != lr.UserID {
-		sendLeaveRoomError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	if err := services.LeaveRoom(lr.RoomID, lr.UserID); err != nil {
+	if err := h.store.Rooms.LeaveRoom(lr.RoomID, lr.UserID); err != nil {
 		log.Printf("failed to leave room: %v", err)
-		sendLeaveRoomError(ctx, "failed to leave room")
+		sendError(ctx, storeFailure(err, "failed to leave room"))
 		return
 	}
 
 	services.RemoveSessionFromRoom(lr.RoomID, ctx.Session())
+	services.InvalidateMembership(lr.UserID, lr.RoomID)
 
 	resp := LeaveRoomResponse{Success: true, Message: "left room"}
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
-
-func sendLeaveRoomError(ctx easytcp.Context, msg string) {
-	resp := LeaveRoomResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
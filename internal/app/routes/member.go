@@ -0,0 +1,395 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+type ListMembersRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+}
+
+type ListMembersResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message"`
+	Members []services.RoomMember `json:"members,omitempty"`
+}
+
+type SetRoleRequest struct {
+	UserID       string `json:"user_id"`
+	RoomID       string `json:"room_id"`
+	TargetUserID string `json:"target_user_id"`
+	Role         string `json:"role"` // editor, commenter or viewer
+}
+
+type SetRoleResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	Member  *services.RoomMember `json:"member,omitempty"`
+}
+
+// MemberActionRequest is shared by kick (222), ban (223), unban (224) and transfer ownership (225).
+type MemberActionRequest struct {
+	UserID       string `json:"user_id"`
+	RoomID       string `json:"room_id"`
+	TargetUserID string `json:"target_user_id"`
+}
+
+type MemberActionResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// MemberEvent is the payload for route 221 membership broadcasts.
+type MemberEvent struct {
+	Event     string `json:"event"` // "role_changed", "kicked", "banned", "owner_changed"
+	RoomID    string `json:"room_id"`
+	AccountID string `json:"account_id"`
+	Role      string `json:"role,omitempty"`
+	By        string `json:"by"`
+}
+
+// RemovedNotice is pushed on route 229 to every session of a kicked or banned user.
+type RemovedNotice struct {
+	Event  string `json:"event"` // "kicked" or "banned"
+	RoomID string `json:"room_id"`
+	By     string `json:"by"`
+}
+
+// RegisterMemberRoutes wires room membership management handlers.
+func RegisterMemberRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(212, h.handleListMembers)
+	s.AddRoute(220, h.handleSetRole)
+	s.AddRoute(222, h.handleKickMember)
+	s.AddRoute(223, h.handleBanMember)
+	s.AddRoute(224, h.handleUnbanMember)
+	s.AddRoute(225, h.handleTransferOwnership)
+}
+
+func (h *handler) handleListMembers(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var lmReq ListMembersRequest
+	if err := json.Unmarshal(req.Data(), &lmReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if lmReq.UserID == "" || lmReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != lmReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	if _, err := h.store.RequireMember(session, lmReq.RoomID); err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	members, err := h.store.Rooms.ListMembers(lmReq.RoomID)
+	if err != nil {
+		log.Printf("failed to list members: %v", err)
+		sendError(ctx, storeFailure(err, "failed to list members"))
+		return
+	}
+
+	resp := ListMembersResponse{Success: true, Message: "ok", Members: members}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleSetRole(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var srReq SetRoleRequest
+	if err := json.Unmarshal(req.Data(), &srReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if srReq.UserID == "" || srReq.RoomID == "" || srReq.TargetUserID == "" || srReq.Role == "" {
+		sendError(ctx, missingFields("user_id", "room_id", "target_user_id", "role"))
+		return
+	}
+	if !services.IsAssignableRole(srReq.Role) {
+		sendError(ctx, errInvalidRole)
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != srReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireMember(session, srReq.RoomID)
+	if err == nil {
+		err = member.Require(services.PermManageMembers)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	if srReq.TargetUserID == srReq.UserID {
+		sendError(ctx, newError(CodeForbidden, "forbidden_target", "cannot change your own role"))
+		return
+	}
+
+	target, err := h.store.Rooms.GetMembership(srReq.RoomID, srReq.TargetUserID)
+	if errors.Is(err, services.ErrNotFound) {
+		sendError(ctx, errTargetNotMember)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch membership: %v", err)
+		sendError(ctx, storeFailure(err, "failed to update role"))
+		return
+	}
+	if target.Role == services.RoleOwner {
+		sendError(ctx, newError(CodeForbidden, "forbidden_target", "cannot change the owner's role"))
+		return
+	}
+
+	if err := h.store.Rooms.SetMemberRole(srReq.RoomID, srReq.TargetUserID, srReq.Role); err != nil {
+		log.Printf("failed to set role: %v", err)
+		sendError(ctx, storeFailure(err, "failed to update role"))
+		return
+	}
+	services.InvalidateMembership(srReq.TargetUserID, srReq.RoomID)
+
+	target.Role = srReq.Role
+	resp := SetRoleResponse{Success: true, Message: "role updated", Member: target}
+	data, _ := json.Marshal(resp)
+
+	// Tell everyone in the room (including the target's sessions) on route 221.
+	event := MemberEvent{
+		Event:     "role_changed",
+		RoomID:    srReq.RoomID,
+		AccountID: srReq.TargetUserID,
+		Role:      srReq.Role,
+		By:        srReq.UserID,
+	}
+	if b, err := json.Marshal(event); err == nil {
+		services.PublishToRoom(srReq.RoomID, easytcp.NewMessage(221, b))
+	}
+
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// parseMemberAction decodes a moderation request and checks that the caller may
+// manage members of the room and is not targeting themselves. On failure it
+// returns a client-facing message.
+func (h *handler) parseMemberAction(ctx easytcp.Context) (*MemberActionRequest, *APIError) {
+	if !services.IsAuthenticated(ctx.Session()) {
+		return nil, errNotAuthenticated
+	}
+
+	var maReq MemberActionRequest
+	if err := json.Unmarshal(ctx.Request().Data(), &maReq); err != nil {
+		return nil, errInvalidFormat
+	}
+
+	if maReq.UserID == "" || maReq.RoomID == "" || maReq.TargetUserID == "" {
+		return nil, missingFields("user_id", "room_id", "target_user_id")
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != maReq.UserID {
+		return nil, errUserMismatch
+	}
+
+	member, err := h.store.RequireMember(session, maReq.RoomID)
+	if err == nil {
+		err = member.Require(services.PermManageMembers)
+	}
+	if err != nil {
+		return nil, accessDenied(err)
+	}
+
+	if maReq.TargetUserID == maReq.UserID {
+		return nil, invalidFields("cannot target yourself", "target_user_id", "must not be yourself")
+	}
+
+	return &maReq, nil
+}
+
+// evictMember drops the target's live sessions from the room, tells them why on
+// route 229 and tells the remaining members on route 221.
+func evictMember(event string, maReq *MemberActionRequest) {
+	services.RemoveUserFromRoom(maReq.RoomID, maReq.TargetUserID)
+
+	notice := RemovedNotice{Event: event, RoomID: maReq.RoomID, By: maReq.UserID}
+	if b, err := json.Marshal(notice); err == nil {
+		for _, sess := range services.SessionsForUser(maReq.TargetUserID) {
+			services.SendToSession(sess, easytcp.NewMessage(229, b))
+		}
+	}
+
+	bcast := MemberEvent{Event: event, RoomID: maReq.RoomID, AccountID: maReq.TargetUserID, By: maReq.UserID}
+	if b, err := json.Marshal(bcast); err == nil {
+		services.PublishToRoom(maReq.RoomID, easytcp.NewMessage(221, b))
+	}
+}
+
+func (h *handler) handleKickMember(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	maReq, apiErr := h.parseMemberAction(ctx)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	target, err := h.store.Rooms.GetMembership(maReq.RoomID, maReq.TargetUserID)
+	if errors.Is(err, services.ErrNotFound) {
+		sendError(ctx, errTargetNotMember)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch membership: %v", err)
+		sendError(ctx, storeFailure(err, "failed to kick member"))
+		return
+	}
+	if target.Role == services.RoleOwner {
+		sendError(ctx, newError(CodeForbidden, "forbidden_target", "cannot kick the owner"))
+		return
+	}
+
+	if err := h.store.Rooms.LeaveRoom(maReq.RoomID, maReq.TargetUserID); err != nil {
+		log.Printf("failed to kick member: %v", err)
+		sendError(ctx, storeFailure(err, "failed to kick member"))
+		return
+	}
+
+	evictMember("kicked", maReq)
+
+	resp := MemberActionResponse{Success: true, Message: "member kicked"}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleBanMember(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	maReq, apiErr := h.parseMemberAction(ctx)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	// Non-members can be banned too, so only the owner check needs the membership row.
+	target, err := h.store.Rooms.GetMembership(maReq.RoomID, maReq.TargetUserID)
+	if err != nil && !errors.Is(err, services.ErrNotFound) {
+		log.Printf("failed to fetch membership: %v", err)
+		sendError(ctx, storeFailure(err, "failed to ban user"))
+		return
+	}
+	if target != nil && target.Role == services.RoleOwner {
+		sendError(ctx, newError(CodeForbidden, "forbidden_target", "cannot ban the owner"))
+		return
+	}
+
+	if err := h.store.Rooms.BanMember(maReq.RoomID, maReq.TargetUserID, maReq.UserID); err != nil {
+		log.Printf("failed to ban user: %v", err)
+		sendError(ctx, storeFailure(err, "failed to ban user"))
+		return
+	}
+
+	evictMember("banned", maReq)
+
+	resp := MemberActionResponse{Success: true, Message: "user banned"}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleUnbanMember(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	maReq, apiErr := h.parseMemberAction(ctx)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	if err := h.store.Rooms.UnbanMember(maReq.RoomID, maReq.TargetUserID); err != nil {
+		log.Printf("failed to unban user: %v", err)
+		sendError(ctx, storeFailure(err, "failed to unban user"))
+		return
+	}
+
+	resp := MemberActionResponse{Success: true, Message: "user unbanned"}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleTransferOwnership(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	maReq, apiErr := h.parseMemberAction(ctx)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	if _, err := h.store.Rooms.GetMembership(maReq.RoomID, maReq.TargetUserID); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			sendError(ctx, errTargetNotMember)
+			return
+		}
+		log.Printf("failed to fetch membership: %v", err)
+		sendError(ctx, storeFailure(err, "failed to transfer ownership"))
+		return
+	}
+
+	err := h.store.Rooms.TransferOwnership(maReq.RoomID, maReq.UserID, maReq.TargetUserID)
+	services.InvalidateMembership(maReq.UserID, maReq.RoomID)
+	services.InvalidateMembership(maReq.TargetUserID, maReq.RoomID)
+	if errors.Is(err, services.ErrNotFound) {
+		sendError(ctx, newError(CodeConflict, "conflict", "you no longer own this room"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to transfer ownership: %v", err)
+		sendError(ctx, storeFailure(err, "failed to transfer ownership"))
+		return
+	}
+
+	resp := MemberActionResponse{Success: true, Message: "ownership transferred"}
+	data, _ := json.Marshal(resp)
+
+	event := MemberEvent{
+		Event:     "owner_changed",
+		RoomID:    maReq.RoomID,
+		AccountID: maReq.TargetUserID,
+		Role:      services.RoleOwner,
+		By:        maReq.UserID,
+	}
+	if b, err := json.Marshal(event); err == nil {
+		services.PublishToRoom(maReq.RoomID, easytcp.NewMessage(221, b))
+	}
+
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
@@ -54,42 +54,52 @@ type FetchedMessage struct {
 	CreatedAt  string `json:"created_at"`
 }
 
-func RegisterMessageRoutes(s *easytcp.Server) {
-	s.AddRoute(301, handleSendMessage)
-	s.AddRoute(310, handleFetchMessages)
+func RegisterMessageRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(301, h.handleSendMessage)
+	s.AddRoute(310, h.handleFetchMessages)
 }
 
-func handleSendMessage(ctx easytcp.Context) {
+func (h *handler) handleSendMessage(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("301 send message: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendMessageError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var msgReq SendMessageRequest
 	if err := json.Unmarshal(req.Data(), &msgReq); err != nil {
-		sendMessageError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if msgReq.UserID == "" || msgReq.RoomID == "" || msgReq.Body == "" {
-		sendMessageError(ctx, "user_id, room_id, and body are required")
+		sendError(ctx, missingFields("user_id", "room_id", "body"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != msgReq.UserID {
-		sendMessageError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	saved, err := services.CreateMessage(msgReq.RoomID, msgReq.UserID, session.UserName, msgReq.Body)
+	member, err := h.store.RequireMember(session, msgReq.RoomID)
+	if err == nil {
+		err = member.Require(services.PermChat)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	saved, err := h.store.Messages.CreateMessage(msgReq.RoomID, msgReq.UserID, session.UserName, msgReq.Body)
 
 	if err != nil {
 		log.Printf("failed to send message: %v", err)
-		sendMessageError(ctx, "failed to send message")
+		sendError(ctx, storeFailure(err, "failed to send message"))
 		return
 	}
 
@@ -110,7 +120,7 @@ func handleSendMessage(ctx easytcp.Context) {
 		SentAt:     saved.SentAt.Format(time.RFC3339),
 	}
 	if b, err := json.Marshal(broadcast); err == nil {
-		services.BroadcastToRoom(msgReq.RoomID, easytcp.NewMessage(302, b), nil)
+		services.PublishToRoom(msgReq.RoomID, easytcp.NewMessage(302, b))
 	}
 
 	resp := SendMessageResponse{
@@ -128,29 +138,34 @@ func handleSendMessage(ctx easytcp.Context) {
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func handleFetchMessages(ctx easytcp.Context) {
+func (h *handler) handleFetchMessages(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("310 fetch messages: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendFetchMessagesError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var fmReq FetchMessagesRequest
 	if err := json.Unmarshal(req.Data(), &fmReq); err != nil {
-		sendFetchMessagesError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if fmReq.RoomID == "" || fmReq.UserID == "" {
-		sendFetchMessagesError(ctx, "room_id and user_id are required")
+		sendError(ctx, missingFields("room_id", "user_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != fmReq.UserID {
-		sendFetchMessagesError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	if _, err := h.store.RequireMember(session, fmReq.RoomID); err != nil {
+		sendError(ctx, accessDenied(err))
 		return
 	}
 
@@ -161,10 +176,10 @@ func handleFetchMessages(ctx easytcp.Context) {
 		fmReq.Limit = 50
 	}
 
-	msgs, hasMore, err := services.ListMessages(fmReq.RoomID, fmReq.BeforeID, fmReq.Limit, fmReq.IncludeSystem)
+	msgs, hasMore, err := h.store.Messages.ListMessages(fmReq.RoomID, fmReq.BeforeID, fmReq.Limit, fmReq.IncludeSystem)
 	if err != nil {
 		log.Printf("failed to fetch messages: %v", err)
-		sendFetchMessagesError(ctx, "failed to fetch messages")
+		sendError(ctx, storeFailure(err, "failed to fetch messages"))
 		return
 	}
 
@@ -196,15 +211,3 @@ func handleFetchMessages(ctx easytcp.Context) {
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
-
-func sendFetchMessagesError(ctx easytcp.Context, msg string) {
-	resp := FetchMessagesResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func sendMessageError(ctx easytcp.Context, msg string) {
-	resp := SendMessageResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
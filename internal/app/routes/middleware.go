@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+const (
+	// ctxRequestID holds the raw JSON request_id of the current request.
+	ctxRequestID = "request_id"
+	// ctxErrorCode is set by sendError so the request log can show the outcome.
+	ctxErrorCode = "error_code"
+
+	maxRequestIDLen = 128
+)
+
+// RequestIDMiddleware echoes an optional client-supplied "request_id" (string
+// or number) into the JSON response of any route for connections that
+// negotiated the request_id feature, so pipelined requests on the same route
+// can be matched to their answers, and logs one line per tagged request for
+// tracing.
+func RequestIDMiddleware(next easytcp.HandlerFunc) easytcp.HandlerFunc {
+	return func(ctx easytcp.Context) {
+		rid := peekRequestID(ctx.Request().Data())
+		if rid == nil {
+			next(ctx)
+			return
+		}
+
+		ctx.Set(ctxRequestID, rid)
+		start := time.Now()
+		next(ctx)
+
+		// Checked after the handler so a handshake that enables the feature
+		// already gets its answer tagged.
+		echo := services.ProtocolFor(ctx.Session()).Has(services.FeatureRequestID)
+		if resp := ctx.Response(); resp != nil && echo {
+			ctx.SetResponseMessage(easytcp.NewMessage(resp.ID(), stampRequestID(resp.Data(), rid)))
+		}
+
+		outcome := "ok"
+		if code, ok := ctx.Get(ctxErrorCode); ok {
+			outcome = string(code.(ErrorCode))
+		}
+		userID := "-"
+		if session := services.GetSession(ctx.Session()); session != nil {
+			userID = session.UserID
+		}
+		log.Printf("route %v request_id=%s user=%s %s in %s", ctx.Request().ID(), rid, userID, outcome, time.Since(start).Round(time.Microsecond))
+	}
+}
+
+// HeartbeatMiddleware counts every inbound message as a sign of life for the
+// idle and login-timeout reaper (services.WatchConnections).
+func HeartbeatMiddleware(next easytcp.HandlerFunc) easytcp.HandlerFunc {
+	return func(ctx easytcp.Context) {
+		next(ctx)
+		services.TouchConnection(ctx.Session())
+	}
+}
+
+// CodecMiddleware translates requests and responses between the codec a
+// connection negotiated on route 2 (MessagePack or Protobuf) and the JSON
+// every handler works with. The codec is read before the handler runs, so the
+// handshake's own response still goes out as JSON.
+func CodecMiddleware(next easytcp.HandlerFunc) easytcp.HandlerFunc {
+	return func(ctx easytcp.Context) {
+		codec := services.ProtocolFor(ctx.Session()).Codec
+		if codec == services.CodecJSON {
+			next(ctx)
+			return
+		}
+
+		req := ctx.Request()
+		data, err := services.DecodeRequest(codec, req.ID(), req.Data())
+		if err != nil {
+			log.Printf("route %v: %v", req.ID(), err)
+			sendError(ctx, errInvalidFormat)
+		} else {
+			ctx.SetRequestMessage(easytcp.NewMessage(req.ID(), data))
+			next(ctx)
+		}
+
+		resp := ctx.Response()
+		if resp == nil {
+			return
+		}
+		data, err = services.EncodePayload(codec, resp.ID(), resp.Data())
+		if err != nil {
+			log.Printf("route %v: encode %s response: %v", resp.ID(), codec, err)
+			sendError(ctx, newError(CodeInternal, "internal", "failed to encode response"))
+			resp = ctx.Response()
+			if data, err = services.EncodePayload(codec, resp.ID(), resp.Data()); err != nil {
+				ctx.SetResponseMessage(nil)
+				return
+			}
+		}
+		ctx.SetResponseMessage(easytcp.NewMessage(resp.ID(), data))
+	}
+}
+
+// HandshakeMiddleware turns away connections that skipped the route 2
+// handshake once MIN_PROTOCOL_VERSION no longer admits legacy clients.
+func HandshakeMiddleware(next easytcp.HandlerFunc) easytcp.HandlerFunc {
+	return func(ctx easytcp.Context) {
+		if ctx.Request().ID() != 2 && services.HandshakeRequired(ctx.Session()) {
+			sendError(ctx, newError(CodeIncompatibleClient, "handshake_required", "send a protocol handshake (route 2) first; this client is too old"))
+			return
+		}
+		next(ctx)
+	}
+}
+
+// peekRequestID returns the request_id of a JSON object payload, or nil when
+// there is none or it isn't a short string or a number.
+func peekRequestID(data []byte) json.RawMessage {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' || !bytes.Contains(data, []byte(`"request_id"`)) {
+		return nil
+	}
+	var probe struct {
+		RequestID json.RawMessage `json:"request_id"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil
+	}
+	rid := probe.RequestID
+	if len(rid) == 0 || len(rid) > maxRequestIDLen {
+		return nil
+	}
+	switch c := rid[0]; {
+	case c == '"', c == '-', c >= '0' && c <= '9':
+		return rid
+	}
+	return nil
+}
+
+// stampRequestID adds "request_id" as the first field of a JSON object payload
+// unless the handler already echoed one (route 1 returns the request as is).
+func stampRequestID(data []byte, rid json.RawMessage) []byte {
+	if len(data) < 2 || data[0] != '{' || peekRequestID(data) != nil {
+		return data
+	}
+	out := make([]byte, 0, len(data)+len(rid)+16)
+	out = append(out, `{"request_id":`...)
+	out = append(out, rid...)
+	if data[1] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...)
+}
@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// stubSession is just enough of an easytcp.Session to carry a negotiated
+// protocol through middleware.
+type stubSession struct{ id string }
+
+func (s *stubSession) ID() interface{}                  { return s.id }
+func (s *stubSession) SetID(interface{})                {}
+func (s *stubSession) Send(easytcp.Context) bool        { return false }
+func (s *stubSession) Codec() easytcp.Codec             { return nil }
+func (s *stubSession) Close()                           {}
+func (s *stubSession) AfterCreateHook() <-chan struct{} { return nil }
+func (s *stubSession) AfterCloseHook() <-chan struct{}  { return nil }
+func (s *stubSession) AllocateContext() easytcp.Context { return easytcp.NewContext().SetSession(s) }
+func (s *stubSession) Conn() net.Conn                   { return nil }
+
+// codecContext returns a request context on a session that negotiated codec
+// with error codes.
+func codecContext(t *testing.T, codec string, routeID int, data []byte) easytcp.Context {
+	t.Helper()
+	if err := services.LoadProtoSchemas(); err != nil {
+		t.Fatalf("LoadProtoSchemas: %v", err)
+	}
+	sess := &stubSession{id: t.Name()}
+	if _, err := services.NegotiateProtocol(sess, 2, 2, []string{codec}, []string{services.FeatureErrorCodes}); err != nil {
+		t.Fatalf("negotiate protocol: %v", err)
+	}
+	t.Cleanup(func() { services.ForgetProtocol(sess) })
+	return easytcp.NewContext().SetSession(sess).SetRequestMessage(easytcp.NewMessage(routeID, data))
+}
+
+// protoFields splits a protobuf message into its raw field values by number;
+// nested messages and strings come back as their bytes.
+func protoFields(t *testing.T, data []byte) map[protowire.Number][]byte {
+	t.Helper()
+	fields := make(map[protowire.Number][]byte)
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatalf("bad protobuf tag: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				t.Fatalf("bad protobuf field %d: %v", num, protowire.ParseError(m))
+			}
+			fields[num], n = v, m
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(data)
+			if m < 0 {
+				t.Fatalf("bad protobuf field %d: %v", num, protowire.ParseError(m))
+			}
+			fields[num], n = protowire.AppendVarint(nil, v), m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		data = data[n:]
+	}
+	return fields
+}
+
+func TestCodecMiddlewareMsgpack(t *testing.T) {
+	req, _ := msgpack.Marshal(map[string]interface{}{"room_id": "r1", "limit": 5})
+	ctx := codecContext(t, services.CodecMsgpack, 303, req)
+
+	CodecMiddleware(func(ctx easytcp.Context) {
+		var got map[string]interface{}
+		if err := json.Unmarshal(ctx.Request().Data(), &got); err != nil {
+			t.Fatalf("handler got non-JSON request %q: %v", ctx.Request().Data(), err)
+		}
+		if want := map[string]interface{}{"room_id": "r1", "limit": 5.0}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("handler got %v, want %v", got, want)
+		}
+		ctx.SetResponseMessage(easytcp.NewMessage(303, []byte(`{"success":true,"count":2}`)))
+	})(ctx)
+
+	var resp map[string]interface{}
+	if err := msgpack.Unmarshal(ctx.Response().Data(), &resp); err != nil {
+		t.Fatalf("response is not msgpack: %v", err)
+	}
+	if count := reflect.ValueOf(resp["count"]); resp["success"] != true || !count.CanInt() || count.Int() != 2 {
+		t.Fatalf("response = %v, want success and integer count 2", resp)
+	}
+}
+
+func TestCodecMiddlewareProtobuf(t *testing.T) {
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendString(req, "token-1")
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendString(req, "zh")
+	ctx := codecContext(t, services.CodecProtobuf, 10, req)
+
+	CodecMiddleware(func(ctx easytcp.Context) {
+		var got map[string]interface{}
+		if err := json.Unmarshal(ctx.Request().Data(), &got); err != nil {
+			t.Fatalf("handler got non-JSON request %q: %v", ctx.Request().Data(), err)
+		}
+		if want := map[string]interface{}{"token": "token-1", "locale": "zh"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("handler got %v, want %v", got, want)
+		}
+		ctx.SetResponseMessage(easytcp.NewMessage(10, []byte(`{"success":true,"user_id":"u1","resume_window":120}`)))
+	})(ctx)
+
+	fields := protoFields(t, ctx.Response().Data())
+	if string(fields[1]) != "\x01" || string(fields[4]) != "u1" || string(fields[8]) != "\x78" {
+		t.Fatalf("LoginResponse fields = %q, want success, user_id u1, resume_window 120", fields)
+	}
+}
+
+func TestCodecMiddlewareErrors(t *testing.T) {
+	t.Run("undecodable request", func(t *testing.T) {
+		ctx := codecContext(t, services.CodecMsgpack, 303, []byte{0xc1})
+		CodecMiddleware(func(easytcp.Context) { t.Fatal("handler ran on an undecodable request") })(ctx)
+
+		var resp struct {
+			Success bool `msgpack:"success"`
+			Error   struct {
+				Code ErrorCode `msgpack:"code"`
+			} `msgpack:"error"`
+		}
+		if err := msgpack.Unmarshal(ctx.Response().Data(), &resp); err != nil {
+			t.Fatalf("error response is not msgpack: %v", err)
+		}
+		if resp.Success || resp.Error.Code != CodeValidation {
+			t.Fatalf("response = %+v, want a VALIDATION error", resp)
+		}
+	})
+
+	t.Run("unencodable response", func(t *testing.T) {
+		ctx := codecContext(t, services.CodecProtobuf, 10, nil)
+		CodecMiddleware(func(ctx easytcp.Context) {
+			ctx.SetResponseMessage(easytcp.NewMessage(10, []byte(`{"success":true,"not_in_schema":1}`)))
+		})(ctx)
+
+		fields := protoFields(t, ctx.Response().Data())
+		if _, ok := fields[1]; ok {
+			t.Fatalf("response still reports success: %q", fields)
+		}
+		if errFields := protoFields(t, fields[3]); string(errFields[1]) != string(CodeInternal) {
+			t.Fatalf("error code = %q, want %s", errFields[1], CodeInternal)
+		}
+	})
+}
+
+func TestCodecMiddlewareJSONPassThrough(t *testing.T) {
+	ctx := codecContext(t, services.CodecJSON, 303, []byte(`{"room_id":"r1"}`))
+	CodecMiddleware(func(ctx easytcp.Context) {
+		ctx.SetResponseMessage(easytcp.NewMessage(303, ctx.Request().Data()))
+	})(ctx)
+	if got := string(ctx.Response().Data()); got != `{"room_id":"r1"}` {
+		t.Fatalf("response = %s, want the request echoed unchanged", got)
+	}
+}
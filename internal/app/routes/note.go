@@ -2,6 +2,8 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 
 	"musick-server/internal/app/services"
@@ -9,6 +11,9 @@ import (
 	"github.com/DarthPestilane/easytcp"
 )
 
+// maxNoteBatchOps caps the operations in one route 608 request.
+const maxNoteBatchOps = 512
+
 type CreateNoteRequest struct {
 	UserID      string `json:"user_id"`
 	RoomID      string `json:"room_id"`
@@ -33,6 +38,8 @@ type DeleteNoteRequest struct {
 	TrackID string `json:"track_id"`
 	Step    int    `json:"step"`
 	Pitch   int    `json:"pitch"`
+	// ExpectedVersion, when set, deletes the note only at that version.
+	ExpectedVersion int `json:"expected_version,omitempty"`
 }
 
 type DeleteNoteResponse struct {
@@ -40,6 +47,38 @@ type DeleteNoteResponse struct {
 	Message string `json:"message"`
 }
 
+// UpdateNoteRequest patches a note by ID; only the fields sent change.
+type UpdateNoteRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+	SongID string `json:"song_id"`
+	NoteID string `json:"note_id"`
+	// ExpectedVersion, when set, updates the note only at that version.
+	ExpectedVersion int `json:"expected_version,omitempty"`
+	services.NotePatch
+}
+
+type UpdateNoteResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Note    *services.Note `json:"note,omitempty"`
+}
+
+// NoteBatchRequest applies create/update/delete ops to one song, in order
+// and all or nothing.
+type NoteBatchRequest struct {
+	UserID string            `json:"user_id"`
+	RoomID string            `json:"room_id"`
+	SongID string            `json:"song_id"`
+	Ops    []services.NoteOp `json:"ops"`
+}
+
+type NoteBatchResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message"`
+	Changes []services.NoteChange `json:"changes,omitempty"`
+}
+
 type ListNotesRequest struct {
 	UserID  string `json:"user_id"`
 	RoomID  string `json:"room_id"`
@@ -54,60 +93,107 @@ type ListNotesResponse struct {
 	Tracks  []services.Track `json:"tracks,omitempty"`
 }
 
+// NoteConflictResponse answers a note write that lost a race (CONFLICT) with
+// the authoritative state of what it touched: Current is the note there now,
+// null when the cell is empty or the note is gone. note_id or
+// track_id/step/pitch say which note or cell that is.
+type NoteConflictResponse struct {
+	ErrorResponse
+	SongID  string         `json:"song_id"`
+	NoteID  string         `json:"note_id,omitempty"`
+	TrackID string         `json:"track_id,omitempty"`
+	Step    *int           `json:"step,omitempty"`
+	Pitch   *int           `json:"pitch,omitempty"`
+	Current *services.Note `json:"current"`
+}
+
+var (
+	errNotePositionTaken = newError(CodeConflict, "note_position_taken", "another note already occupies that position")
+	errNoteChanged       = newError(CodeConflict, "note_changed", "the note was changed by someone else")
+)
+
 // NoteBroadcast is the unified payload for route 603 broadcasts.
 type NoteBroadcast struct {
-	Action  string         `json:"action"` // "on" for create, "off" for delete
+	Action  string         `json:"action"` // "on" for create, "off" for delete, "update" for 607, "batch" for 608
 	SongID  string         `json:"song_id"`
 	TrackID string         `json:"track_id"`
 	Step    int            `json:"step"`
 	Pitch   int            `json:"pitch"`
 	Note    *services.Note `json:"note,omitempty"`
+	// Before is the note as it was, on "update"; the other fields describe
+	// it afterwards.
+	Before *services.Note `json:"before,omitempty"`
+	// Changes lists every change of a "batch", in order.
+	Changes []services.NoteChange `json:"changes,omitempty"`
 }
 
 // RegisterNoteRoutes wires note-related handlers.
-func RegisterNoteRoutes(s *easytcp.Server) {
-	s.AddRoute(601, handleCreateNote)
-	s.AddRoute(602, handleDeleteNote)
-	s.AddRoute(610, handleListNotes)
+func RegisterNoteRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(601, h.handleCreateNote)
+	s.AddRoute(602, h.handleDeleteNote)
+	s.AddRoute(607, h.handleUpdateNote)
+	s.AddRoute(608, h.handleNoteBatch)
+	s.AddRoute(610, h.handleListNotes)
 }
 
-func handleCreateNote(ctx easytcp.Context) {
+func (h *handler) handleCreateNote(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("601 create note: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendNoteCreateError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var createReq CreateNoteRequest
 	if err := json.Unmarshal(req.Data(), &createReq); err != nil {
-		sendNoteCreateError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if createReq.UserID == "" || createReq.RoomID == "" || createReq.SongID == "" || createReq.TrackID == "" {
-		sendNoteCreateError(ctx, "user_id, room_id, song_id, and track_id are required")
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "track_id"))
 		return
 	}
 	if createReq.Step < 0 || createReq.Pitch <= 0 {
-		sendNoteCreateError(ctx, "step must be >= 0 and pitch must be > 0")
+		sendError(ctx, errInvalidNotePosition)
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != createReq.UserID {
-		sendNoteCreateError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireTrack(session, createReq.RoomID, createReq.SongID, createReq.TrackID)
+	if err == nil {
+		err = member.Require(services.PermEditNotes)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
 		return
 	}
 
-	note, err := services.CreateNote(createReq.SongID, createReq.TrackID, createReq.Step, createReq.Pitch, createReq.Velocity, createReq.LengthSteps, createReq.UserID)
+	note, err := h.store.Notes.CreateNote(createReq.SongID, createReq.TrackID, createReq.Step, createReq.Pitch, createReq.Velocity, createReq.LengthSteps, createReq.UserID)
+	if errors.Is(err, services.ErrConflict) {
+		// Someone else got to this cell first; hand back their note.
+		h.sendCellConflict(ctx, errNotePositionTaken, createReq.SongID, createReq.TrackID, createReq.Step, createReq.Pitch)
+		return
+	}
 	if err != nil {
 		log.Printf("failed to create note: %v", err)
-		sendNoteCreateError(ctx, "failed to create note")
+		sendError(ctx, storeFailure(err, "failed to create note"))
 		return
 	}
 
+	services.RecordEdit(createReq.RoomID, createReq.UserID, services.Edit{
+		Kind:   services.EditNotes,
+		SongID: createReq.SongID,
+		Notes:  []services.NoteChange{{Op: services.NoteOpCreate, Note: note}},
+	})
+
 	// Ensure this session is tracked in the room for broadcasts.
 	services.AddSessionToRoom(createReq.RoomID, ctx.Session())
 
@@ -129,55 +215,77 @@ func handleCreateNote(ctx easytcp.Context) {
 		Note:    note,
 	}
 	if b, err := json.Marshal(bcast); err == nil {
-		services.BroadcastToRoom(createReq.RoomID, easytcp.NewMessage(603, b), nil)
+		services.PublishToRoom(createReq.RoomID, easytcp.NewMessage(603, b))
 	}
 
 	// Send direct response on 601.
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendNoteCreateError(ctx easytcp.Context, msg string) {
-	resp := CreateNoteResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func handleDeleteNote(ctx easytcp.Context) {
+func (h *handler) handleDeleteNote(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("602 delete note: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendNoteDeleteError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var delReq DeleteNoteRequest
 	if err := json.Unmarshal(req.Data(), &delReq); err != nil {
-		sendNoteDeleteError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if delReq.UserID == "" || delReq.RoomID == "" || delReq.SongID == "" || delReq.TrackID == "" {
-		sendNoteDeleteError(ctx, "user_id, room_id, song_id, and track_id are required")
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "track_id"))
 		return
 	}
 	if delReq.Step < 0 || delReq.Pitch <= 0 {
-		sendNoteDeleteError(ctx, "step must be >= 0 and pitch must be > 0")
+		sendError(ctx, errInvalidNotePosition)
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != delReq.UserID {
-		sendNoteDeleteError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireTrack(session, delReq.RoomID, delReq.SongID, delReq.TrackID)
+	if err == nil {
+		err = member.Require(services.PermEditNotes)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
 		return
 	}
 
-	if err := services.DeleteNote(delReq.SongID, delReq.TrackID, delReq.Step, delReq.Pitch); err != nil {
+	// The store hands back the note it removed, for the undo history and the
+	// broadcast. Without expected_version an empty cell is already what the
+	// client wants, so the delete succeeds without a broadcast.
+	deleted, err := h.store.Notes.DeleteNote(delReq.SongID, delReq.TrackID, delReq.Step, delReq.Pitch, delReq.ExpectedVersion)
+	if errors.Is(err, services.ErrNotFound) && delReq.ExpectedVersion == 0 {
+		data, _ := json.Marshal(DeleteNoteResponse{Success: true, Message: "note deleted"})
+		ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+		return
+	}
+	if errors.Is(err, services.ErrNotFound) || errors.Is(err, services.ErrStaleVersion) {
+		h.sendCellConflict(ctx, errNoteChanged, delReq.SongID, delReq.TrackID, delReq.Step, delReq.Pitch)
+		return
+	}
+	if err != nil {
 		log.Printf("failed to delete note: %v", err)
-		sendNoteDeleteError(ctx, "failed to delete note")
+		sendError(ctx, storeFailure(err, "failed to delete note"))
 		return
 	}
 
+	services.RecordEdit(delReq.RoomID, delReq.UserID, services.Edit{
+		Kind:   services.EditNotes,
+		SongID: delReq.SongID,
+		Notes:  []services.NoteChange{{Op: services.NoteOpDelete, Before: deleted}},
+	})
+
 	// Ensure this session is tracked in the room for broadcasts.
 	services.AddSessionToRoom(delReq.RoomID, ctx.Session())
 
@@ -191,57 +299,410 @@ func handleDeleteNote(ctx easytcp.Context) {
 		TrackID: delReq.TrackID,
 		Step:    delReq.Step,
 		Pitch:   delReq.Pitch,
+		Before:  deleted,
 	}
 	if b, err := json.Marshal(bcast); err == nil {
-		services.BroadcastToRoom(delReq.RoomID, easytcp.NewMessage(603, b), nil)
+		services.PublishToRoom(delReq.RoomID, easytcp.NewMessage(603, b))
 	}
 
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendNoteDeleteError(ctx easytcp.Context, msg string) {
-	resp := DeleteNoteResponse{Success: false, Message: msg}
+func (h *handler) handleUpdateNote(ctx easytcp.Context) {
+	req := ctx.Request()
+	log.Printf("607 update note: id=%d bytes=%d", req.ID(), len(req.Data()))
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var updReq UpdateNoteRequest
+	if err := json.Unmarshal(req.Data(), &updReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if updReq.UserID == "" || updReq.RoomID == "" || updReq.SongID == "" || updReq.NoteID == "" {
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "note_id"))
+		return
+	}
+	if updReq.NotePatch.Empty() {
+		sendError(ctx, errNothingToUpdate)
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != updReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireSong(session, updReq.RoomID, updReq.SongID)
+	if err == nil {
+		err = member.Require(services.PermEditNotes)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	before, apiErr := h.checkNotePatch(session, updReq.RoomID, updReq.SongID, updReq.NoteID, updReq.NotePatch)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+	if updReq.ExpectedVersion > 0 && before.Version != updReq.ExpectedVersion {
+		sendNoteConflict(ctx, errNoteChanged, NoteConflictResponse{SongID: updReq.SongID, NoteID: before.ID, Current: before})
+		return
+	}
+
+	// Pinned to the version just validated against the grid.
+	note, err := h.store.Notes.UpdateNote(updReq.NoteID, updReq.NotePatch, before.Version)
+	switch {
+	case errors.Is(err, services.ErrStaleVersion):
+		h.sendNoteIDConflict(ctx, updReq.SongID, updReq.NoteID)
+		return
+	case errors.Is(err, services.ErrConflict):
+		target := updReq.NotePatch.Apply(*before)
+		h.sendCellConflict(ctx, errNotePositionTaken, target.SongID, target.TrackID, target.Step, target.Pitch)
+		return
+	case err != nil:
+		sendError(ctx, noteWriteFailure(err, "failed to update note"))
+		return
+	}
+
+	services.RecordEdit(updReq.RoomID, updReq.UserID, services.Edit{
+		Kind:   services.EditNotes,
+		SongID: updReq.SongID,
+		Notes:  []services.NoteChange{{Op: services.NoteOpUpdate, Note: note, Before: before}},
+	})
+
+	// Ensure this session is tracked in the room for broadcasts.
+	services.AddSessionToRoom(updReq.RoomID, ctx.Session())
+
+	resp := UpdateNoteResponse{Success: true, Message: "note updated", Note: note}
 	data, _ := json.Marshal(resp)
+
+	// One "update" broadcast instead of an off/on pair, so the note keeps its
+	// ID and created_by.
+	bcast := NoteBroadcast{
+		Action:  "update",
+		SongID:  note.SongID,
+		TrackID: note.TrackID,
+		Step:    note.Step,
+		Pitch:   note.Pitch,
+		Note:    note,
+		Before:  before,
+	}
+	if b, err := json.Marshal(bcast); err == nil {
+		services.PublishToRoom(updReq.RoomID, easytcp.NewMessage(603, b))
+	}
+
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// checkNotePatch loads the note, makes sure it belongs to songID (and that a
+// new track_id is a track of the same song) and that the patched note fits
+// the song grid. It returns the note as it is now.
+func (h *handler) checkNotePatch(session *services.UserSession, roomID, songID, noteID string, patch services.NotePatch) (*services.Note, *APIError) {
+	note, err := h.store.Notes.GetNote(noteID)
+	if errors.Is(err, services.ErrNotFound) || (err == nil && note.SongID != songID) {
+		return nil, newError(CodeNotFound, "not_found", "note not found in this song")
+	}
+	if err != nil {
+		log.Printf("failed to fetch note: %v", err)
+		return nil, storeFailure(err, "failed to fetch note")
+	}
+
+	if patch.TrackID != nil && *patch.TrackID != note.TrackID {
+		if _, err := h.store.RequireTrack(session, roomID, songID, *patch.TrackID); err != nil {
+			return nil, accessDenied(err)
+		}
+	}
+
+	song, err := h.store.Songs.GetSong(songID)
+	if err != nil {
+		log.Printf("failed to fetch song: %v", err)
+		return nil, storeFailure(err, "failed to fetch song")
+	}
+	if err := services.CheckNoteOnGrid(song, patch.Apply(*note)); err != nil {
+		return nil, offGrid(err)
+	}
+	return note, nil
+}
+
+// offGrid maps a CheckNoteOnGrid failure to a validation error on its field.
+func offGrid(err error) *APIError {
+	var gridErr *services.NoteGridError
+	if errors.As(err, &gridErr) {
+		return invalidFields(err.Error(), gridErr.Field, gridErr.Reason)
+	}
+	return invalidFields(err.Error())
+}
+
+// noteWriteFailure maps a failed note write to the error sent to the client.
+func noteWriteFailure(err error, message string) *APIError {
+	switch {
+	case errors.Is(err, services.ErrConflict):
+		return newError(CodeConflict, "note_position_taken", "another note already occupies that position")
+	case errors.Is(err, services.ErrNotFound):
+		return newError(CodeNotFound, "not_found", "note not found in this song")
+	}
+	log.Printf("%s: %v", message, err)
+	return storeFailure(err, message)
+}
+
+func (h *handler) handleNoteBatch(ctx easytcp.Context) {
+	req := ctx.Request()
+	log.Printf("608 note batch: id=%d bytes=%d", req.ID(), len(req.Data()))
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var batchReq NoteBatchRequest
+	if err := json.Unmarshal(req.Data(), &batchReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if batchReq.UserID == "" || batchReq.RoomID == "" || batchReq.SongID == "" || len(batchReq.Ops) == 0 {
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "ops"))
+		return
+	}
+	if len(batchReq.Ops) > maxNoteBatchOps {
+		sendError(ctx, invalidFields(fmt.Sprintf("at most %d ops per batch", maxNoteBatchOps), "ops", "too_many"))
+		return
+	}
+	for i, op := range batchReq.Ops {
+		if op.Op == services.NoteOpCreate && op.NoteID != "" {
+			field := fmt.Sprintf("ops[%d].note_id", i)
+			sendError(ctx, invalidFields(field+" is not allowed on create", field, "not_allowed"))
+			return
+		}
+		if op.CreatedBy != "" {
+			field := fmt.Sprintf("ops[%d].created_by", i)
+			sendError(ctx, invalidFields(field+" is not allowed", field, "not_allowed"))
+			return
+		}
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != batchReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireSong(session, batchReq.RoomID, batchReq.SongID)
+	if err == nil {
+		err = member.Require(services.PermEditNotes)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	// Dry-run the batch first so a bad op is reported by index and nothing
+	// reaches the database.
+	song, err := h.store.Songs.GetSong(batchReq.SongID)
+	if err != nil {
+		log.Printf("failed to fetch song: %v", err)
+		sendError(ctx, storeFailure(err, "failed to fetch song"))
+		return
+	}
+	tracks, err := h.store.Tracks.ListTracksBySong(batchReq.SongID)
+	if err != nil {
+		log.Printf("failed to list tracks: %v", err)
+		sendError(ctx, storeFailure(err, "failed to list tracks"))
+		return
+	}
+	notes, err := h.store.Notes.ListNotesBySong(batchReq.SongID, "")
+	if err != nil {
+		log.Printf("failed to list notes: %v", err)
+		sendError(ctx, storeFailure(err, "failed to list notes"))
+		return
+	}
+	if err := services.CheckNoteBatch(song, tracks, notes, batchReq.Ops); err != nil {
+		h.sendNoteBatchError(ctx, batchReq.SongID, batchReq.Ops, err)
+		return
+	}
+
+	changes, err := h.store.Notes.ApplyNoteBatch(batchReq.SongID, batchReq.UserID, batchReq.Ops)
+	if errors.Is(err, services.ErrConflict) || errors.Is(err, services.ErrNotFound) {
+		// The notes changed between the dry run and the write (the ops are
+		// pinned to the versions checked). Run it again on what is there now
+		// to tell the client which op lost and what it lost to.
+		var opErr *services.NoteOpError
+		if !errors.As(err, &opErr) {
+			if fresh, ferr := h.store.Notes.ListNotesBySong(batchReq.SongID, ""); ferr == nil {
+				if cerr := services.CheckNoteBatch(song, tracks, fresh, batchReq.Ops); cerr != nil {
+					err = cerr
+				}
+			}
+		}
+	}
+	if err != nil {
+		h.sendNoteBatchError(ctx, batchReq.SongID, batchReq.Ops, err)
+		return
+	}
+
+	services.RecordEdit(batchReq.RoomID, batchReq.UserID, services.Edit{
+		Kind:   services.EditNotes,
+		SongID: batchReq.SongID,
+		Notes:  changes,
+	})
+
+	// Ensure this session is tracked in the room for broadcasts.
+	services.AddSessionToRoom(batchReq.RoomID, ctx.Session())
+
+	resp := NoteBatchResponse{Success: true, Message: fmt.Sprintf("%d note changes applied", len(changes)), Changes: changes}
+	data, _ := json.Marshal(resp)
+
+	// The whole batch goes out as one 603 event.
+	bcast := NoteBroadcast{
+		Action:  "batch",
+		SongID:  batchReq.SongID,
+		Changes: changes,
+	}
+	if b, err := json.Marshal(bcast); err == nil {
+		services.PublishToRoom(batchReq.RoomID, easytcp.NewMessage(603, b))
+	}
+
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// sendNoteBatchError answers a failed batch, naming the op that failed. An op
+// that lost a race gets the conflict envelope with the note in its way.
+func (h *handler) sendNoteBatchError(ctx easytcp.Context, songID string, ops []services.NoteOp, err error) {
+	var opErr *services.NoteOpError
+	if !errors.As(err, &opErr) {
+		sendError(ctx, noteWriteFailure(err, "failed to apply note batch"))
+		return
+	}
+	field := fmt.Sprintf("ops[%d]", opErr.Index)
+
+	var gridErr *services.NoteGridError
+	switch {
+	case errors.As(err, &gridErr):
+		sendError(ctx, invalidFields(err.Error(), field+"."+gridErr.Field, gridErr.Reason))
+	case errors.Is(err, services.ErrConflict), errors.Is(err, services.ErrStaleVersion):
+		e := newError(CodeConflict, "note_changed", field+": the note was changed by someone else")
+		if errors.Is(err, services.ErrConflict) {
+			e = newError(CodeConflict, "note_position_taken", field+": another note already occupies that position")
+		}
+		e.Details = []FieldError{{Field: field, Reason: "conflict"}}
+		resp := NoteConflictResponse{SongID: songID, NoteID: ops[opErr.Index].NoteID, Current: opErr.Current}
+		if c := opErr.Current; c != nil {
+			resp.NoteID, resp.TrackID, resp.Step, resp.Pitch = c.ID, c.TrackID, &c.Step, &c.Pitch
+		}
+		sendNoteConflict(ctx, e, resp)
+	case errors.Is(err, services.ErrNotFound):
+		sendError(ctx, newError(CodeNotFound, "not_found", field+": note not found in this song"))
+	default:
+		sendError(ctx, invalidFields(err.Error(), field, "invalid"))
+	}
+}
+
+// noteAt returns the note in a cell, or nil when the cell is empty.
+func (h *handler) noteAt(songID, trackID string, step, pitch int) (*services.Note, error) {
+	notes, err := h.store.Notes.ListNotesBySong(songID, trackID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range notes {
+		if notes[i].Step == step && notes[i].Pitch == pitch {
+			return &notes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// sendCellConflict answers with e and the cell's current note.
+func (h *handler) sendCellConflict(ctx easytcp.Context, e *APIError, songID, trackID string, step, pitch int) {
+	current, err := h.noteAt(songID, trackID, step, pitch)
+	if err != nil {
+		log.Printf("failed to fetch note: %v", err)
+		sendError(ctx, storeFailure(err, "failed to fetch note"))
+		return
+	}
+	sendNoteConflict(ctx, e, NoteConflictResponse{SongID: songID, TrackID: trackID, Step: &step, Pitch: &pitch, Current: current})
+}
+
+// sendNoteIDConflict answers a stale write to a note with its current state.
+func (h *handler) sendNoteIDConflict(ctx easytcp.Context, songID, noteID string) {
+	current, err := h.store.Notes.GetNote(noteID)
+	if errors.Is(err, services.ErrNotFound) {
+		current, err = nil, nil
+	}
+	if err != nil {
+		log.Printf("failed to fetch note: %v", err)
+		sendError(ctx, storeFailure(err, "failed to fetch note"))
+		return
+	}
+	sendNoteConflict(ctx, errNoteChanged, NoteConflictResponse{SongID: songID, NoteID: noteID, Current: current})
+}
+
+// sendNoteConflict answers with e and what the note write lost to.
+func sendNoteConflict(ctx easytcp.Context, e *APIError, resp NoteConflictResponse) {
+	resp.ErrorResponse = errorEnvelope(ctx, e)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("encode conflict response: %v", err)
+		return
+	}
 	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
 }
 
-func handleListNotes(ctx easytcp.Context) {
+func (h *handler) handleListNotes(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("610 list notes: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendListNotesError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var lnReq ListNotesRequest
 	if err := json.Unmarshal(req.Data(), &lnReq); err != nil {
-		sendListNotesError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if lnReq.UserID == "" || lnReq.RoomID == "" || lnReq.SongID == "" {
-		sendListNotesError(ctx, "user_id, room_id, and song_id are required")
+		sendError(ctx, missingFields("user_id", "room_id", "song_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != lnReq.UserID {
-		sendListNotesError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	notes, err := services.ListNotesBySong(lnReq.SongID, lnReq.TrackID)
+	if _, err := h.store.RequireSong(session, lnReq.RoomID, lnReq.SongID); err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+	if lnReq.TrackID != "" {
+		if _, err := h.store.RequireTrack(session, lnReq.RoomID, lnReq.SongID, lnReq.TrackID); err != nil {
+			sendError(ctx, accessDenied(err))
+			return
+		}
+	}
+
+	notes, err := h.store.Notes.ListNotesBySong(lnReq.SongID, lnReq.TrackID)
 	if err != nil {
 		log.Printf("failed to list notes: %v", err)
-		sendListNotesError(ctx, "failed to list notes")
+		sendError(ctx, storeFailure(err, "failed to list notes"))
 		return
 	}
 
-	tracks, err := services.ListTracksBySong(lnReq.SongID)
+	tracks, err := h.store.Tracks.ListTracksBySong(lnReq.SongID)
 	if err != nil {
 		log.Printf("failed to list tracks: %v", err)
-		sendListNotesError(ctx, "failed to list tracks")
+		sendError(ctx, storeFailure(err, "failed to list tracks"))
 		return
 	}
 
@@ -258,9 +719,3 @@ func handleListNotes(ctx easytcp.Context) {
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
-
-func sendListNotesError(ctx easytcp.Context, msg string) {
-	resp := ListNotesResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
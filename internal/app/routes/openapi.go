@@ -0,0 +1,182 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The OpenAPI description is generated from httpEndpoints and the Go
+// response types, so it can't drift from what the gateway serves.
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		var err error
+		if openAPIJSON, err = json.MarshalIndent(buildOpenAPI(), "", "  "); err != nil {
+			panic(fmt.Sprintf("encode openapi: %v", err))
+		}
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
+
+type jsonObject = map[string]interface{}
+
+func buildOpenAPI() jsonObject {
+	schemas := make(jsonObject)
+	errorRef := schemaFor(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	paths := make(jsonObject)
+	for _, ep := range httpEndpoints {
+		params := []jsonObject{}
+		for _, p := range ep.Params {
+			param := jsonObject{
+				"name":     p.Name,
+				"in":       p.In,
+				"required": p.In == "path",
+				"schema":   jsonObject{"type": p.Type},
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+
+		responses := jsonObject{
+			"200": jsonObject{
+				"description": "OK",
+				"content":     jsonObject{"application/json": jsonObject{"schema": schemaFor(reflect.TypeOf(ep.Response), schemas)}},
+			},
+			"default": jsonObject{
+				"description": "Error envelope; the HTTP status follows error.code",
+				"content":     jsonObject{"application/json": jsonObject{"schema": errorRef}},
+			},
+		}
+
+		path := HTTPPrefix + ep.Path
+		item, _ := paths[path].(jsonObject)
+		if item == nil {
+			item = make(jsonObject)
+			paths[path] = item
+		}
+		item[strings.ToLower(ep.Method)] = jsonObject{
+			"summary":     ep.Summary,
+			"description": fmt.Sprintf("Same as TCP route %d.", ep.Route),
+			"operationId": fmt.Sprintf("route%d", ep.Route),
+			"parameters":  params,
+			"responses":   responses,
+		}
+	}
+
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "Musick REST gateway",
+			"version": "1",
+		},
+		"security": []jsonObject{{"bearer": []string{}}},
+		"paths":    paths,
+		"components": jsonObject{
+			"schemas": schemas,
+			"securitySchemes": jsonObject{
+				"bearer": jsonObject{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema of t, adding named structs to schemas and
+// referring to them by $ref.
+func schemaFor(t reflect.Type, schemas jsonObject) jsonObject {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return jsonObject{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage(nil)):
+		return jsonObject{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonObject{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		ref := jsonObject{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = jsonObject{} // placeholder for recursive types
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return ref
+	}
+	return jsonObject{}
+}
+
+func structSchema(t reflect.Type, schemas jsonObject) jsonObject {
+	props := make(jsonObject)
+	var required []string
+	addStructFields(t, schemas, props, &required)
+	schema := jsonObject{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addStructFields follows encoding/json: exported fields by their json tag,
+// embedded structs flattened, omitempty fields optional.
+func addStructFields(t reflect.Type, schemas, props jsonObject, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructFields(ft, schemas, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaFor(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"encoding/json"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+type PresenceRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+}
+
+type PresenceResponse struct {
+	Success bool                     `json:"success"`
+	Message string                   `json:"message"`
+	RoomID  string                   `json:"room_id,omitempty"`
+	Online  []services.PresenceEntry `json:"online,omitempty"`
+}
+
+// RegisterPresenceRoutes wires the room presence handler. Join/leave updates
+// are pushed on route 251 by services.AddSessionToRoom and friends.
+func RegisterPresenceRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(250, h.handlePresence)
+}
+
+func (h *handler) handlePresence(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var pReq PresenceRequest
+	if err := json.Unmarshal(req.Data(), &pReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if pReq.UserID == "" || pReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != pReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	if _, err := h.store.RequireMember(session, pReq.RoomID); err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	// Asking for presence subscribes the session, so it also receives the 251 updates.
+	services.AddSessionToRoom(pReq.RoomID, ctx.Session())
+
+	resp := PresenceResponse{
+		Success: true,
+		Message: "ok",
+		RoomID:  pReq.RoomID,
+		Online:  services.RoomPresence(pReq.RoomID),
+	}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
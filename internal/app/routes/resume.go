@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+type ResumeRequest struct {
+	ResumeToken string `json:"resume_token"`
+	// LastSeq optionally overrides, per room, the last event seq the client
+	// applied, counted in that room's LastEpoch; rooms not listed resume from
+	// where the old connection stopped.
+	LastSeq   map[string]uint64 `json:"last_seq,omitempty"`
+	LastEpoch map[string]string `json:"last_epoch,omitempty"`
+}
+
+type ResumedRoom struct {
+	RoomID   string `json:"room_id"`
+	Epoch    string `json:"epoch"`
+	Seq      uint64 `json:"seq"`
+	Replayed int    `json:"replayed"`
+	Reload   bool   `json:"reload"`
+}
+
+type ResumeResponse struct {
+	Success      bool          `json:"success"`
+	Message      string        `json:"message"`
+	UserID       string        `json:"user_id,omitempty"`
+	UserName     string        `json:"user_name,omitempty"`
+	ExpiresAt    string        `json:"expires_at,omitempty"`
+	ResumeToken  string        `json:"resume_token,omitempty"`
+	ResumeWindow int           `json:"resume_window,omitempty"`
+	Rooms        []ResumedRoom `json:"rooms,omitempty"`
+}
+
+// RegisterResumeRoutes wires session resumption (13) for reconnecting clients.
+func RegisterResumeRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(13, h.handleResume)
+}
+
+// handleResume restores a session dropped less than ResumeWindow ago: the
+// user is authenticated again, every room the old connection was subscribed
+// to (and is still a member of) is resubscribed, and missed events are
+// replayed as in route 260. The new connection must have negotiated the
+// resume feature.
+func (h *handler) handleResume(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.ProtocolFor(ctx.Session()).Has(services.FeatureResume) {
+		sendError(ctx, featureRequired(services.FeatureResume))
+		return
+	}
+
+	if services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, newError(CodeConflict, "conflict", "already authenticated"))
+		return
+	}
+
+	var rReq ResumeRequest
+	if err := json.Unmarshal(req.Data(), &rReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if rReq.ResumeToken == "" {
+		sendError(ctx, missingFields("resume_token"))
+		return
+	}
+
+	session, rooms, err := services.ResumeSession(ctx.Session(), rReq.ResumeToken)
+	if errors.Is(err, services.ErrResumeInvalid) {
+		sendError(ctx, newError(CodeUnauthenticated, "resume_invalid", err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to resume session: %v", err)
+		sendError(ctx, storeFailure(err, "failed to resume session"))
+		return
+	}
+
+	log.Printf("session resumed for user %s", session.UserID)
+
+	resumed := make([]ResumedRoom, 0, len(rooms))
+	for roomID, after := range rooms {
+		if seq, ok := rReq.LastSeq[roomID]; ok {
+			after = services.StreamPos{Epoch: rReq.LastEpoch[roomID], Seq: seq}
+		}
+		if _, err := h.store.RequireMember(session, roomID); err != nil {
+			// Kicked, banned or the room is gone; don't resubscribe.
+			continue
+		}
+		pos, replayed, ok := services.ResumeRoom(roomID, ctx.Session(), after)
+		resumed = append(resumed, ResumedRoom{RoomID: roomID, Epoch: pos.Epoch, Seq: pos.Seq, Replayed: replayed, Reload: !ok})
+	}
+	sort.Slice(resumed, func(i, j int) bool { return resumed[i].RoomID < resumed[j].RoomID })
+
+	resp := ResumeResponse{
+		Success:   true,
+		Message:   "session resumed",
+		UserID:    session.UserID,
+		UserName:  session.UserName,
+		ExpiresAt: formatExpiry(session.ExpiresAt),
+		Rooms:     resumed,
+	}
+	if session.ResumeToken != "" {
+		resp.ResumeToken = session.ResumeToken
+		resp.ResumeWindow = int(services.ResumeWindow.Seconds())
+	}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
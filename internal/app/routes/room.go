@@ -2,6 +2,7 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 
 	"musick-server/internal/app/services"
@@ -45,50 +46,85 @@ type FindPublicRoomsResponse struct {
 	Rooms   []services.Room `json:"rooms,omitempty"`
 }
 
-func RegisterRoomRoutes(s *easytcp.Server) {
-	s.AddRoute(201, handleCreateRoom)
-	s.AddRoute(210, handleListRooms)
-	s.AddRoute(211, handleFindPublicRooms)
+type UpdateRoomRequest struct {
+	UserID      string  `json:"user_id"`
+	RoomID      string  `json:"room_id"`
+	RoomName    *string `json:"room_name,omitempty"`
+	IsPrivate   *bool   `json:"is_private,omitempty"`
+	Description *string `json:"description,omitempty"`
 }
 
-func handleCreateRoom(ctx easytcp.Context) {
+type UpdateRoomResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Room    *services.Room `json:"room,omitempty"`
+}
+
+type DeleteRoomRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+}
+
+type DeleteRoomResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// RoomEvent is the payload for route 206 room broadcasts.
+type RoomEvent struct {
+	Event  string         `json:"event"` // "updated" or "deleted"
+	RoomID string         `json:"room_id"`
+	Room   *services.Room `json:"room,omitempty"`
+	By     string         `json:"by"`
+}
+
+func RegisterRoomRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(201, h.handleCreateRoom)
+	s.AddRoute(204, h.handleUpdateRoom)
+	s.AddRoute(205, h.handleDeleteRoom)
+	s.AddRoute(210, h.handleListRooms)
+	s.AddRoute(211, h.handleFindPublicRooms)
+}
+
+func (h *handler) handleCreateRoom(ctx easytcp.Context) {
 	req := ctx.Request()
 
 	// Check authentication
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendRoomError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var createReq CreateRoomRequest
 	if err := json.Unmarshal(req.Data(), &createReq); err != nil {
-		sendRoomError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	// Validate required fields
 	if createReq.RoomName == "" {
-		sendRoomError(ctx, "room_name is required")
+		sendError(ctx, missingFields("room_name"))
 		return
 	}
 
 	if createReq.UserID == "" {
-		sendRoomError(ctx, "user_id is required")
+		sendError(ctx, missingFields("user_id"))
 		return
 	}
 
 	// Verify the user_id matches the authenticated session
 	userSession := services.GetSession(ctx.Session())
 	if userSession == nil || userSession.UserID != createReq.UserID {
-		sendRoomError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
 	// Create room in database
-	room, err := services.CreateRoom(createReq.UserID, createReq.RoomName, createReq.IsPrivate)
+	room, err := h.store.Rooms.CreateRoom(createReq.UserID, createReq.RoomName, createReq.IsPrivate)
 	if err != nil {
 		log.Printf("failed to create room: %v", err)
-		sendRoomError(ctx, "failed to create room")
+		sendError(ctx, storeFailure(err, "failed to create room"))
 		return
 	}
 
@@ -107,104 +143,219 @@ func handleCreateRoom(ctx easytcp.Context) {
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), respData))
 }
 
-func sendRoomError(ctx easytcp.Context, msg string) {
-	resp := CreateRoomResponse{Success: false, Message: msg}
+func (h *handler) handleUpdateRoom(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var upReq UpdateRoomRequest
+	if err := json.Unmarshal(req.Data(), &upReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if upReq.UserID == "" || upReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+	if upReq.RoomName == nil && upReq.IsPrivate == nil && upReq.Description == nil {
+		sendError(ctx, errNothingToUpdate)
+		return
+	}
+	if upReq.RoomName != nil && *upReq.RoomName == "" {
+		sendError(ctx, invalidFields("room_name cannot be empty", "room_name", "empty"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != upReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireMember(session, upReq.RoomID)
+	if err == nil {
+		err = member.Require(services.PermManageRoom)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	room, err := h.store.Rooms.UpdateRoom(upReq.RoomID, upReq.RoomName, upReq.IsPrivate, upReq.Description)
+	if errors.Is(err, services.ErrNotFound) {
+		// Deleted between the membership check and the update.
+		sendError(ctx, errRoomNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update room: %v", err)
+		sendError(ctx, storeFailure(err, "failed to update room"))
+		return
+	}
+
+	resp := UpdateRoomResponse{Success: true, Message: "room updated", Room: room}
 	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+
+	event := RoomEvent{Event: "updated", RoomID: room.ID, Room: room, By: upReq.UserID}
+	if b, err := json.Marshal(event); err == nil {
+		services.PublishToRoom(room.ID, easytcp.NewMessage(206, b))
+	}
+
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func handleListRooms(ctx easytcp.Context) {
+func (h *handler) handleDeleteRoom(ctx easytcp.Context) {
 	req := ctx.Request()
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendListRoomsError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var delReq DeleteRoomRequest
+	if err := json.Unmarshal(req.Data(), &delReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if delReq.UserID == "" || delReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != delReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireMember(session, delReq.RoomID)
+	if err == nil {
+		err = member.Require(services.PermManageRoom)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	if err := h.store.Rooms.DeleteRoom(delReq.RoomID); err != nil {
+		log.Printf("failed to delete room: %v", err)
+		sendError(ctx, storeFailure(err, "failed to delete room"))
+		return
+	}
+
+	log.Printf("room deleted: %s by user %s", delReq.RoomID, delReq.UserID)
+
+	// Tell subscribers before dropping them so their clients can close the room.
+	event := RoomEvent{Event: "deleted", RoomID: delReq.RoomID, By: delReq.UserID}
+	if b, err := json.Marshal(event); err == nil {
+		services.PublishToRoom(delReq.RoomID, easytcp.NewMessage(206, b))
+	}
+	services.EvictRoom(delReq.RoomID)
+
+	resp := DeleteRoomResponse{Success: true, Message: "room deleted"}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleListRooms(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var listReq ListRoomsRequest
 	if err := json.Unmarshal(req.Data(), &listReq); err != nil {
-		sendListRoomsError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if listReq.UserID == "" {
-		sendListRoomsError(ctx, "user_id is required")
+		sendError(ctx, missingFields("user_id"))
 		return
 	}
 
 	userSession := services.GetSession(ctx.Session())
 	if userSession == nil || userSession.UserID != listReq.UserID {
-		sendListRoomsError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	rooms, err := services.ListRoomsByUser(listReq.UserID)
-	if err != nil {
-		log.Printf("failed to list rooms: %v", err)
-		sendListRoomsError(ctx, "failed to list rooms")
+	resp, apiErr := h.listRooms(listReq.UserID)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
 		return
 	}
 
-	resp := ListRoomsResponse{
-		Success: true,
-		Message: "rooms fetched",
-		Rooms:   rooms,
-	}
-
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendListRoomsError(ctx easytcp.Context, msg string) {
-	resp := ListRoomsResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+// listRooms backs route 210 and GET /api/v1/rooms.
+func (h *handler) listRooms(userID string) (*ListRoomsResponse, *APIError) {
+	rooms, err := h.store.Rooms.ListRoomsByUser(userID)
+	if err != nil {
+		log.Printf("failed to list rooms: %v", err)
+		return nil, storeFailure(err, "failed to list rooms")
+	}
+	return &ListRoomsResponse{
+		Success: true,
+		Message: "rooms fetched",
+		Rooms:   rooms,
+	}, nil
 }
 
-func handleFindPublicRooms(ctx easytcp.Context) {
+func (h *handler) handleFindPublicRooms(ctx easytcp.Context) {
 	req := ctx.Request()
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendFindPublicRoomsError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var findReq FindPublicRoomsRequest
 	if err := json.Unmarshal(req.Data(), &findReq); err != nil {
-		sendFindPublicRoomsError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if findReq.UserID == "" {
-		sendFindPublicRoomsError(ctx, "user_id is required")
+		sendError(ctx, missingFields("user_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != findReq.UserID {
-		sendFindPublicRoomsError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	rooms, err := services.FindPublicRooms(findReq.Name, findReq.UserID)
-	if err != nil {
-		log.Printf("failed to find public rooms: %v", err)
-		sendFindPublicRoomsError(ctx, "failed to find public rooms")
+	resp, apiErr := h.findPublicRooms(findReq.UserID, findReq.Name)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
 		return
 	}
 
-	resp := FindPublicRoomsResponse{
-		Success: true,
-		Message: "rooms fetched",
-		Rooms:   rooms,
-	}
-
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendFindPublicRoomsError(ctx easytcp.Context, msg string) {
-	resp := FindPublicRoomsResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+// findPublicRooms backs route 211 and GET /api/v1/rooms/public.
+func (h *handler) findPublicRooms(userID, name string) (*FindPublicRoomsResponse, *APIError) {
+	rooms, err := h.store.Rooms.FindPublicRooms(name, userID)
+	if err != nil {
+		log.Printf("failed to find public rooms: %v", err)
+		return nil, storeFailure(err, "failed to find public rooms")
+	}
+	return &FindPublicRoomsResponse{
+		Success: true,
+		Message: "rooms fetched",
+		Rooms:   rooms,
+	}, nil
 }
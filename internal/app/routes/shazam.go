@@ -27,14 +27,14 @@ func handleShazamDetect(ctx easytcp.Context) {
 
 	// 1. 檢查是否登入 (按照現有專案慣例)
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendShazamError(ctx, "未驗證身份")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	// 2. 解析 JSON 請求
 	var sReq ShazamRequest
 	if err := json.Unmarshal(req.Data(), &sReq); err != nil {
-		sendShazamError(ctx, "無效的請求格式")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
@@ -42,7 +42,7 @@ func handleShazamDetect(ctx easytcp.Context) {
 	resultJson, err := services.RecognizeSong(sReq.AudioData)
 	if err != nil {
 		log.Printf("Shazam API 錯誤: %v", err)
-		sendShazamError(ctx, "辨識失敗")
+		sendError(ctx, newError(CodeUpstreamUnavailable, "recognition_failed", "recognition failed"))
 		return
 	}
 
@@ -59,9 +59,3 @@ func handleShazamDetect(ctx easytcp.Context) {
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
-
-func sendShazamError(ctx easytcp.Context, msg string) {
-	resp := ShazamResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
@@ -0,0 +1,280 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// maxSnapshotNameLen bounds snapshot names, in characters.
+const maxSnapshotNameLen = 100
+
+type CreateSnapshotRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+	SongID string `json:"song_id"`
+	Name   string `json:"name"`
+}
+
+type CreateSnapshotResponse struct {
+	Success  bool               `json:"success"`
+	Message  string             `json:"message"`
+	Snapshot *services.Snapshot `json:"snapshot,omitempty"`
+}
+
+type SnapshotListRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+	SongID string `json:"song_id"`
+}
+
+type SnapshotListResponse struct {
+	Success   bool                `json:"success"`
+	Message   string              `json:"message"`
+	Snapshots []services.Snapshot `json:"snapshots,omitempty"`
+}
+
+type RestoreSnapshotRequest struct {
+	UserID     string `json:"user_id"`
+	RoomID     string `json:"room_id"`
+	SongID     string `json:"song_id"`
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// RestoreSnapshotResponse names the restored snapshot and the automatic one
+// taken of the song just before, which restores the overwritten state.
+type RestoreSnapshotResponse struct {
+	Success  bool               `json:"success"`
+	Message  string             `json:"message"`
+	Snapshot *services.Snapshot `json:"snapshot,omitempty"`
+	Backup   *services.Snapshot `json:"backup,omitempty"`
+}
+
+// SnapshotEvent is the payload for route 633 broadcasts. Collaborators
+// should refetch the song with 510/610 when they get one.
+type SnapshotEvent struct {
+	Event    string             `json:"event"` // "restored"
+	RoomID   string             `json:"room_id"`
+	SongID   string             `json:"song_id"`
+	Snapshot *services.Snapshot `json:"snapshot,omitempty"`
+	Backup   *services.Snapshot `json:"backup,omitempty"`
+	By       string             `json:"by"`
+}
+
+var errSnapshotNotFound = newError(CodeNotFound, "snapshot_not_found", "snapshot not found")
+
+// RegisterSnapshotRoutes wires song snapshot handlers. Automatic snapshots
+// are taken by services.WatchAutoSnapshots.
+func RegisterSnapshotRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(630, h.handleCreateSnapshot)
+	s.AddRoute(631, h.handleListSnapshots)
+	s.AddRoute(632, h.handleRestoreSnapshot)
+}
+
+func (h *handler) handleCreateSnapshot(ctx easytcp.Context) {
+	req := ctx.Request()
+	log.Printf("630 create snapshot: id=%d bytes=%d", req.ID(), len(req.Data()))
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var snapReq CreateSnapshotRequest
+	if err := json.Unmarshal(req.Data(), &snapReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	name := strings.TrimSpace(snapReq.Name)
+	if snapReq.UserID == "" || snapReq.RoomID == "" || snapReq.SongID == "" || name == "" {
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "name"))
+		return
+	}
+	if utf8.RuneCountInString(name) > maxSnapshotNameLen {
+		sendError(ctx, invalidFields(fmt.Sprintf("name must be at most %d characters", maxSnapshotNameLen), "name", "too_long"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != snapReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireSong(session, snapReq.RoomID, snapReq.SongID)
+	if err == nil {
+		err = member.Require(services.PermEditSongs)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	snap, err := h.store.TakeSnapshot(snapReq.SongID, name, snapReq.UserID, false)
+	if err != nil {
+		log.Printf("failed to snapshot song: %v", err)
+		sendError(ctx, storeFailure(err, "failed to create snapshot"))
+		return
+	}
+
+	resp := CreateSnapshotResponse{
+		Success:  true,
+		Message:  "snapshot created",
+		Snapshot: snap,
+	}
+
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+func (h *handler) handleListSnapshots(ctx easytcp.Context) {
+	req := ctx.Request()
+	log.Printf("631 list snapshots: id=%d bytes=%d", req.ID(), len(req.Data()))
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var listReq SnapshotListRequest
+	if err := json.Unmarshal(req.Data(), &listReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if listReq.UserID == "" || listReq.RoomID == "" || listReq.SongID == "" {
+		sendError(ctx, missingFields("user_id", "room_id", "song_id"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != listReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	resp, apiErr := h.listSnapshots(session, listReq.RoomID, listReq.SongID)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
+		return
+	}
+
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
+
+// listSnapshots backs route 631 and GET /api/v1/rooms/{room_id}/songs/{song_id}/snapshots.
+func (h *handler) listSnapshots(session *services.UserSession, roomID, songID string) (*SnapshotListResponse, *APIError) {
+	if _, err := h.store.RequireSong(session, roomID, songID); err != nil {
+		return nil, accessDenied(err)
+	}
+
+	snaps, err := h.store.Snapshots.ListSnapshots(songID)
+	if err != nil {
+		log.Printf("failed to list snapshots: %v", err)
+		return nil, storeFailure(err, "failed to list snapshots")
+	}
+	return &SnapshotListResponse{
+		Success:   true,
+		Message:   "snapshots fetched",
+		Snapshots: snaps,
+	}, nil
+}
+
+func (h *handler) handleRestoreSnapshot(ctx easytcp.Context) {
+	req := ctx.Request()
+	log.Printf("632 restore snapshot: id=%d bytes=%d", req.ID(), len(req.Data()))
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	var rsReq RestoreSnapshotRequest
+	if err := json.Unmarshal(req.Data(), &rsReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if rsReq.UserID == "" || rsReq.RoomID == "" || rsReq.SongID == "" || rsReq.SnapshotID == "" {
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "snapshot_id"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != rsReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	// A restore rewrites settings, tracks and notes alike.
+	member, err := h.store.RequireSong(session, rsReq.RoomID, rsReq.SongID)
+	for _, p := range []services.Permission{services.PermEditSongs, services.PermEditTracks, services.PermEditNotes} {
+		if err == nil {
+			err = member.Require(p)
+		}
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	snap, err := h.store.Snapshots.GetSnapshot(rsReq.SnapshotID)
+	if errors.Is(err, services.ErrNotFound) || (err == nil && snap.SongID != rsReq.SongID) {
+		sendError(ctx, errSnapshotNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch snapshot: %v", err)
+		sendError(ctx, storeFailure(err, "failed to fetch snapshot"))
+		return
+	}
+	snap.Data = nil
+
+	// Never overwrite the song without a way back. The backup is a named
+	// snapshot, so autosaves never prune it.
+	backup, err := h.store.TakeSnapshot(rsReq.SongID, fmt.Sprintf("Before restoring %q", snap.Name), rsReq.UserID, false)
+	if err != nil {
+		log.Printf("failed to snapshot song before restore: %v", err)
+		sendError(ctx, storeFailure(err, "failed to back up the song"))
+		return
+	}
+
+	if err := h.store.Snapshots.RestoreSnapshot(snap.ID); errors.Is(err, services.ErrNotFound) {
+		sendError(ctx, errSnapshotNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to restore snapshot: %v", err)
+		sendError(ctx, storeFailure(err, "failed to restore snapshot"))
+		return
+	}
+
+	// Undo entries describe notes and tracks the restore just replaced.
+	services.DropSongHistory(rsReq.RoomID, rsReq.SongID)
+	services.InvalidateSongTracks(rsReq.SongID)
+	services.AddSessionToRoom(rsReq.RoomID, ctx.Session())
+
+	bcast := SnapshotEvent{Event: "restored", RoomID: rsReq.RoomID, SongID: rsReq.SongID, Snapshot: snap, Backup: backup, By: rsReq.UserID}
+	if b, err := json.Marshal(bcast); err == nil {
+		// Events from before the restore can't be replayed onto it.
+		services.PublishResetToRoom(rsReq.RoomID, easytcp.NewMessage(633, b))
+	}
+
+	resp := RestoreSnapshotResponse{
+		Success:  true,
+		Message:  "snapshot restored",
+		Snapshot: snap,
+		Backup:   backup,
+	}
+
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
@@ -53,92 +53,116 @@ type UpdateSongResponse struct {
 	Song    *services.Song `json:"song,omitempty"`
 }
 
-// RegisterSongRoutes wires song-related handlers.
-func RegisterSongRoutes(s *easytcp.Server) {
-	s.AddRoute(501, handleCreateSong)
-	s.AddRoute(510, handleListSongs)
-	s.AddRoute(511, handleUpdateSong)
+// SongBroadcast is sent on route 512 when a song's settings change, through
+// 511 or an undo/redo of it, with the song as it is now.
+type SongBroadcast struct {
+	Action string         `json:"action"` // "updated"
+	Song   *services.Song `json:"song"`
+	By     string         `json:"by"`
 }
 
-func handleListSongs(ctx easytcp.Context) {
+// RegisterSongRoutes wires song-related handlers. Route 512 is server-initiated:
+// SongBroadcast after a settings change.
+func RegisterSongRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(501, h.handleCreateSong)
+	s.AddRoute(510, h.handleListSongs)
+	s.AddRoute(511, h.handleUpdateSong)
+}
+
+func (h *handler) handleListSongs(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("510 list songs: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendSongListError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var listReq SongListRequest
 	if err := json.Unmarshal(req.Data(), &listReq); err != nil {
-		sendSongListError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if listReq.UserID == "" || listReq.RoomID == "" {
-		sendSongListError(ctx, "user_id and room_id are required")
+		sendError(ctx, missingFields("user_id", "room_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != listReq.UserID {
-		sendSongListError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	songs, err := services.ListSongsByRoom(listReq.RoomID)
-	if err != nil {
-		log.Printf("failed to list songs: %v", err)
-		sendSongListError(ctx, "failed to list songs")
+	resp, apiErr := h.listSongs(session, listReq.RoomID)
+	if apiErr != nil {
+		sendError(ctx, apiErr)
 		return
 	}
 
-	resp := SongListResponse{
-		Success: true,
-		Message: "songs fetched",
-		Songs:   songs,
-	}
-
 	data, _ := json.Marshal(resp)
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendSongListError(ctx easytcp.Context, msg string) {
-	resp := SongListResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+// listSongs backs route 510 and GET /api/v1/rooms/{room_id}/songs.
+func (h *handler) listSongs(session *services.UserSession, roomID string) (*SongListResponse, *APIError) {
+	if _, err := h.store.RequireMember(session, roomID); err != nil {
+		return nil, accessDenied(err)
+	}
+
+	songs, err := h.store.Songs.ListSongsByRoom(roomID)
+	if err != nil {
+		log.Printf("failed to list songs: %v", err)
+		return nil, storeFailure(err, "failed to list songs")
+	}
+	return &SongListResponse{
+		Success: true,
+		Message: "songs fetched",
+		Songs:   songs,
+	}, nil
 }
 
-func handleCreateSong(ctx easytcp.Context) {
+func (h *handler) handleCreateSong(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("501 create song: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendSongCreateError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var createReq CreateSongRequest
 	if err := json.Unmarshal(req.Data(), &createReq); err != nil {
-		sendSongCreateError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if createReq.UserID == "" || createReq.RoomID == "" || createReq.Title == "" {
-		sendSongCreateError(ctx, "user_id, room_id, and title are required")
+		sendError(ctx, missingFields("user_id", "room_id", "title"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != createReq.UserID {
-		sendSongCreateError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	song, err := services.CreateSong(createReq.RoomID, createReq.Title, createReq.BPM, createReq.Steps, createReq.UserID)
+	member, err := h.store.RequireMember(session, createReq.RoomID)
+	if err == nil {
+		err = member.Require(services.PermEditSongs)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	song, err := h.store.Songs.CreateSong(createReq.RoomID, createReq.Title, createReq.BPM, createReq.Steps, createReq.UserID)
 	if err != nil {
 		log.Printf("failed to create song: %v", err)
-		sendSongCreateError(ctx, "failed to create song")
+		sendError(ctx, storeFailure(err, "failed to create song"))
 		return
 	}
 
@@ -152,50 +176,68 @@ func handleCreateSong(ctx easytcp.Context) {
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendSongCreateError(ctx easytcp.Context, msg string) {
-	resp := CreateSongResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func handleUpdateSong(ctx easytcp.Context) {
+func (h *handler) handleUpdateSong(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("511 update song: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendSongUpdateError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var upReq UpdateSongRequest
 	if err := json.Unmarshal(req.Data(), &upReq); err != nil {
-		sendSongUpdateError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if upReq.UserID == "" || upReq.RoomID == "" || upReq.SongID == "" {
-		sendSongUpdateError(ctx, "user_id, room_id, and song_id are required")
+		sendError(ctx, missingFields("user_id", "room_id", "song_id"))
 		return
 	}
 
 	if upReq.Title == nil && upReq.BPM == nil && upReq.Steps == nil && upReq.BeatsPerMeasure == nil && upReq.Scale == nil && upReq.StartPitch == nil && upReq.OctaveRange == nil {
-		sendSongUpdateError(ctx, "no fields to update")
+		sendError(ctx, errNothingToUpdate)
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != upReq.UserID {
-		sendSongUpdateError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	updated, err := services.UpdateSong(upReq.SongID, upReq.Title, upReq.BPM, upReq.Steps, upReq.BeatsPerMeasure, upReq.Scale, upReq.StartPitch, upReq.OctaveRange)
+	member, err := h.store.RequireSong(session, upReq.RoomID, upReq.SongID)
+	if err == nil {
+		err = member.Require(services.PermEditSongs)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	// Keep the old settings for the undo history.
+	before, err := h.store.Songs.GetSong(upReq.SongID)
+	if err != nil {
+		log.Printf("failed to fetch song for history: %v", err)
+		before = nil
+	}
+
+	updated, err := h.store.Songs.UpdateSong(upReq.SongID, upReq.Title, upReq.BPM, upReq.Steps, upReq.BeatsPerMeasure, upReq.Scale, upReq.StartPitch, upReq.OctaveRange)
 	if err != nil {
 		log.Printf("failed to update song: %v", err)
-		sendSongUpdateError(ctx, "failed to update song")
+		sendError(ctx, storeFailure(err, "failed to update song"))
 		return
 	}
 
+	if before != nil {
+		services.RecordEdit(upReq.RoomID, upReq.UserID, services.Edit{Kind: services.EditSong, SongID: upReq.SongID, Before: before, After: updated})
+	}
+
+	// Ensure this session is tracked in the room for broadcasts.
+	services.AddSessionToRoom(upReq.RoomID, ctx.Session())
+	publishSong(upReq.RoomID, upReq.UserID, updated)
+
 	resp := UpdateSongResponse{
 		Success: true,
 		Message: "song updated",
@@ -206,8 +248,9 @@ func handleUpdateSong(ctx easytcp.Context) {
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func sendSongUpdateError(ctx easytcp.Context, msg string) {
-	resp := UpdateSongResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
+// publishSong tells the room on 512 that a song's settings changed.
+func publishSong(roomID, userID string, song *services.Song) {
+	if b, err := json.Marshal(SongBroadcast{Action: "updated", Song: song, By: userID}); err == nil {
+		services.PublishToRoom(roomID, easytcp.NewMessage(512, b))
+	}
 }
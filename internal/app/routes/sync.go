@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"encoding/json"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+type ResyncRequest struct {
+	UserID   string `json:"user_id"`
+	RoomID   string `json:"room_id"`
+	Epoch    string `json:"epoch"`     // epoch of the events after_seq counts in
+	AfterSeq uint64 `json:"after_seq"` // last seq the client applied; 0 = none
+}
+
+type ResyncResponse struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	RoomID   string `json:"room_id,omitempty"`
+	Epoch    string `json:"epoch"`
+	Seq      uint64 `json:"seq"`
+	Replayed int    `json:"replayed"`
+	Reload   bool   `json:"reload"`
+}
+
+// RegisterSyncRoutes wires the room event resync handler. Sequenced events
+// themselves are stamped by services.PublishToRoom.
+func RegisterSyncRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(260, h.handleResync)
+}
+
+// handleResync replays the room events after after_seq on their original
+// routes, or answers reload=true when the gap is too large to replay or the
+// room's stream started over since (epoch differs); the client should then
+// refetch with 510/610 and continue from the returned epoch and seq.
+// Only connections that negotiated event_seq see seqs, so only they may resync.
+func (h *handler) handleResync(ctx easytcp.Context) {
+	req := ctx.Request()
+
+	if !services.IsAuthenticated(ctx.Session()) {
+		sendError(ctx, errNotAuthenticated)
+		return
+	}
+
+	if !services.ProtocolFor(ctx.Session()).Has(services.FeatureEventSeq) {
+		sendError(ctx, featureRequired(services.FeatureEventSeq))
+		return
+	}
+
+	var rsReq ResyncRequest
+	if err := json.Unmarshal(req.Data(), &rsReq); err != nil {
+		sendError(ctx, errInvalidFormat)
+		return
+	}
+
+	if rsReq.UserID == "" || rsReq.RoomID == "" {
+		sendError(ctx, missingFields("user_id", "room_id"))
+		return
+	}
+
+	session := services.GetSession(ctx.Session())
+	if session == nil || session.UserID != rsReq.UserID {
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	if _, err := h.store.RequireMember(session, rsReq.RoomID); err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	after := services.StreamPos{Epoch: rsReq.Epoch, Seq: rsReq.AfterSeq}
+	pos, replayed, ok := services.ResumeRoom(rsReq.RoomID, ctx.Session(), after)
+
+	resp := ResyncResponse{
+		Success:  true,
+		Message:  "ok",
+		RoomID:   rsReq.RoomID,
+		Epoch:    pos.Epoch,
+		Seq:      pos.Seq,
+		Replayed: replayed,
+		Reload:   !ok,
+	}
+	if !ok {
+		resp.Message = "too far behind, reload the room"
+	}
+	data, _ := json.Marshal(resp)
+	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
+}
@@ -45,44 +45,56 @@ type TrackBroadcast struct {
 }
 
 // RegisterTrackRoutes wires track-related handlers.
-func RegisterTrackRoutes(s *easytcp.Server) {
-	s.AddRoute(604, handleCreateTrack)
-	s.AddRoute(605, handleDeleteTrack)
+func RegisterTrackRoutes(s *easytcp.Server, store *services.Store) {
+	h := &handler{store: store}
+	s.AddRoute(604, h.handleCreateTrack)
+	s.AddRoute(605, h.handleDeleteTrack)
 }
 
-func handleCreateTrack(ctx easytcp.Context) {
+func (h *handler) handleCreateTrack(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("604 create track: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendCreateTrackError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var tReq CreateTrackRequest
 	if err := json.Unmarshal(req.Data(), &tReq); err != nil {
-		sendCreateTrackError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if tReq.UserID == "" || tReq.RoomID == "" || tReq.SongID == "" || tReq.Name == "" {
-		sendCreateTrackError(ctx, "user_id, room_id, song_id, and name are required")
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "name"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != tReq.UserID {
-		sendCreateTrackError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
 		return
 	}
 
-	track, err := services.CreateTrack(tReq.SongID, tReq.Name, tReq.Instrument, tReq.Channel, tReq.Color)
+	member, err := h.store.RequireSong(session, tReq.RoomID, tReq.SongID)
+	if err == nil {
+		err = member.Require(services.PermEditTracks)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
+		return
+	}
+
+	track, err := h.store.Tracks.CreateTrack(tReq.SongID, tReq.Name, tReq.Instrument, tReq.Channel, tReq.Color)
 	if err != nil {
 		log.Printf("failed to create track: %v", err)
-		sendCreateTrackError(ctx, "failed to create track")
+		sendError(ctx, storeFailure(err, "failed to create track"))
 		return
 	}
 
+	services.RecordEdit(tReq.RoomID, tReq.UserID, services.Edit{Kind: services.EditTrackAdd, SongID: tReq.SongID, Track: track})
+
 	// Track membership for broadcasts.
 	services.AddSessionToRoom(tReq.RoomID, ctx.Session())
 
@@ -92,43 +104,72 @@ func handleCreateTrack(ctx easytcp.Context) {
 	// Broadcast add on route 606.
 	bcast := TrackBroadcast{Action: "on", Track: track, TrackID: track.ID, SongID: track.SongID}
 	if b, err := json.Marshal(bcast); err == nil {
-		services.BroadcastToRoom(tReq.RoomID, easytcp.NewMessage(606, b), nil)
+		services.PublishToRoom(tReq.RoomID, easytcp.NewMessage(606, b))
 	}
 
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
 
-func handleDeleteTrack(ctx easytcp.Context) {
+func (h *handler) handleDeleteTrack(ctx easytcp.Context) {
 	req := ctx.Request()
 	log.Printf("605 delete track: id=%d bytes=%d", req.ID(), len(req.Data()))
 
 	if !services.IsAuthenticated(ctx.Session()) {
-		sendDeleteTrackError(ctx, "not authenticated")
+		sendError(ctx, errNotAuthenticated)
 		return
 	}
 
 	var dReq DeleteTrackRequest
 	if err := json.Unmarshal(req.Data(), &dReq); err != nil {
-		sendDeleteTrackError(ctx, "invalid request format")
+		sendError(ctx, errInvalidFormat)
 		return
 	}
 
 	if dReq.UserID == "" || dReq.RoomID == "" || dReq.SongID == "" || dReq.TrackID == "" {
-		sendDeleteTrackError(ctx, "user_id, room_id, song_id, and track_id are required")
+		sendError(ctx, missingFields("user_id", "room_id", "song_id", "track_id"))
 		return
 	}
 
 	session := services.GetSession(ctx.Session())
 	if session == nil || session.UserID != dReq.UserID {
-		sendDeleteTrackError(ctx, "user_id mismatch")
+		sendError(ctx, errUserMismatch)
+		return
+	}
+
+	member, err := h.store.RequireTrack(session, dReq.RoomID, dReq.SongID, dReq.TrackID)
+	if err == nil {
+		err = member.Require(services.PermEditTracks)
+	}
+	if err != nil {
+		sendError(ctx, accessDenied(err))
 		return
 	}
 
-	if err := services.DeleteTrack(dReq.TrackID, dReq.SongID); err != nil {
+	// Keep the track and the notes it takes along for the undo history.
+	track, err := h.store.Tracks.GetTrack(dReq.TrackID)
+	var notes []services.Note
+	if err == nil {
+		notes, err = h.store.Notes.ListNotesBySong(dReq.SongID, dReq.TrackID)
+	}
+	if err != nil {
+		log.Printf("failed to fetch track for history: %v", err)
+		track = nil
+	}
+
+	if err := h.store.Tracks.DeleteTrack(dReq.TrackID, dReq.SongID); err != nil {
 		log.Printf("failed to delete track: %v", err)
-		sendDeleteTrackError(ctx, "failed to delete track")
+		sendError(ctx, storeFailure(err, "failed to delete track"))
 		return
 	}
+	services.InvalidateSongTracks(dReq.SongID)
+
+	if track != nil {
+		edit := services.Edit{Kind: services.EditTrackRemove, SongID: dReq.SongID, Track: track}
+		for i := range notes {
+			edit.Notes = append(edit.Notes, services.NoteChange{Op: services.NoteOpDelete, Before: &notes[i]})
+		}
+		services.RecordEdit(dReq.RoomID, dReq.UserID, edit)
+	}
 
 	services.AddSessionToRoom(dReq.RoomID, ctx.Session())
 
@@ -137,20 +178,8 @@ func handleDeleteTrack(ctx easytcp.Context) {
 
 	bcast := TrackBroadcast{Action: "off", TrackID: dReq.TrackID, SongID: dReq.SongID}
 	if b, err := json.Marshal(bcast); err == nil {
-		services.BroadcastToRoom(dReq.RoomID, easytcp.NewMessage(606, b), nil)
+		services.PublishToRoom(dReq.RoomID, easytcp.NewMessage(606, b))
 	}
 
 	ctx.SetResponseMessage(easytcp.NewMessage(req.ID(), data))
 }
-
-func sendCreateTrackError(ctx easytcp.Context, msg string) {
-	resp := CreateTrackResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
-
-func sendDeleteTrackError(ctx easytcp.Context, msg string) {
-	resp := DeleteTrackResponse{Success: false, Message: msg}
-	data, _ := json.Marshal(resp)
-	ctx.SetResponseMessage(easytcp.NewMessage(ctx.Request().ID(), data))
-}
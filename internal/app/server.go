@@ -2,6 +2,8 @@ package app
 
 import (
 	"log"
+	"net/http"
+	"time"
 
 	"musick-server/internal/app/routes"
 	"musick-server/internal/app/services"
@@ -9,19 +11,46 @@ import (
 	"github.com/DarthPestilane/easytcp"
 )
 
-// Server wraps easytcp.Server and centralizes route registration.
+// Server wraps easytcp.Server and centralizes route registration. tcp serves
+// the native client on the length-prefixed framing; ws serves the WebSocket
+// gateway with the same routes, sessions and room subscriptions; api is the
+// REST gateway for non-realtime operations; store backs the automatic song
+// snapshots.
 type Server struct {
-	srv *easytcp.Server
+	tcp    *easytcp.Server
+	ws     *easytcp.Server
+	api    *http.ServeMux
+	packer *easytcp.DefaultPacker
+	store  *services.Store
 }
 
-// New creates a configured server and registers all routes.
-func New() *Server {
+// New creates a configured server and registers all routes against store. It
+// fails when the Protobuf schemas in proto/ don't compile.
+func New(store *services.Store) (*Server, error) {
+	if err := services.LoadProtoSchemas(); err != nil {
+		return nil, err
+	}
+
 	// 1. 建立 DefaultPacker 實例
 	packer := easytcp.NewDefaultPacker()
 
 	// 2. 關鍵修正：將最大封包限制調大至 10MB (預設可能太小導致斷線)
 	packer.MaxDataSize = 10 * 1024 * 1024
 
+	api := http.NewServeMux()
+	routes.RegisterHTTPRoutes(api, store)
+
+	return &Server{
+		tcp:    newEasyServer(store, packer),
+		ws:     newEasyServer(store, packer),
+		api:    api,
+		packer: packer,
+		store:  store,
+	}, nil
+}
+
+// newEasyServer builds one easytcp.Server with the session hooks and routes.
+func newEasyServer(store *services.Store, packer *easytcp.DefaultPacker) *easytcp.Server {
 	// 3. 將設定好的 packer 傳入 ServerOption
 	srv := easytcp.NewServer(&easytcp.ServerOption{
 		Packer: packer,
@@ -31,47 +60,115 @@ func New() *Server {
 	srv.OnSessionCreate = func(sess easytcp.Session) {
 		addr := sess.Conn().RemoteAddr().String()
 		log.Printf("client connected: %s", addr)
+		services.TrackConnection(sess)
 	}
 	srv.OnSessionClose = func(sess easytcp.Session) {
 		addr := sess.Conn().RemoteAddr().String()
 		log.Printf("client disconnected: %s", addr)
+		services.ParkSession(sess)
 		services.RemoveSession(sess)
 		services.RemoveSessionFromAllRooms(sess)
+		services.CloseOutbox(sess)
+		services.ForgetProtocol(sess)
+		services.ForgetConnection(sess)
 	}
 
-	registerRoutes(srv)
-
-	return &Server{srv: srv}
+	registerRoutes(srv, store)
+	return srv
 }
 
-// Run starts listening on the provided address.
-func (s *Server) Run(addr string) error {
+// Run starts listening on the provided address, for WebSocket clients on
+// wsAddr and for REST calls on httpAddr; an empty address disables that gateway.
+func (s *Server) Run(addr, wsAddr, httpAddr string) error {
 	log.Printf("listening on %s", addr)
-	return s.srv.Run(addr)
+	go services.WatchSessionExpiry()
+	go services.WatchConnections()
+	go services.WatchAutoSnapshots(s.store)
+	if wsAddr != "" {
+		go func() {
+			if err := runWebSocket(s.ws, s.packer, wsAddr); err != nil {
+				log.Printf("websocket gateway stopped: %v", err)
+			}
+		}()
+	}
+	if httpAddr != "" {
+		go func() {
+			log.Printf("rest gateway listening on http://%s%s", httpAddr, routes.HTTPPrefix)
+			srv := &http.Server{Addr: httpAddr, Handler: s.api, ReadHeaderTimeout: 10 * time.Second}
+			if err := srv.ListenAndServe(); err != nil {
+				log.Printf("rest gateway stopped: %v", err)
+			}
+		}()
+	}
+	return s.tcp.Run(addr)
 }
 
-// registerRoutes wires all message handlers.
-func registerRoutes(s *easytcp.Server) {
+// registerRoutes wires all message handlers against the given storage backend.
+func registerRoutes(s *easytcp.Server, store *services.Store) {
+	// Any inbound message keeps the connection alive for the idle reaper.
+	s.Use(routes.HeartbeatMiddleware)
+	// Translate MessagePack/Protobuf payloads to and from JSON for connections
+	// that negotiated them; handlers only ever see JSON.
+	s.Use(routes.CodecMiddleware)
+	// Echo the optional request_id into every response and log tagged requests.
+	s.Use(routes.RequestIDMiddleware)
+	// Reject clients that skipped the handshake when legacy clients are not allowed.
+	s.Use(routes.HandshakeMiddleware)
+
 	routes.RegisterEchoRoutes(s)
+
+	// Route 2: protocol handshake (version, codec, feature flags), before login.
+	routes.RegisterHandshakeRoutes(s)
+
+	// Route 3: heartbeat ping/pong.
+	routes.RegisterHeartbeatRoutes(s)
+
+	// Route 10: login; 11: refresh token; 12: token expiring/expired notice (server push).
 	routes.RegisterAuthRoutes(s)
 
+	// Route 13: resume a dropped session on a new connection.
+	routes.RegisterResumeRoutes(s, store)
+
 	// Route 201: create room.
+	// Route 204: update room; 205: delete room; 206: room updated/deleted broadcast.
 	// Route 210: list rooms.
 	// Route 211: find public rooms.
-	routes.RegisterRoomRoutes(s)
-	routes.RegisterJoinRoomRoutes(s)
-	routes.RegisterMessageRoutes(s)
+	routes.RegisterRoomRoutes(s, store)
+	routes.RegisterJoinRoomRoutes(s, store)
+
+	// Route 212: list members; 220: set member role; 221: membership broadcast;
+	// 222: kick; 223: ban; 224: unban; 225: transfer ownership; 229: removed notice (server push).
+	routes.RegisterMemberRoutes(s, store)
+
+	// Route 240: create invite; 241: list invites; 242: revoke invite; 243: regenerate room code.
+	routes.RegisterInviteRoutes(s, store)
 
-	// Route 501: create song; 510: list songs; 511: update song.
-	routes.RegisterSongRoutes(s)
+	// Route 250: room presence; 251: presence joined/left broadcast.
+	routes.RegisterPresenceRoutes(s, store)
 
-	// Route 601: create note; 602: delete note; 603: broadcast note updates; 610: list notes.
-	routes.RegisterNoteRoutes(s)
+	// Route 260: resync a room's event stream from a seq (replay or reload).
+	routes.RegisterSyncRoutes(s, store)
+
+	routes.RegisterMessageRoutes(s, store)
+
+	// Route 501: create song; 510: list songs; 511: update song; 512: song updated broadcast.
+	routes.RegisterSongRoutes(s, store)
+
+	// Route 601: create note; 602: delete note; 603: broadcast note updates;
+	// 607: update note; 608: note batch; 610: list notes.
+	routes.RegisterNoteRoutes(s, store)
 
 	// Route 604: create track; 605: delete track; 606: broadcast track updates.
-	routes.RegisterTrackRoutes(s)
+	routes.RegisterTrackRoutes(s, store)
+
+	// Routes 620/621: per-user undo/redo of note, track and song edits.
+	routes.RegisterHistoryRoutes(s, store)
+
+	// Route 630: snapshot a song; 631: list snapshots; 632: restore one;
+	// 633: snapshot restored broadcast.
+	routes.RegisterSnapshotRoutes(s, store)
 
 	// Route 701: create post; 702: delete post; 710: list posts; 711: update post.
-	routes.RegisterCommunityRoutes(s)
+	routes.RegisterCommunityRoutes(s, store)
 	routes.RegisterShazamRoutes(s)
 }
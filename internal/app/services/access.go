@@ -0,0 +1,186 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// membershipTTL bounds how long a positive membership, song or track lookup
+// is trusted.
+const membershipTTL = time.Minute
+
+var (
+	ErrNotRoomMember  = errors.New("not a member of this room")
+	ErrSongNotInRoom  = errors.New("song not found in this room")
+	ErrTrackNotInSong = errors.New("track not found in this song")
+)
+
+// accessCache remembers authorization lookups for one session. Song->room and
+// track->song links don't move, but tracks come and go (605, undo, snapshot
+// restore), so those entries are dropped by InvalidateSongTracks and, like
+// membership, expire after membershipTTL.
+type accessCache struct {
+	mu        sync.Mutex
+	members   map[string]cachedMember // room_id -> membership
+	songRoom  map[string]cachedLink   // song_id -> room_id
+	trackSong map[string]cachedLink   // track_id -> song_id
+}
+
+type cachedMember struct {
+	member RoomMember
+	at     time.Time
+}
+
+// cachedLink is the parent a song or track was found under.
+type cachedLink struct {
+	parent string
+	at     time.Time
+}
+
+func newAccessCache() *accessCache {
+	return &accessCache{
+		members:   make(map[string]cachedMember),
+		songRoom:  make(map[string]cachedLink),
+		trackSong: make(map[string]cachedLink),
+	}
+}
+
+// link returns the cached parent for id in links if it is still fresh.
+// Callers must hold c.mu.
+func (c *accessCache) link(links map[string]cachedLink, id string) (string, bool) {
+	hit, ok := links[id]
+	if !ok || time.Since(hit.at) >= membershipTTL {
+		return "", false
+	}
+	return hit.parent, true
+}
+
+// RequireMember returns the session user's membership in roomID, or ErrNotRoomMember.
+func (st *Store) RequireMember(us *UserSession, roomID string) (*RoomMember, error) {
+	c := us.access
+	c.mu.Lock()
+	if hit, ok := c.members[roomID]; ok && time.Since(hit.at) < membershipTTL {
+		c.mu.Unlock()
+		member := hit.member
+		return &member, nil
+	}
+	c.mu.Unlock()
+
+	member, err := st.Rooms.GetMembership(roomID, us.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotRoomMember
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.members[roomID] = cachedMember{member: *member, at: time.Now()}
+	c.mu.Unlock()
+	return member, nil
+}
+
+// RequireSong checks membership in roomID and that songID belongs to it.
+func (st *Store) RequireSong(us *UserSession, roomID, songID string) (*RoomMember, error) {
+	member, err := st.RequireMember(us, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := us.access
+	c.mu.Lock()
+	owner, ok := c.link(c.songRoom, songID)
+	c.mu.Unlock()
+	if !ok {
+		song, err := st.Songs.GetSong(songID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrSongNotInRoom
+		}
+		if err != nil {
+			return nil, err
+		}
+		owner = song.RoomID
+		c.mu.Lock()
+		c.songRoom[songID] = cachedLink{parent: owner, at: time.Now()}
+		c.mu.Unlock()
+	}
+	if owner != roomID {
+		return nil, ErrSongNotInRoom
+	}
+	return member, nil
+}
+
+// RequireTrack checks membership, that songID belongs to roomID and that trackID belongs to songID.
+func (st *Store) RequireTrack(us *UserSession, roomID, songID, trackID string) (*RoomMember, error) {
+	member, err := st.RequireSong(us, roomID, songID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := us.access
+	c.mu.Lock()
+	owner, ok := c.link(c.trackSong, trackID)
+	c.mu.Unlock()
+	if !ok {
+		track, err := st.Tracks.GetTrack(trackID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrTrackNotInSong
+		}
+		if err != nil {
+			return nil, err
+		}
+		owner = track.SongID
+		c.mu.Lock()
+		c.trackSong[trackID] = cachedLink{parent: owner, at: time.Now()}
+		c.mu.Unlock()
+	}
+	if owner != songID {
+		return nil, ErrTrackNotInSong
+	}
+	return member, nil
+}
+
+// InvalidateMembership drops cached membership for roomID on every session of
+// userID, e.g. after they leave or their role changes.
+func InvalidateMembership(userID, roomID string) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, us := range sessions {
+		if us.UserID != userID {
+			continue
+		}
+		us.access.mu.Lock()
+		delete(us.access.members, roomID)
+		us.access.mu.Unlock()
+	}
+}
+
+// InvalidateRoom drops cached membership for roomID on every session, e.g.
+// after the room is deleted.
+func InvalidateRoom(roomID string) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, us := range sessions {
+		us.access.mu.Lock()
+		delete(us.access.members, roomID)
+		us.access.mu.Unlock()
+	}
+}
+
+// InvalidateSongTracks drops every cached track of songID on every session,
+// after tracks were removed (605, undo of an add, snapshot restore), so the
+// next RequireTrack sees they are gone.
+func InvalidateSongTracks(songID string) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, us := range sessions {
+		us.access.mu.Lock()
+		for trackID, link := range us.access.trackSong {
+			if link.parent == songID {
+				delete(us.access.trackSong, trackID)
+			}
+		}
+		us.access.mu.Unlock()
+	}
+}
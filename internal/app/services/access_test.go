@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// ageAccessCache makes every cached lookup of us look d older.
+func ageAccessCache(us *UserSession, d time.Duration) {
+	c := us.access
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, hit := range c.members {
+		hit.at = hit.at.Add(-d)
+		c.members[id] = hit
+	}
+	for _, links := range []map[string]cachedLink{c.songRoom, c.trackSong} {
+		for id, hit := range links {
+			hit.at = hit.at.Add(-d)
+			links[id] = hit
+		}
+	}
+}
+
+func TestRequireMemberCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		forget func(us *UserSession, roomID string)
+	}{
+		{"expiry", func(us *UserSession, _ string) { ageAccessCache(us, membershipTTL) }},
+		{"InvalidateMembership", func(us *UserSession, roomID string) { InvalidateMembership(us.UserID, roomID) }},
+		{"InvalidateRoom", func(_ *UserSession, roomID string) { InvalidateRoom(roomID) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, song, _ := newTestSong(t)
+			st := &Store{Rooms: m, Songs: m, Tracks: m}
+			us := newTestSession(t, CodecJSON).login("guest-" + newStreamEpoch())
+			room, _ := m.GetRoom(song.RoomID)
+			if _, err := m.JoinRoomByCode(room.Code, us.UserID); err != nil {
+				t.Fatalf("JoinRoomByCode: %v", err)
+			}
+
+			if _, err := st.RequireMember(us, room.ID); err != nil {
+				t.Fatalf("RequireMember: %v", err)
+			}
+			if err := m.LeaveRoom(room.ID, us.UserID); err != nil {
+				t.Fatalf("LeaveRoom: %v", err)
+			}
+			if _, err := st.RequireMember(us, room.ID); err != nil {
+				t.Fatalf("cached RequireMember: %v", err)
+			}
+
+			tt.forget(us, room.ID)
+			if _, err := st.RequireMember(us, room.ID); !errors.Is(err, ErrNotRoomMember) {
+				t.Fatalf("RequireMember after %s: err = %v, want ErrNotRoomMember", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestRequireTrackCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		forget func(us *UserSession, songID string)
+	}{
+		{"expiry", func(us *UserSession, _ string) { ageAccessCache(us, membershipTTL) }},
+		{"InvalidateSongTracks", func(_ *UserSession, songID string) { InvalidateSongTracks(songID) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, song, track := newTestSong(t)
+			st := &Store{Rooms: m, Songs: m, Tracks: m}
+			us := newTestSession(t, CodecJSON).login("owner")
+
+			if _, err := st.RequireTrack(us, song.RoomID, song.ID, track.ID); err != nil {
+				t.Fatalf("RequireTrack: %v", err)
+			}
+			if err := m.DeleteTrack(track.ID, song.ID); err != nil {
+				t.Fatalf("DeleteTrack: %v", err)
+			}
+			if _, err := st.RequireTrack(us, song.RoomID, song.ID, track.ID); err != nil {
+				t.Fatalf("cached RequireTrack: %v", err)
+			}
+
+			tt.forget(us, song.ID)
+			if _, err := st.RequireTrack(us, song.RoomID, song.ID, track.ID); !errors.Is(err, ErrTrackNotInSong) {
+				t.Fatalf("RequireTrack after %s: err = %v, want ErrTrackNotInSong", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestRequireSongChecksRoom(t *testing.T) {
+	m, song, track := newTestSong(t)
+	st := &Store{Rooms: m, Songs: m, Tracks: m}
+	us := newTestSession(t, CodecJSON).login("owner")
+	other, _ := m.CreateRoom("owner", "other", false)
+
+	if _, err := st.RequireSong(us, other.ID, song.ID); !errors.Is(err, ErrSongNotInRoom) {
+		t.Fatalf("song of another room: err = %v, want ErrSongNotInRoom", err)
+	}
+	// The cached song->room link must not let the song through either.
+	if _, err := st.RequireSong(us, other.ID, song.ID); !errors.Is(err, ErrSongNotInRoom) {
+		t.Fatalf("cached song of another room: err = %v, want ErrSongNotInRoom", err)
+	}
+	if _, err := st.RequireTrack(us, song.RoomID, "missing", track.ID); !errors.Is(err, ErrSongNotInRoom) {
+		t.Fatalf("unknown song: err = %v, want ErrSongNotInRoom", err)
+	}
+	if _, err := st.RequireTrack(us, song.RoomID, song.ID, "missing"); !errors.Is(err, ErrTrackNotInSong) {
+		t.Fatalf("unknown track: err = %v, want ErrTrackNotInSong", err)
+	}
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/DarthPestilane/easytcp"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Payload codecs negotiated on route 2. Handlers always work in JSON; other
+// codecs are translated at the connection edge (CodecMiddleware for requests
+// and responses, the outbox path for pushes).
+const (
+	CodecMsgpack  = "msgpack"
+	CodecProtobuf = "protobuf"
+)
+
+// routeKey normalizes an easytcp message ID (int from the packer, int or
+// uint32 from handlers) to an int.
+func routeKey(id interface{}) int {
+	switch v := id.(type) {
+	case int:
+		return v
+	case uint32:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return -1
+}
+
+// DecodeRequest turns a request payload in codec into the JSON handlers read.
+func DecodeRequest(codec string, routeID interface{}, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecMsgpack:
+		var v interface{}
+		if err := msgpack.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("decode msgpack: %w", err)
+		}
+		return json.Marshal(v)
+	case CodecProtobuf:
+		return protoToJSON(protoRoutes[routeKey(routeID)][0], data)
+	}
+	return data, nil
+}
+
+// EncodePayload turns a JSON response or push for routeID into codec.
+func EncodePayload(codec string, routeID interface{}, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecMsgpack:
+		v, err := decodeJSONValue(data)
+		if err != nil {
+			return nil, err
+		}
+		return msgpack.Marshal(v)
+	case CodecProtobuf:
+		return jsonToProto(protoRoutes[routeKey(routeID)][1], data)
+	}
+	return data, nil
+}
+
+// packFor frames a JSON message for a connection using codec.
+func packFor(codec string, msg *easytcp.Message) ([]byte, error) {
+	data, err := EncodePayload(codec, msg.ID(), msg.Data())
+	if err != nil {
+		return nil, err
+	}
+	return roomPacker.Pack(easytcp.NewMessage(msg.ID(), data))
+}
+
+// frameCache packs one message at most once per codec during a fan-out.
+// When plain is set, msg carries a seq and plain is the same message without
+// it, for sessions that didn't negotiate FeatureEventSeq.
+type frameCache struct {
+	msg    *easytcp.Message
+	plain  *easytcp.Message
+	frames map[string][]byte
+}
+
+func newFrameCache(msg *easytcp.Message) *frameCache {
+	return &frameCache{msg: msg, frames: make(map[string][]byte)}
+}
+
+func (fc *frameCache) frameFor(sess easytcp.Session) ([]byte, error) {
+	p := ProtocolFor(sess)
+	msg, key := fc.msg, p.Codec
+	if fc.plain != nil && !p.Has(FeatureEventSeq) {
+		msg, key = fc.plain, p.Codec+"/plain"
+	}
+	if data, ok := fc.frames[key]; ok {
+		return data, nil
+	}
+	data, err := packFor(p.Codec, msg)
+	if err != nil {
+		return nil, err
+	}
+	fc.frames[key] = data
+	return data, nil
+}
+
+// decodeJSONValue decodes JSON keeping integers as int64 so msgpack encodes
+// them as integers rather than floats.
+func decodeJSONValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decode json payload: %w", err)
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = convertNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = convertNumbers(e)
+		}
+	}
+	return v
+}
+
+// protoToJSON decodes a protobuf payload of message type name (JsonPayload
+// when empty) into JSON.
+func protoToJSON(name string, data []byte) ([]byte, error) {
+	if name == "" {
+		payload := dynamicpb.NewMessage(protoMessage(protoJSONPayload))
+		if err := proto.Unmarshal(data, payload); err != nil {
+			return nil, fmt.Errorf("decode protobuf: %w", err)
+		}
+		return payload.Get(payload.Descriptor().Fields().ByName("json")).Bytes(), nil
+	}
+
+	msg := dynamicpb.NewMessage(protoMessage(name))
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("decode protobuf %s: %w", name, err)
+	}
+	return json.Marshal(protoToMap(msg))
+}
+
+// jsonToProto encodes a JSON payload as protobuf message type name
+// (JsonPayload when empty). A JSON field the message doesn't declare is an
+// error rather than being dropped: add it to proto/musick.proto.
+func jsonToProto(name string, data []byte) ([]byte, error) {
+	if name == "" {
+		payload := dynamicpb.NewMessage(protoMessage(protoJSONPayload))
+		payload.Set(payload.Descriptor().Fields().ByName("json"), protoreflect.ValueOfBytes(data))
+		return proto.Marshal(payload)
+	}
+
+	v, err := decodeJSONValue(data)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("encode protobuf %s: payload is not an object", name)
+	}
+	msg := dynamicpb.NewMessage(protoMessage(name))
+	if err := mapToProto(obj, msg); err != nil {
+		return nil, fmt.Errorf("encode protobuf %s: %w", name, err)
+	}
+	return proto.Marshal(msg)
+}
+
+func protoToMap(msg protoreflect.Message) map[string]interface{} {
+	out := make(map[string]interface{})
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.IsMap() {
+			entries := make(map[string]interface{}, v.Map().Len())
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				entries[k.String()] = protoFieldValue(fd.MapValue(), mv)
+				return true
+			})
+			out[string(fd.Name())] = entries
+		} else if fd.IsList() {
+			list := v.List()
+			items := make([]interface{}, list.Len())
+			for i := range items {
+				items[i] = protoFieldValue(fd, list.Get(i))
+			}
+			out[string(fd.Name())] = items
+		} else {
+			out[string(fd.Name())] = protoFieldValue(fd, v)
+		}
+		return true
+	})
+	return out
+}
+
+func protoFieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind:
+		return protoToMap(v.Message())
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int32(v.Enum())
+	}
+	return v.Interface()
+}
+
+func mapToProto(obj map[string]interface{}, msg protoreflect.Message) error {
+	fields := msg.Descriptor().Fields()
+	for key, raw := range obj {
+		fd := fields.ByName(protoreflect.Name(key))
+		if fd == nil {
+			return fmt.Errorf("%s: not declared in message %s", key, msg.Descriptor().FullName())
+		}
+		if raw == nil {
+			continue
+		}
+		if fd.IsMap() {
+			entries, ok := raw.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s: expected an object", key)
+			}
+			m := msg.Mutable(fd).Map()
+			for k, item := range entries {
+				mk, err := protoMapKey(fd.MapKey(), k)
+				if err != nil {
+					return fmt.Errorf("%s: key %q: %w", key, k, err)
+				}
+				if fd.MapValue().Kind() == protoreflect.MessageKind {
+					sub, ok := item.(map[string]interface{})
+					if !ok {
+						return fmt.Errorf("%s: expected objects", key)
+					}
+					elem := m.NewValue()
+					if err := mapToProto(sub, elem.Message()); err != nil {
+						return fmt.Errorf("%s: %w", key, err)
+					}
+					m.Set(mk, elem)
+					continue
+				}
+				val, err := protoScalar(fd.MapValue(), item)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				m.Set(mk, val)
+			}
+			continue
+		}
+		if fd.IsList() {
+			items, ok := raw.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s: expected a list", key)
+			}
+			list := msg.Mutable(fd).List()
+			for _, item := range items {
+				if fd.Kind() == protoreflect.MessageKind {
+					elem := list.NewElement()
+					sub, ok := item.(map[string]interface{})
+					if !ok {
+						return fmt.Errorf("%s: expected objects", key)
+					}
+					if err := mapToProto(sub, elem.Message()); err != nil {
+						return fmt.Errorf("%s: %w", key, err)
+					}
+					list.Append(elem)
+					continue
+				}
+				val, err := protoScalar(fd, item)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				list.Append(val)
+			}
+			continue
+		}
+		if fd.Kind() == protoreflect.MessageKind {
+			sub, ok := raw.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s: expected an object", key)
+			}
+			if err := mapToProto(sub, msg.Mutable(fd).Message()); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			continue
+		}
+		val, err := protoScalar(fd, raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		msg.Set(fd, val)
+	}
+	return nil
+}
+
+// protoMapKey converts a JSON object key to a map key of fd's kind.
+func protoMapKey(fd protoreflect.FieldDescriptor, k string) (protoreflect.MapKey, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(k).MapKey(), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(k)
+		if err != nil {
+			return protoreflect.MapKey{}, err
+		}
+		return protoreflect.ValueOfBool(b).MapKey(), nil
+	}
+	n, err := strconv.ParseInt(k, 10, 64)
+	if err != nil {
+		return protoreflect.MapKey{}, err
+	}
+	v, err := protoScalar(fd, n)
+	if err != nil {
+		return protoreflect.MapKey{}, err
+	}
+	return v.MapKey(), nil
+}
+
+// protoScalar converts a decoded JSON value (see decodeJSONValue) to fd's kind.
+func protoScalar(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		switch t := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(t), nil
+		case int64:
+			return protoreflect.ValueOfString(strconv.FormatInt(t, 10)), nil
+		case float64:
+			return protoreflect.ValueOfString(strconv.FormatFloat(t, 'f', -1, 64)), nil
+		}
+	case protoreflect.BoolKind:
+		if b, ok := v.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.BytesKind:
+		if s, ok := v.(string); ok {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfBytes(b), nil
+		}
+	case protoreflect.EnumKind:
+		switch t := v.(type) {
+		case string:
+			if ev := fd.Enum().Values().ByName(protoreflect.Name(t)); ev != nil {
+				return protoreflect.ValueOfEnum(ev.Number()), nil
+			}
+			return protoreflect.Value{}, fmt.Errorf("unknown %s value %q", fd.Enum().Name(), t)
+		case int64:
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(t)), nil
+		}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		var f float64
+		switch t := v.(type) {
+		case int64:
+			f = float64(t)
+		case float64:
+			f = t
+		default:
+			return protoreflect.Value{}, fmt.Errorf("expected a number, got %T", v)
+		}
+		if fd.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+		return protoreflect.ValueOfFloat64(f), nil
+	default:
+		var n int64
+		switch t := v.(type) {
+		case int64:
+			n = t
+		case float64:
+			n = int64(t)
+		default:
+			return protoreflect.Value{}, fmt.Errorf("expected a number, got %T", v)
+		}
+		switch fd.Kind() {
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+			return protoreflect.ValueOfInt32(int32(n)), nil
+		case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+			return protoreflect.ValueOfInt64(n), nil
+		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+			return protoreflect.ValueOfUint32(uint32(n)), nil
+		case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			return protoreflect.ValueOfUint64(uint64(n)), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("cannot use %T for a %s field", v, fd.Kind())
+}
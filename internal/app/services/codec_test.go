@@ -0,0 +1,174 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/DarthPestilane/easytcp"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func useProtoSchemas(t *testing.T) {
+	t.Helper()
+	if err := LoadProtoSchemas(); err != nil {
+		t.Fatalf("LoadProtoSchemas: %v", err)
+	}
+}
+
+// assertSameJSON compares two JSON documents by value.
+func assertSameJSON(t *testing.T, got, want []byte) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("decode %s: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("decode %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+// decodePush turns a push or response payload in codec back into JSON.
+func decodePush(t *testing.T, codec string, routeID int, data []byte) []byte {
+	t.Helper()
+	var (
+		out []byte
+		err error
+	)
+	switch codec {
+	case CodecProtobuf:
+		out, err = protoToJSON(protoRoutes[routeID][1], data)
+	default:
+		out, err = DecodeRequest(codec, routeID, data)
+	}
+	if err != nil {
+		t.Fatalf("decode %s payload: %v", codec, err)
+	}
+	return out
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	in := []byte(`{"step":3,"velocity":0.5,"name":"kick","tags":["a",1,true],"note":{"id":"n1"},"before":null}`)
+	packed, err := EncodePayload(CodecMsgpack, 603, in)
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+
+	var raw map[string]interface{}
+	if err := msgpack.Unmarshal(packed, &raw); err != nil {
+		t.Fatalf("msgpack.Unmarshal: %v", err)
+	}
+	if _, ok := raw["step"].(float64); ok {
+		t.Fatalf("integer step encoded as a float")
+	}
+
+	out, err := DecodeRequest(CodecMsgpack, 603, packed)
+	if err != nil {
+		t.Fatalf("DecodeRequest: %v", err)
+	}
+	assertSameJSON(t, out, in)
+
+	if _, err := DecodeRequest(CodecMsgpack, 601, []byte{0xc1}); err == nil {
+		t.Fatal("DecodeRequest accepted invalid msgpack")
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	useProtoSchemas(t)
+
+	tests := []struct {
+		name string
+		msg  string
+		json string
+	}{
+		{"request", "CreateNoteRequest", `{"room_id":"r1","song_id":"s1","track_id":"t1","step":4,"pitch":60,"velocity":100,"length_steps":2,"request_id":"q1"}`},
+		{"batch broadcast", "NoteBroadcast", `{"epoch":"e1","seq":9,"action":"batch","song_id":"s1","changes":[{"op":"update","note":{"id":"n1","step":2,"version":3},"before":{"id":"n1","step":1,"version":2}}]}`},
+		{"optional zero", "DeleteNoteResponse", `{"error":{"code":"CONFLICT","message_key":"stale_version"},"step":0,"pitch":0}`},
+		{"json payload", "", `{"songs":[{"id":"s1","bpm":120.5}],"anything":{"goes":true}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := jsonToProto(tt.msg, []byte(tt.json))
+			if err != nil {
+				t.Fatalf("jsonToProto: %v", err)
+			}
+			out, err := protoToJSON(tt.msg, data)
+			if err != nil {
+				t.Fatalf("protoToJSON: %v", err)
+			}
+			assertSameJSON(t, out, []byte(tt.json))
+		})
+	}
+
+	// Requests and pushes pick their message types from protoRoutes.
+	req := `{"room_id":"r1","song_id":"s1","track_id":"t1","step":1,"pitch":2,"expected_version":3}`
+	data, err := jsonToProto("DeleteNoteRequest", []byte(req))
+	if err != nil {
+		t.Fatalf("jsonToProto: %v", err)
+	}
+	out, err := DecodeRequest(CodecProtobuf, uint32(602), data)
+	if err != nil {
+		t.Fatalf("DecodeRequest: %v", err)
+	}
+	assertSameJSON(t, out, []byte(req))
+
+	push := `{"id":7,"room_id":"r1","sender_id":"u1","body":"hi","seq":2,"epoch":"e1"}`
+	if data, err = EncodePayload(CodecProtobuf, 302, []byte(push)); err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	assertSameJSON(t, decodePush(t, CodecProtobuf, 302, data), []byte(push))
+}
+
+func TestProtobufRejectsUndeclaredFields(t *testing.T) {
+	useProtoSchemas(t)
+	if _, err := EncodePayload(CodecProtobuf, 603, []byte(`{"action":"create","bogus":1}`)); err == nil {
+		t.Fatal("EncodePayload dropped a field NoteBroadcast doesn't declare")
+	}
+	if _, err := EncodePayload(CodecProtobuf, 603, []byte(`[1]`)); err == nil {
+		t.Fatal("EncodePayload accepted a non-object payload")
+	}
+}
+
+func TestPublishToRoomPerCodec(t *testing.T) {
+	useProtoSchemas(t)
+	roomID := testRoom(t)
+	payload := `{"action":"create","song_id":"s1","note":{"id":"n1","step":1,"pitch":60}}`
+
+	type sub struct {
+		sess    *testSession
+		codec   string
+		stamped bool
+	}
+	var subs []sub
+	for _, codec := range []string{CodecJSON, CodecMsgpack, CodecProtobuf} {
+		for _, stamped := range []bool{true, false} {
+			var sess *testSession
+			if stamped {
+				sess = newTestSession(t, codec, FeatureEventSeq)
+			} else {
+				sess = newTestSession(t, codec)
+			}
+			AddSessionToRoom(roomID, sess)
+			subs = append(subs, sub{sess, codec, stamped})
+		}
+	}
+
+	PublishToRoom(roomID, easytcp.NewMessage(603, []byte(payload)))
+	pos := RoomPos(roomID)
+	stamped := stampSeq([]byte(payload), pos)
+
+	for _, s := range subs {
+		msg := s.sess.readFrame(t)
+		if routeKey(msg.ID()) != 603 {
+			t.Fatalf("%s: route %v, want 603", s.codec, msg.ID())
+		}
+		want := []byte(payload)
+		if s.stamped {
+			want = stamped
+		}
+		assertSameJSON(t, decodePush(t, s.codec, 603, msg.Data()), want)
+	}
+}
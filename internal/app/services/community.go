@@ -38,9 +38,7 @@ type CommunityPostWithAttachments struct {
 }
 
 // CreateCommunityPost inserts a new post authored by user.
-func CreateCommunityPost(authorID, title, body string) (*CommunityPost, error) {
-	loadEnv()
-
+func (sb *Supabase) CreateCommunityPost(authorID, title, body string) (*CommunityPost, error) {
 	payload := map[string]interface{}{
 		"author_id": authorID,
 		"title":     title,
@@ -52,13 +50,13 @@ func CreateCommunityPost(authorID, title, body string) (*CommunityPost, error) {
 		return nil, fmt.Errorf("marshal post payload: %w", err)
 	}
 
-	req, _ := http.NewRequest("POST", supabaseURL+"/rest/v1/community_posts", bytes.NewReader(b))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/community_posts", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Prefer", "return=representation")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("create post: %w", err)
 	}
@@ -78,7 +76,7 @@ func CreateCommunityPost(authorID, title, body string) (*CommunityPost, error) {
 	}
 
 	post := &rows[0]
-	if name, err := fetchSenderName(authorID); err == nil {
+	if name, err := sb.fetchSenderName(authorID); err == nil {
 		post.AuthorName = name
 	}
 
@@ -86,15 +84,13 @@ func CreateCommunityPost(authorID, title, body string) (*CommunityPost, error) {
 }
 
 // DeleteCommunityPost deletes a post by id scoped to author.
-func DeleteCommunityPost(postID, authorID string) error {
-	loadEnv()
-
-	url := fmt.Sprintf("%s/rest/v1/community_posts?id=eq.%s&author_id=eq.%s", supabaseURL, postID, authorID)
+func (sb *Supabase) DeleteCommunityPost(postID, authorID string) error {
+	url := fmt.Sprintf("%s/rest/v1/community_posts?id=eq.%s&author_id=eq.%s", sb.url, postID, authorID)
 	req, _ := http.NewRequest("DELETE", url, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("delete post: %w", err)
 	}
@@ -109,9 +105,7 @@ func DeleteCommunityPost(postID, authorID string) error {
 }
 
 // UpdateCommunityPost updates title/body and returns the updated row.
-func UpdateCommunityPost(postID, authorID string, title *string, body *string) (*CommunityPost, error) {
-	loadEnv()
-
+func (sb *Supabase) UpdateCommunityPost(postID, authorID string, title *string, body *string) (*CommunityPost, error) {
 	payload := map[string]interface{}{}
 	if title != nil {
 		payload["title"] = *title
@@ -132,14 +126,14 @@ func UpdateCommunityPost(postID, authorID string, title *string, body *string) (
 		return nil, fmt.Errorf("marshal update payload: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/rest/v1/community_posts?id=eq.%s&author_id=eq.%s", supabaseURL, postID, authorID)
+	url := fmt.Sprintf("%s/rest/v1/community_posts?id=eq.%s&author_id=eq.%s", sb.url, postID, authorID)
 	req, _ := http.NewRequest("PATCH", url, bytes.NewReader(b))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Prefer", "return=representation")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("update post: %w", err)
 	}
@@ -159,7 +153,7 @@ func UpdateCommunityPost(postID, authorID string, title *string, body *string) (
 	}
 
 	post := &rows[0]
-	if name, err := fetchSenderName(authorID); err == nil {
+	if name, err := sb.fetchSenderName(authorID); err == nil {
 		post.AuthorName = name
 	}
 
@@ -167,9 +161,7 @@ func UpdateCommunityPost(postID, authorID string, title *string, body *string) (
 }
 
 // ListCommunityPosts returns posts in reverse chronological order with optional attachments.
-func ListCommunityPosts(before string, limit int, includeAttachments bool) ([]CommunityPostWithAttachments, bool, error) {
-	loadEnv()
-
+func (sb *Supabase) ListCommunityPosts(before string, limit int, includeAttachments bool) ([]CommunityPostWithAttachments, bool, error) {
 	if limit <= 0 {
 		limit = 20
 	}
@@ -190,12 +182,12 @@ func ListCommunityPosts(before string, limit int, includeAttachments bool) ([]Co
 		q.Set("created_at", "lt."+before)
 	}
 
-	endpoint := fmt.Sprintf("%s/rest/v1/community_posts?%s", supabaseURL, q.Encode())
+	endpoint := fmt.Sprintf("%s/rest/v1/community_posts?%s", sb.url, q.Encode())
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, false, fmt.Errorf("list posts: %w", err)
 	}
@@ -218,7 +210,7 @@ func ListCommunityPosts(before string, limit int, includeAttachments bool) ([]Co
 			rows[i].AuthorName = cached
 			continue
 		}
-		if name, err := fetchSenderName(authorID); err == nil {
+		if name, err := sb.fetchSenderName(authorID); err == nil {
 			rows[i].AuthorName = name
 			nameCache[authorID] = name
 		}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+const (
+	defaultHeartbeatInterval = 25 * time.Second
+	defaultLoginTimeout      = 30 * time.Second
+	// maxConnSweepInterval caps how often idle and never-authenticated
+	// connections are looked for; short timeouts sweep more often.
+	maxConnSweepInterval = 5 * time.Second
+	// keepAliveProbes is how many unanswered TCP keepalive probes mark a
+	// peer dead.
+	keepAliveProbes = 3
+)
+
+// connState is what the reaper knows about one open connection.
+type connState struct {
+	sess        easytcp.Session
+	connectedAt time.Time
+	lastSeen    time.Time // last inbound message of any route
+	loggedIn    bool      // authenticated at least once
+}
+
+var (
+	conns   = make(map[interface{}]*connState)
+	connsMu sync.Mutex
+)
+
+// HeartbeatSettings returns how often clients that negotiated the heartbeat
+// feature should ping (route 3) and how long a silent one is kept open.
+// HEARTBEAT_INTERVAL and HEARTBEAT_TIMEOUT are in seconds; the timeout
+// defaults to two intervals and a half.
+func HeartbeatSettings() (interval, timeout time.Duration) {
+	loadEnv()
+	return heartbeatInterval, heartbeatTimeout
+}
+
+// TrackConnection starts watching a new connection (on session create).
+func TrackConnection(sess easytcp.Session) {
+	now := time.Now()
+	connsMu.Lock()
+	conns[sess.ID()] = &connState{sess: sess, connectedAt: now, lastSeen: now}
+	connsMu.Unlock()
+
+	enableKeepAlive(sess.Conn())
+}
+
+// enableKeepAlive turns on TCP keepalive for plain TCP connections, so a peer
+// that vanished without closing (a phone losing signal) is noticed even if it
+// never negotiated heartbeats: after HEARTBEAT_INTERVAL of silence the kernel
+// probes it, and once HEARTBEAT_TIMEOUT passes unanswered the read fails and
+// the session closes with the usual cleanup. WebSocket connections are pinged
+// by the gateway instead.
+func enableKeepAlive(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	loadEnv()
+	probe := (heartbeatTimeout - heartbeatInterval) / keepAliveProbes
+	if probe < time.Second {
+		probe = time.Second
+	}
+	err := tcp.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     heartbeatInterval,
+		Interval: probe,
+		Count:    keepAliveProbes,
+	})
+	if err != nil {
+		log.Printf("enable keepalive for %s failed: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// TouchConnection records inbound traffic on sess; any message counts as a
+// heartbeat.
+func TouchConnection(sess easytcp.Session) {
+	authenticated := IsAuthenticated(sess)
+	connsMu.Lock()
+	if c, ok := conns[sess.ID()]; ok {
+		c.lastSeen = time.Now()
+		c.loggedIn = c.loggedIn || authenticated
+	}
+	connsMu.Unlock()
+}
+
+// ForgetConnection stops watching sess (on session close).
+func ForgetConnection(sess easytcp.Session) {
+	connsMu.Lock()
+	delete(conns, sess.ID())
+	connsMu.Unlock()
+}
+
+// WatchConnections closes connections that never logged in within
+// LOGIN_TIMEOUT and those that negotiated heartbeats but went silent for
+// HEARTBEAT_TIMEOUT. Closing runs the usual OnSessionClose cleanup, so the
+// session leaves its rooms and is parked for resumption. Connections without
+// the feature are covered by TCP keepalive (enableKeepAlive) or WebSocket
+// pings instead, which close them the same way. It never returns.
+func WatchConnections() {
+	loadEnv()
+	every := maxConnSweepInterval
+	for _, d := range []time.Duration{heartbeatTimeout / 5, loginTimeout / 5} {
+		if d > 0 && d < every {
+			every = d
+		}
+	}
+	if every < time.Second {
+		every = time.Second
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		sweepConnections(now)
+	}
+}
+
+func sweepConnections(now time.Time) {
+	loadEnv()
+
+	type reaped struct {
+		sess   easytcp.Session
+		reason string
+	}
+	var closing []reaped
+
+	connsMu.Lock()
+	for id, c := range conns {
+		if !c.loggedIn && IsAuthenticated(c.sess) {
+			c.loggedIn = true
+		}
+		switch {
+		case !c.loggedIn && loginTimeout > 0 && now.Sub(c.connectedAt) > loginTimeout:
+			closing = append(closing, reaped{c.sess, "no login within " + loginTimeout.String()})
+		case now.Sub(c.lastSeen) > heartbeatTimeout && ProtocolFor(c.sess).Has(FeatureHeartbeat):
+			closing = append(closing, reaped{c.sess, "no heartbeat for " + now.Sub(c.lastSeen).Round(time.Second).String()})
+		default:
+			continue
+		}
+		delete(conns, id)
+	}
+	connsMu.Unlock()
+
+	for _, r := range closing {
+		log.Printf("closing %s: %s", r.sess.Conn().RemoteAddr(), r.reason)
+		r.sess.Close()
+	}
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// maxEditHistory is how many edits each user can undo per song.
+const maxEditHistory = 100
+
+// historyIdleTTL is how long a user's history of a song is kept after they
+// last recorded, undid or redid an edit there.
+const historyIdleTTL = time.Hour
+
+// Kinds of recorded edits.
+const (
+	EditNotes       = "notes"        // 601, 602, 607, 608: Notes
+	EditTrackAdd    = "track_add"    // 604: Track
+	EditTrackRemove = "track_remove" // 605: Track, and in Notes the notes it took along
+	EditSong        = "song"         // 511: Before and After
+)
+
+// Edit is one undoable change to a song. Notes and tracks keep their IDs
+// when an edit is undone or redone, so older entries stay valid.
+type Edit struct {
+	Kind   string
+	SongID string
+	Notes  []NoteChange
+	Track  *Track
+	Before *Song
+	After  *Song
+	At     time.Time
+}
+
+type historyKey struct {
+	songID string
+	userID string
+}
+
+// editHistory is one user's undo and redo stacks for one song, newest last.
+type editHistory struct {
+	undo     []Edit
+	redo     []Edit
+	lastUsed time.Time
+}
+
+var (
+	histories   = make(map[string]map[historyKey]*editHistory) // by room
+	historiesMu sync.Mutex
+)
+
+// historyFor returns the user's history of a song, created when create is
+// set and nil otherwise if there is none. Callers must hold historiesMu.
+func historyFor(roomID, songID, userID string, create bool) *editHistory {
+	key := historyKey{songID, userID}
+	if h, ok := histories[roomID][key]; ok {
+		h.lastUsed = time.Now()
+		return h
+	}
+	if !create {
+		return nil
+	}
+	room, ok := histories[roomID]
+	if !ok {
+		room = make(map[historyKey]*editHistory)
+		histories[roomID] = room
+	}
+	h := &editHistory{lastUsed: time.Now()}
+	room[key] = h
+	return h
+}
+
+// RecordEdit adds a fresh edit by userID to the song's history, clears that
+// user's redo stack and queues the song for the next automatic snapshot.
+func RecordEdit(roomID, userID string, e Edit) {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	historiesMu.Lock()
+	h := historyFor(roomID, e.SongID, userID, true)
+	h.undo = pushEdit(h.undo, e)
+	h.redo = nil
+	historiesMu.Unlock()
+	MarkSongEdited(e.SongID, userID)
+}
+
+// PopEdit takes the user's newest undoable (or, with redo, redoable) edit
+// off its stack; see PushEdit for where it goes next.
+func PopEdit(roomID, songID, userID string, redo bool) (Edit, bool) {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+	h := historyFor(roomID, songID, userID, false)
+	if h == nil {
+		return Edit{}, false
+	}
+	stack := &h.undo
+	if redo {
+		stack = &h.redo
+	}
+	if len(*stack) == 0 {
+		return Edit{}, false
+	}
+	e := (*stack)[len(*stack)-1]
+	*stack = (*stack)[:len(*stack)-1]
+	return e, true
+}
+
+// PushEdit puts e on the user's redo stack (toRedo) or undo stack, leaving
+// the other alone: an undone edit goes to redo, a redone one back to undo,
+// and one that failed to apply back where PopEdit took it from.
+func PushEdit(roomID, userID string, e Edit, toRedo bool) {
+	historiesMu.Lock()
+	h := historyFor(roomID, e.SongID, userID, true)
+	if toRedo {
+		h.redo = pushEdit(h.redo, e)
+	} else {
+		h.undo = pushEdit(h.undo, e)
+	}
+	historiesMu.Unlock()
+}
+
+// HistoryDepth returns how many edits the user can undo and redo on a song.
+func HistoryDepth(roomID, songID, userID string) (undo, redo int) {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+	h := historyFor(roomID, songID, userID, false)
+	if h == nil {
+		return 0, 0
+	}
+	return len(h.undo), len(h.redo)
+}
+
+func pushEdit(stack []Edit, e Edit) []Edit {
+	stack = append(stack, e)
+	if len(stack) > maxEditHistory {
+		stack = append(stack[:0], stack[len(stack)-maxEditHistory:]...)
+	}
+	return stack
+}
+
+// dropRoomHistory forgets every history in a room (after it is deleted).
+func dropRoomHistory(roomID string) {
+	historiesMu.Lock()
+	delete(histories, roomID)
+	historiesMu.Unlock()
+}
+
+// DropSongHistory forgets every user's history of a song (after a snapshot
+// replaced its content).
+func DropSongHistory(roomID, songID string) {
+	historiesMu.Lock()
+	for key := range histories[roomID] {
+		if key.songID == songID {
+			delete(histories[roomID], key)
+		}
+	}
+	if len(histories[roomID]) == 0 {
+		delete(histories, roomID)
+	}
+	historiesMu.Unlock()
+}
+
+// sweepHistories forgets histories nobody has used for historyIdleTTL, so
+// memory is bounded by recent editors rather than everyone who ever edited.
+func sweepHistories(now time.Time) {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+	for roomID, room := range histories {
+		for key, h := range room {
+			if now.Sub(h.lastUsed) >= historyIdleTTL {
+				delete(room, key)
+			}
+		}
+		if len(room) == 0 {
+			delete(histories, roomID)
+		}
+	}
+}
+
+// NoteChangesInPlace reports whether notes, a song's current notes, are as
+// changes left them (or, for redo, as they were before them): every note
+// the changes end with is there unchanged and every note they removed is
+// gone. Anything else means someone edited those notes since.
+func NoteChangesInPlace(notes []Note, changes []NoteChange, redo bool) bool {
+	want := make(map[string]*Note)
+	if redo {
+		for i := len(changes) - 1; i >= 0; i-- {
+			c := changes[i]
+			if c.Before != nil {
+				want[c.Before.ID] = c.Before
+			} else {
+				want[c.Note.ID] = nil
+			}
+		}
+	} else {
+		for _, c := range changes {
+			if c.Note != nil {
+				want[c.Note.ID] = c.Note
+			} else {
+				want[c.Before.ID] = nil
+			}
+		}
+	}
+
+	current := make(map[string]Note, len(notes))
+	for _, n := range notes {
+		current[n.ID] = n
+	}
+	for id, w := range want {
+		n, ok := current[id]
+		switch {
+		case w == nil && ok, w != nil && !ok:
+			return false
+		case w != nil && !sameNote(n, *w):
+			return false
+		}
+	}
+	return true
+}
+
+// NoteOpsFor returns the ops that revert changes (or, for redo, apply them
+// again), restoring deleted notes under their old IDs and authors.
+func NoteOpsFor(changes []NoteChange, redo bool) []NoteOp {
+	ops := make([]NoteOp, 0, len(changes))
+	if redo {
+		for _, c := range changes {
+			switch {
+			case c.Before == nil:
+				ops = append(ops, NoteOp{Op: NoteOpCreate, NoteID: c.Note.ID, CreatedBy: c.Note.CreatedBy, NotePatch: patchTo(*c.Note)})
+			case c.Note == nil:
+				ops = append(ops, NoteOp{Op: NoteOpDelete, NoteID: c.Before.ID})
+			default:
+				ops = append(ops, NoteOp{Op: NoteOpUpdate, NoteID: c.Note.ID, NotePatch: patchTo(*c.Note)})
+			}
+		}
+		return ops
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		switch {
+		case c.Before == nil:
+			ops = append(ops, NoteOp{Op: NoteOpDelete, NoteID: c.Note.ID})
+		case c.Note == nil:
+			ops = append(ops, NoteOp{Op: NoteOpCreate, NoteID: c.Before.ID, CreatedBy: c.Before.CreatedBy, NotePatch: patchTo(*c.Before)})
+		default:
+			ops = append(ops, NoteOp{Op: NoteOpUpdate, NoteID: c.Before.ID, NotePatch: patchTo(*c.Before)})
+		}
+	}
+	return ops
+}
+
+// patchTo returns a patch that sets every editable field to n's.
+func patchTo(n Note) NotePatch {
+	trackID, step, pitch, velocity, length := n.TrackID, n.Step, n.Pitch, n.Velocity, n.LengthSteps
+	return NotePatch{TrackID: &trackID, Step: &step, Pitch: &pitch, Velocity: &velocity, LengthSteps: &length}
+}
+
+func sameNote(a, b Note) bool {
+	return a.TrackID == b.TrackID && a.Step == b.Step && a.Pitch == b.Pitch && a.Velocity == b.Velocity && a.LengthSteps == b.LengthSteps
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// noteContent is a note without the fields undo doesn't restore (version,
+// creation time).
+type noteContent struct {
+	TrackID                            string
+	Step, Pitch, Velocity, LengthSteps int
+	CreatedBy                          string
+}
+
+func songContent(t *testing.T, m *Memory, songID string) map[string]noteContent {
+	t.Helper()
+	notes, err := m.ListNotesBySong(songID, "")
+	if err != nil {
+		t.Fatalf("ListNotesBySong: %v", err)
+	}
+	content := make(map[string]noteContent, len(notes))
+	for _, n := range notes {
+		content[n.ID] = noteContent{n.TrackID, n.Step, n.Pitch, n.Velocity, n.LengthSteps, n.CreatedBy}
+	}
+	return content
+}
+
+func sameContent(a, b map[string]noteContent) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, n := range a {
+		if other, ok := b[id]; !ok || other != n {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNoteUndoRedoRoundTrip(t *testing.T) {
+	m, song, track := newTestSong(t)
+	kept, _ := m.CreateNote(song.ID, track.ID, 0, 30, 100, 1, "owner")
+	gone, _ := m.CreateNote(song.ID, track.ID, 1, 30, 100, 1, "owner")
+	before := songContent(t, m, song.ID)
+
+	// One batch that creates, moves (twice, to check ordering) and deletes.
+	changes, err := m.ApplyNoteBatch(song.ID, "u1", []NoteOp{
+		createOp(track.ID, 2, 30),
+		{Op: NoteOpUpdate, NoteID: kept.ID, NotePatch: NotePatch{Step: intp(5)}},
+		{Op: NoteOpUpdate, NoteID: kept.ID, NotePatch: NotePatch{Step: intp(6), Velocity: intp(40)}},
+		{Op: NoteOpDelete, NoteID: gone.ID},
+	})
+	if err != nil {
+		t.Fatalf("ApplyNoteBatch: %v", err)
+	}
+	after := songContent(t, m, song.ID)
+
+	notes, _ := m.ListNotesBySong(song.ID, "")
+	if !NoteChangesInPlace(notes, changes, false) {
+		t.Fatal("changes not in place right after applying them")
+	}
+	if _, err := m.ApplyNoteBatch(song.ID, "u1", NoteOpsFor(changes, false)); err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	if got := songContent(t, m, song.ID); !sameContent(got, before) {
+		t.Fatalf("after undo %v, want %v", got, before)
+	}
+
+	notes, _ = m.ListNotesBySong(song.ID, "")
+	if NoteChangesInPlace(notes, changes, false) {
+		t.Fatal("undone changes still reported in place for undo")
+	}
+	if !NoteChangesInPlace(notes, changes, true) {
+		t.Fatal("undone changes not in place for redo")
+	}
+	if _, err := m.ApplyNoteBatch(song.ID, "u1", NoteOpsFor(changes, true)); err != nil {
+		t.Fatalf("redo: %v", err)
+	}
+	if got := songContent(t, m, song.ID); !sameContent(got, after) {
+		t.Fatalf("after redo %v, want %v", got, after)
+	}
+}
+
+func TestNoteChangesInPlaceSeesOtherEdits(t *testing.T) {
+	m, song, track := newTestSong(t)
+	changes, err := m.ApplyNoteBatch(song.ID, "u1", []NoteOp{createOp(track.ID, 2, 30), createOp(track.ID, 3, 30)})
+	if err != nil {
+		t.Fatalf("ApplyNoteBatch: %v", err)
+	}
+	created := *changes[1].Note
+
+	tests := []struct {
+		name string
+		edit func() error
+	}{
+		{"note changed", func() error {
+			_, err := m.UpdateNote(created.ID, NotePatch{Velocity: intp(1)}, 0)
+			return err
+		}},
+		{"note deleted", func() error {
+			_, err := m.DeleteNote(song.ID, track.ID, created.Step, created.Pitch, 0)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.edit(); err != nil {
+				t.Fatalf("edit: %v", err)
+			}
+			notes, _ := m.ListNotesBySong(song.ID, "")
+			if NoteChangesInPlace(notes, changes, false) {
+				t.Fatal("undo would overwrite someone else's edit")
+			}
+			// Put the note back as the batch left it for the next case.
+			m.mu.Lock()
+			m.notes[created.ID] = created
+			m.mu.Unlock()
+		})
+	}
+}
+
+func TestEditHistoryStacks(t *testing.T) {
+	roomID := "room-" + newStreamEpoch()
+	t.Cleanup(func() { dropRoomHistory(roomID) })
+
+	if undo, redo := HistoryDepth(roomID, "s1", "u1"); undo != 0 || redo != 0 {
+		t.Fatalf("empty HistoryDepth = %d, %d", undo, redo)
+	}
+	if _, ok := PopEdit(roomID, "s1", "u1", false); ok {
+		t.Fatal("PopEdit found an edit in an empty history")
+	}
+	historiesMu.Lock()
+	_, allocated := histories[roomID]
+	historiesMu.Unlock()
+	if allocated {
+		t.Fatal("reading an empty history created one")
+	}
+
+	RecordEdit(roomID, "u1", Edit{Kind: EditSong, SongID: "s1"})
+	RecordEdit(roomID, "u1", Edit{Kind: EditNotes, SongID: "s1"})
+	e, ok := PopEdit(roomID, "s1", "u1", false)
+	if !ok || e.Kind != EditNotes {
+		t.Fatalf("PopEdit = %+v, %v; want the newest edit", e, ok)
+	}
+	PushEdit(roomID, "u1", e, true)
+	if undo, redo := HistoryDepth(roomID, "s1", "u1"); undo != 1 || redo != 1 {
+		t.Fatalf("after undo HistoryDepth = %d, %d; want 1, 1", undo, redo)
+	}
+	if undo, _ := HistoryDepth(roomID, "s1", "u2"); undo != 0 {
+		t.Fatal("another user shares u1's history")
+	}
+
+	// A fresh edit clears the redo stack.
+	RecordEdit(roomID, "u1", Edit{Kind: EditNotes, SongID: "s1"})
+	if undo, redo := HistoryDepth(roomID, "s1", "u1"); undo != 2 || redo != 0 {
+		t.Fatalf("after a new edit HistoryDepth = %d, %d; want 2, 0", undo, redo)
+	}
+
+	for i := 0; i < maxEditHistory+5; i++ {
+		RecordEdit(roomID, "u1", Edit{Kind: EditNotes, SongID: "s1"})
+	}
+	if undo, _ := HistoryDepth(roomID, "s1", "u1"); undo != maxEditHistory {
+		t.Fatalf("undo depth = %d, want capped at %d", undo, maxEditHistory)
+	}
+}
+
+func TestSweepHistories(t *testing.T) {
+	roomID := "room-" + newStreamEpoch()
+	t.Cleanup(func() { dropRoomHistory(roomID) })
+	RecordEdit(roomID, "idle", Edit{Kind: EditNotes, SongID: "s1"})
+	RecordEdit(roomID, "busy", Edit{Kind: EditNotes, SongID: "s1"})
+
+	now := time.Now()
+	historiesMu.Lock()
+	histories[roomID][historyKey{"s1", "idle"}].lastUsed = now.Add(-historyIdleTTL)
+	historiesMu.Unlock()
+
+	sweepHistories(now)
+	if undo, _ := HistoryDepth(roomID, "s1", "idle"); undo != 0 {
+		t.Fatal("idle history survived the sweep")
+	}
+	if undo, _ := HistoryDepth(roomID, "s1", "busy"); undo != 1 {
+		t.Fatal("recently used history was swept")
+	}
+
+	// Reading a history counts as using it, so sweep past the last read.
+	sweepHistories(time.Now().Add(historyIdleTTL))
+	historiesMu.Lock()
+	_, ok := histories[roomID]
+	historiesMu.Unlock()
+	if ok {
+		t.Fatal("room with no histories left was kept")
+	}
+}
+
+func TestDeleteTrackIfNotes(t *testing.T) {
+	m, song, track := newTestSong(t)
+	n, _ := m.CreateNote(song.ID, track.ID, 0, 30, 100, 1, "owner")
+	versions := map[string]int{n.ID: 1}
+
+	// Someone edits the track's notes between the check and the delete.
+	if _, err := m.UpdateNote(n.ID, NotePatch{Velocity: intp(5)}, 0); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	if err := m.DeleteTrackIfNotes(track.ID, song.ID, versions); !errors.Is(err, ErrStaleVersion) {
+		t.Fatalf("err = %v, want ErrStaleVersion", err)
+	}
+	extra, _ := m.CreateNote(song.ID, track.ID, 1, 30, 100, 1, "other")
+	if err := m.DeleteTrackIfNotes(track.ID, song.ID, map[string]int{n.ID: 2}); !errors.Is(err, ErrStaleVersion) {
+		t.Fatalf("with a note added: err = %v, want ErrStaleVersion", err)
+	}
+	if _, err := m.GetTrack(track.ID); err != nil {
+		t.Fatalf("track deleted despite the changes: %v", err)
+	}
+
+	if err := m.DeleteTrackIfNotes(track.ID, song.ID, map[string]int{n.ID: 2, extra.ID: 1}); err != nil {
+		t.Fatalf("DeleteTrackIfNotes: %v", err)
+	}
+	if notes, _ := m.ListNotesBySong(song.ID, ""); len(notes) != 0 {
+		t.Fatalf("track's notes left behind: %+v", notes)
+	}
+	if err := m.DeleteTrackIfNotes(track.ID, song.ID, nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second delete: err = %v, want ErrNotFound", err)
+	}
+}
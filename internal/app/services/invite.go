@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// roomCodeAlphabet avoids look-alike characters so codes are easy to read out.
+const roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	ErrInviteRequired = errors.New("this room is private; ask the owner for an invite")
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteExpired  = errors.New("invite has expired")
+	ErrInviteUsedUp   = errors.New("invite has no uses left")
+)
+
+// Invite is a room_invites row. MaxUses 0 means unlimited; an empty Role joins
+// with the default member role.
+type Invite struct {
+	Token     string    `json:"token"`
+	RoomID    string    `json:"room_id"`
+	CreatedBy string    `json:"created_by"`
+	Role      string    `json:"role,omitempty"`
+	MaxUses   int       `json:"max_uses"`
+	Uses      int       `json:"uses"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// usable checks expiry and remaining uses at now.
+func (inv *Invite) usable(now time.Time) error {
+	if !now.Before(inv.ExpiresAt) {
+		return ErrInviteExpired
+	}
+	if inv.MaxUses > 0 && inv.Uses >= inv.MaxUses {
+		return ErrInviteUsedUp
+	}
+	return nil
+}
+
+// joinRole is the room_members role an invite grants.
+func (inv *Invite) joinRole() string {
+	if inv.Role == "" {
+		return roleLegacyMember
+	}
+	return inv.Role
+}
+
+// newRandomToken returns an unguessable URL-safe token (invites, resume tokens).
+func newRandomToken() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomRoomCode returns a 6-character code from roomCodeAlphabet.
+func randomRoomCode() (string, error) {
+	code := make([]byte, 6)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(roomCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = roomCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// CreateInvite inserts a new invite for the room.
+func (sb *Supabase) CreateInvite(roomID, createdBy, role string, maxUses int, expiresAt time.Time) (*Invite, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	payload := map[string]interface{}{
+		"token":      token,
+		"room_id":    roomID,
+		"created_by": createdBy,
+		"max_uses":   maxUses,
+		"expires_at": expiresAt.UTC(),
+	}
+	if role != "" {
+		payload["role"] = role
+	}
+	body, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/rest/v1/room_invites", sb.url), bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("create invite: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("create invite failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []Invite
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode invite: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("create invite returned no rows")
+	}
+	return &rows[0], nil
+}
+
+// fetchInvites runs a room_invites query with the given filters.
+func (sb *Supabase) fetchInvites(q url.Values) ([]Invite, error) {
+	q.Set("select", "token,room_id,created_by,role,max_uses,uses,expires_at,created_at")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/room_invites?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch invites: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch invites failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []Invite
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode invites: %w", err)
+	}
+	return rows, nil
+}
+
+// ListInvites returns the room's invites, newest first.
+func (sb *Supabase) ListInvites(roomID string) ([]Invite, error) {
+	q := url.Values{}
+	q.Set("room_id", "eq."+roomID)
+	q.Set("order", "created_at.desc")
+	return sb.fetchInvites(q)
+}
+
+// RevokeInvite deletes an invite, or returns ErrNotFound.
+func (sb *Supabase) RevokeInvite(roomID, token string) error {
+	endpoint := fmt.Sprintf("%s/rest/v1/room_invites?room_id=eq.%s&token=eq.%s", sb.url, url.QueryEscape(roomID), url.QueryEscape(token))
+	req, _ := http.NewRequest("DELETE", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("revoke invite request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("revoke invite failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []Invite
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return fmt.Errorf("decode revoked invite: %w", err)
+	}
+	if len(rows) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RedeemInvite joins userID to the invite's room with the invite's role.
+// Existing members are let through without spending a use. The use counter is
+// bumped with a compare-and-set on the previous value so concurrent redemptions
+// can never exceed max_uses.
+func (sb *Supabase) RedeemInvite(token, userID string) (*Room, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		q := url.Values{}
+		q.Set("token", "eq."+token)
+		q.Set("limit", "1")
+		invites, err := sb.fetchInvites(q)
+		if err != nil {
+			return nil, err
+		}
+		if len(invites) == 0 {
+			return nil, ErrInviteNotFound
+		}
+		inv := invites[0]
+
+		room, err := sb.GetRoom(inv.RoomID)
+		if err != nil {
+			return nil, err
+		}
+		banned, err := sb.IsBanned(room.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return nil, ErrBanned
+		}
+		if _, err := sb.GetMembership(room.ID, userID); err == nil {
+			return room, nil
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		if err := inv.usable(time.Now()); err != nil {
+			return nil, err
+		}
+
+		claimed, err := sb.claimInviteUse(inv)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := sb.insertMembership(room.ID, userID, inv.joinRole()); err != nil {
+			return nil, err
+		}
+		return room, nil
+	}
+	return nil, fmt.Errorf("invite is busy, try again")
+}
+
+// claimInviteUse increments uses if it still equals inv.Uses.
+func (sb *Supabase) claimInviteUse(inv Invite) (bool, error) {
+	body, _ := json.Marshal(map[string]int{"uses": inv.Uses + 1})
+	endpoint := fmt.Sprintf("%s/rest/v1/room_invites?token=eq.%s&uses=eq.%d", sb.url, url.QueryEscape(inv.Token), inv.Uses)
+	req, _ := http.NewRequest("PATCH", endpoint, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("claim invite request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("claim invite failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []Invite
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return false, fmt.Errorf("decode invite claim: %w", err)
+	}
+	return len(rows) > 0, nil
+}
+
+// RegenerateRoomCode replaces the room's join code; old codes stop working.
+func (sb *Supabase) RegenerateRoomCode(roomID string) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomRoomCode()
+		if err != nil {
+			return "", err
+		}
+
+		body, _ := json.Marshal(map[string]string{"code": code})
+		endpoint := fmt.Sprintf("%s/rest/v1/rooms?id=eq.%s", sb.url, url.QueryEscape(roomID))
+		req, _ := http.NewRequest("PATCH", endpoint, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+		req.Header.Set("apikey", sb.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Prefer", "return=representation")
+
+		resp, err := sb.client.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("regenerate code request failed: %w", err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			var rows []Room
+			if err := json.Unmarshal(b, &rows); err != nil {
+				return "", fmt.Errorf("decode regenerated code: %w", err)
+			}
+			if len(rows) == 0 {
+				return "", ErrNotFound
+			}
+			return code, nil
+		case http.StatusConflict:
+			// Unique violation on rooms.code; pick another one.
+			continue
+		default:
+			return "", fmt.Errorf("regenerate code failed (status %d): %s", resp.StatusCode, b)
+		}
+	}
+	return "", fmt.Errorf("could not allocate a unique room code")
+}
@@ -3,22 +3,30 @@ package services
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
-// JoinRoomByCode looks up room by code and inserts membership. Returns room details.
-func JoinRoomByCode(code, userID string) (*Room, error) {
-	loadEnv()
+// RoomMember is a room_members row.
+type RoomMember struct {
+	RoomID    string `json:"room_id"`
+	AccountID string `json:"account_id"`
+	Role      string `json:"role"`
+}
 
+// JoinRoomByCode looks up room by code and inserts membership. Returns room details.
+// Private rooms return ErrInviteRequired unless the user is already a member.
+func (sb *Supabase) JoinRoomByCode(code, userID string) (*Room, error) {
 	// Step 1: find room details by code
-	findReq, _ := http.NewRequest("GET", fmt.Sprintf("%s/rest/v1/rooms?code=eq.%s&select=id,code,owner_id,title,is_private,created_at&limit=1", supabaseURL, code), nil)
-	findReq.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	findReq.Header.Set("apikey", supabaseAPIKey)
+	findReq, _ := http.NewRequest("GET", fmt.Sprintf("%s/rest/v1/rooms?code=eq.%s&select=id,code,owner_id,title,is_private,description,created_at&limit=1", sb.url, code), nil)
+	findReq.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	findReq.Header.Set("apikey", sb.apiKey)
 
-	findResp, err := http.DefaultClient.Do(findReq)
+	findResp, err := sb.client.Do(findReq)
 	if err != nil {
 		return nil, fmt.Errorf("lookup room by code: %w", err)
 	}
@@ -30,68 +38,124 @@ func JoinRoomByCode(code, userID string) (*Room, error) {
 	}
 
 	var rooms []struct {
-		ID        string    `json:"id"`
-		Code      string    `json:"code"`
-		OwnerID   string    `json:"owner_id"`
-		Title     string    `json:"title"`
-		IsPrivate bool      `json:"is_private"`
-		CreatedAt time.Time `json:"created_at"`
+		ID          string    `json:"id"`
+		Code        string    `json:"code"`
+		OwnerID     string    `json:"owner_id"`
+		Title       string    `json:"title"`
+		IsPrivate   bool      `json:"is_private"`
+		Description string    `json:"description"`
+		CreatedAt   time.Time `json:"created_at"`
 	}
 	if err := json.NewDecoder(findResp.Body).Decode(&rooms); err != nil {
 		return nil, fmt.Errorf("decode room lookup: %w", err)
 	}
 	if len(rooms) == 0 {
-		return nil, fmt.Errorf("room not found")
+		return nil, fmt.Errorf("room not found: %w", ErrNotFound)
 	}
 	room := rooms[0]
 	roomID := room.ID
 
-	// Step 2: insert membership (idempotent upsert on PK room_id+account_id)
+	banned, err := sb.IsBanned(roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, ErrBanned
+	}
+
+	if room.IsPrivate {
+		// Private rooms only let existing members back in; newcomers need an invite.
+		if _, err := sb.GetMembership(roomID, userID); errors.Is(err, ErrNotFound) {
+			return nil, ErrInviteRequired
+		} else if err != nil {
+			return nil, err
+		}
+	} else if err := sb.insertMembership(roomID, userID, roleLegacyMember); err != nil {
+		return nil, err
+	}
+
+	return &Room{
+		ID:          room.ID,
+		Code:        room.Code,
+		OwnerID:     room.OwnerID,
+		Title:       room.Title,
+		IsPrivate:   room.IsPrivate,
+		Description: room.Description,
+		CreatedAt:   room.CreatedAt,
+	}, nil
+}
+
+// insertMembership adds a room_members row; an existing row is left untouched.
+func (sb *Supabase) insertMembership(roomID, userID, role string) error {
+	// Idempotent insert on PK room_id+account_id.
 	payload := map[string]interface{}{
 		"room_id":    roomID,
 		"account_id": userID,
-		"role":       "member",
+		"role":       role,
 	}
 	body, _ := json.Marshal(payload)
 
-	insertReq, _ := http.NewRequest("POST", fmt.Sprintf("%s/rest/v1/room_members", supabaseURL), bytes.NewReader(body))
-	insertReq.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	insertReq.Header.Set("apikey", supabaseAPIKey)
+	insertReq, _ := http.NewRequest("POST", fmt.Sprintf("%s/rest/v1/room_members", sb.url), bytes.NewReader(body))
+	insertReq.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	insertReq.Header.Set("apikey", sb.apiKey)
 	insertReq.Header.Set("Content-Type", "application/json")
 	insertReq.Header.Set("Prefer", "resolution=ignore-duplicates")
 
-	insertResp, err := http.DefaultClient.Do(insertReq)
+	insertResp, err := sb.client.Do(insertReq)
 	if err != nil {
-		return nil, fmt.Errorf("insert membership: %w", err)
+		return fmt.Errorf("insert membership: %w", err)
 	}
 	defer insertResp.Body.Close()
 
 	if insertResp.StatusCode != 201 && insertResp.StatusCode != 204 {
 		b, _ := io.ReadAll(insertResp.Body)
-		return nil, fmt.Errorf("insert membership failed (status %d): %s", insertResp.StatusCode, b)
+		return fmt.Errorf("insert membership failed (status %d): %s", insertResp.StatusCode, b)
 	}
+	return nil
+}
 
-	return &Room{
-		ID:        room.ID,
-		Code:      room.Code,
-		OwnerID:   room.OwnerID,
-		Title:     room.Title,
-		IsPrivate: room.IsPrivate,
-		CreatedAt: room.CreatedAt,
-	}, nil
+// GetRoom fetches a room by id, or ErrNotFound.
+func (sb *Supabase) GetRoom(roomID string) (*Room, error) {
+	q := url.Values{}
+	q.Set("select", "id,code,owner_id,title,is_private,description,created_at")
+	q.Set("id", "eq."+roomID)
+	q.Set("limit", "1")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/rooms?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch room: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch room failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []Room
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode room: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+	return &rows[0], nil
 }
 
 // LeaveRoom removes a user's membership from a room.
-func LeaveRoom(roomID, userID string) error {
-	loadEnv()
-
-	endpoint := fmt.Sprintf("%s/rest/v1/room_members?room_id=eq.%s&account_id=eq.%s", supabaseURL, roomID, userID)
+func (sb *Supabase) LeaveRoom(roomID, userID string) error {
+	endpoint := fmt.Sprintf("%s/rest/v1/room_members?room_id=eq.%s&account_id=eq.%s", sb.url, roomID, userID)
 	req, _ := http.NewRequest("DELETE", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Prefer", "return=minimal")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("leave room request failed: %w", err)
 	}
@@ -104,3 +168,113 @@ func LeaveRoom(roomID, userID string) error {
 
 	return nil
 }
+
+// memberRow is a room_members row with the room's owner_id embedded.
+type memberRow struct {
+	RoomMember
+	Rooms struct {
+		OwnerID string `json:"owner_id"`
+	} `json:"rooms"`
+}
+
+// member normalizes the stored role; rooms.owner_id is authoritative for ownership.
+func (r memberRow) member() RoomMember {
+	m := r.RoomMember
+	if r.Rooms.OwnerID == m.AccountID {
+		m.Role = RoleOwner
+	} else {
+		m.Role = normalizeRole(m.Role)
+		if m.Role == RoleOwner {
+			m.Role = RoleEditor
+		}
+	}
+	return m
+}
+
+// fetchMembers runs a room_members query with the given filters.
+func (sb *Supabase) fetchMembers(q url.Values) ([]RoomMember, error) {
+	q.Set("select", "room_id,account_id,role,rooms(owner_id)")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/room_members?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch membership: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch membership failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []memberRow
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode membership: %w", err)
+	}
+
+	members := make([]RoomMember, 0, len(rows))
+	for _, r := range rows {
+		members = append(members, r.member())
+	}
+	return members, nil
+}
+
+// GetMembership fetches the user's room_members row, or ErrNotFound.
+func (sb *Supabase) GetMembership(roomID, userID string) (*RoomMember, error) {
+	q := url.Values{}
+	q.Set("room_id", "eq."+roomID)
+	q.Set("account_id", "eq."+userID)
+	q.Set("limit", "1")
+
+	members, err := sb.fetchMembers(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, ErrNotFound
+	}
+	return &members[0], nil
+}
+
+// ListMembers returns every member of a room.
+func (sb *Supabase) ListMembers(roomID string) ([]RoomMember, error) {
+	q := url.Values{}
+	q.Set("room_id", "eq."+roomID)
+	q.Set("order", "account_id.asc")
+	return sb.fetchMembers(q)
+}
+
+// SetMemberRole updates a member's role, or returns ErrNotFound.
+func (sb *Supabase) SetMemberRole(roomID, userID, role string) error {
+	body, _ := json.Marshal(map[string]string{"role": role})
+	endpoint := fmt.Sprintf("%s/rest/v1/room_members?room_id=eq.%s&account_id=eq.%s", sb.url, url.QueryEscape(roomID), url.QueryEscape(userID))
+	req, _ := http.NewRequest("PATCH", endpoint, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("update role request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("update role failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []RoomMember
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return fmt.Errorf("decode role update: %w", err)
+	}
+	if len(rows) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway tolerates small clock drift between Supabase and this server.
+	jwtLeeway = 30 * time.Second
+	// jwksTTL is how long a fetched key set is trusted before a refresh.
+	jwksTTL = 10 * time.Minute
+	// jwksMinRefresh rate-limits refreshes triggered by unknown key ids.
+	jwksMinRefresh = time.Minute
+)
+
+var jwksClient = &http.Client{Timeout: 5 * time.Second}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims holds the Supabase access token claims we rely on.
+type jwtClaims struct {
+	Subject      string                 `json:"sub"`
+	Email        string                 `json:"email"`
+	Audience     audienceClaim          `json:"aud"`
+	Issuer       string                 `json:"iss"`
+	ExpiresAt    int64                  `json:"exp"`
+	NotBefore    int64                  `json:"nbf"`
+	UserMetadata map[string]interface{} `json:"user_metadata"`
+}
+
+// audienceClaim accepts both the string and the array form of "aud".
+type audienceClaim []string
+
+func (a *audienceClaim) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audienceClaim{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audienceClaim) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// jwk is a single entry of a JSON Web Key Set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksCache keeps the public keys from SUPABASE_JWKS_URL or SUPABASE_JWKS_FILE in process.
+type jwksCache struct {
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	refreshing  bool
+}
+
+var jwks jwksCache
+
+// verifyTokenLocal checks the token signature and claims without calling Supabase.
+func verifyTokenLocal(token string) (*SupabaseUser, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed jwt")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decode jwt header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode jwt signature: %w", err)
+	}
+	if err := verifyJWTSignature(header, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decode jwt claims: %w", err)
+	}
+	if err := validateJWTClaims(&claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &SupabaseUser{
+		ID:           claims.Subject,
+		Email:        claims.Email,
+		UserMetadata: claims.UserMetadata,
+		ExpiresAt:    time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+func decodeJWTSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifyJWTSignature(header jwtHeader, signingInput string, sig []byte) error {
+	switch header.Alg {
+	case "HS256":
+		if jwtSecret == "" {
+			return fmt.Errorf("HS256 token but SUPABASE_JWT_SECRET is not set")
+		}
+		mac := hmac.New(sha256.New, []byte(jwtSecret))
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return fmt.Errorf("invalid jwt signature")
+		}
+		return nil
+
+	case "RS256":
+		key, err := jwks.lookup(header.Kid)
+		if err != nil {
+			return err
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an RSA key", header.Kid)
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("invalid jwt signature")
+		}
+		return nil
+
+	case "ES256":
+		key, err := jwks.lookup(header.Kid)
+		if err != nil {
+			return err
+		}
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an EC key", header.Kid)
+		}
+		if len(sig) != 64 {
+			return fmt.Errorf("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		digest := sha256.Sum256([]byte(signingInput))
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("invalid jwt signature")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported jwt alg %q", header.Alg)
+	}
+}
+
+func validateJWTClaims(c *jwtClaims, now time.Time) error {
+	if c.Subject == "" {
+		return fmt.Errorf("jwt has no sub claim")
+	}
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("jwt has no exp claim")
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(jwtLeeway)) {
+		return fmt.Errorf("jwt expired")
+	}
+	if c.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("jwt not valid yet")
+	}
+	if jwtAudience != "" && !c.Audience.contains(jwtAudience) {
+		return fmt.Errorf("jwt audience mismatch")
+	}
+	if jwtIssuer != "" && c.Issuer != jwtIssuer {
+		return fmt.Errorf("jwt issuer mismatch")
+	}
+	return nil
+}
+
+// lookup returns the key for kid. A stale but known key is served right away
+// while the set refreshes in the background, so a slow JWKS endpoint never
+// delays logins; only an unknown kid waits for a (rate-limited) refresh.
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	key, known := c.keys[kid]
+	if known {
+		if time.Since(c.fetchedAt) > jwksTTL && !c.refreshing {
+			c.refreshing = true
+			go c.refresh()
+		}
+		c.mu.Unlock()
+		return key, nil
+	}
+	canRefresh := !c.refreshing && time.Since(c.lastAttempt) > jwksMinRefresh
+	if canRefresh {
+		c.refreshing = true
+	}
+	c.mu.Unlock()
+
+	if canRefresh {
+		c.refresh()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, known = c.keys[kid]; !known {
+		return nil, fmt.Errorf("no signing key for kid %q", kid)
+	}
+	return key, nil
+}
+
+// refresh reloads the key set without holding the lock during I/O.
+// A failed refresh keeps the previously cached keys.
+func (c *jwksCache) refresh() {
+	keys, err := fetchJWKS()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	c.lastAttempt = time.Now()
+	if err != nil {
+		log.Printf("jwks refresh failed: %v", err)
+		return
+	}
+	c.keys = keys
+	c.fetchedAt = c.lastAttempt
+}
+
+// fetchJWKS reads the key set from SUPABASE_JWKS_FILE or SUPABASE_JWKS_URL.
+func fetchJWKS() (map[string]crypto.PublicKey, error) {
+	var raw []byte
+	switch {
+	case jwksFile != "":
+		b, err := os.ReadFile(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	case jwksURL != "":
+		resp, err := jwksClient.Get(jwksURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return nil, fmt.Errorf("fetch jwks failed (status %d): %s", resp.StatusCode, body)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	default:
+		return nil, fmt.Errorf("neither SUPABASE_JWKS_URL nor SUPABASE_JWKS_FILE is set")
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			log.Printf("jwks: skipping key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testJWTSecret   = "test-secret"
+	testJWTAudience = "authenticated"
+	testJWTIssuer   = "https://example.supabase.co/auth/v1"
+)
+
+// testJWKS serves a key set that tests can rotate, counting fetches.
+type testJWKS struct {
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetches int
+}
+
+func (s *testJWKS) set(kid string, key crypto.PublicKey) {
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+}
+
+func (s *testJWKS) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func (s *testJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+
+	b64 := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, key := range s.keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "RSA", Kid: kid, Alg: "RS256", N: b64(k.N), E: b64(big.NewInt(int64(k.E)))})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "EC", Kid: kid, Alg: "ES256", Crv: "P-256", X: b64(k.X), Y: b64(k.Y)})
+		}
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+// useTestJWTConfig points local verification at a test JWKS server and a
+// fresh key cache, restoring the package settings afterwards.
+func useTestJWTConfig(t *testing.T) *testJWKS {
+	t.Helper()
+	loadEnv()
+
+	keys := &testJWKS{keys: make(map[string]crypto.PublicKey)}
+	srv := httptest.NewServer(keys)
+
+	oldSecret, oldURL, oldFile, oldAud, oldIss := jwtSecret, jwksURL, jwksFile, jwtAudience, jwtIssuer
+	jwtSecret, jwksURL, jwksFile, jwtAudience, jwtIssuer = testJWTSecret, srv.URL, "", testJWTAudience, testJWTIssuer
+	jwks = jwksCache{}
+	t.Cleanup(func() {
+		srv.Close()
+		jwtSecret, jwksURL, jwksFile, jwtAudience, jwtIssuer = oldSecret, oldURL, oldFile, oldAud, oldIss
+		jwks = jwksCache{}
+	})
+	return keys
+}
+
+// jwtSigner signs a JWT signing input.
+type jwtSigner func(t *testing.T, input string) []byte
+
+func hs256(secret string) jwtSigner {
+	return func(t *testing.T, input string) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(input))
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(key *rsa.PrivateKey) jwtSigner {
+	return func(t *testing.T, input string) []byte {
+		digest := sha256.Sum256([]byte(input))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("sign RS256: %v", err)
+		}
+		return sig
+	}
+}
+
+func es256(key *ecdsa.PrivateKey) jwtSigner {
+	return func(t *testing.T, input string) []byte {
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("sign ES256: %v", err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+}
+
+func unsigned(t *testing.T, input string) []byte { return nil }
+
+func makeJWT(t *testing.T, header jwtHeader, claims map[string]interface{}, sign jwtSigner) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal jwt segment: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := enc(header) + "." + enc(claims)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign(t, input))
+}
+
+// testClaims returns valid claims with overrides applied; a nil override
+// removes the claim.
+func testClaims(overrides map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub":           "user-1",
+		"email":         "user@example.com",
+		"aud":           testJWTAudience,
+		"iss":           testJWTIssuer,
+		"exp":           now.Add(time.Hour).Unix(),
+		"user_metadata": map[string]interface{}{"name": "User One"},
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestVerifyTokenLocal(t *testing.T) {
+	keys := useTestJWTConfig(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.set("rsa-1", &rsaKey.PublicKey)
+	keys.set("ec-1", &ecKey.PublicKey)
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		header  jwtHeader
+		claims  map[string]interface{}
+		sign    jwtSigner
+		wantErr string
+	}{
+		{name: "HS256 valid", header: jwtHeader{Alg: "HS256"}, sign: hs256(testJWTSecret)},
+		{name: "HS256 bad signature", header: jwtHeader{Alg: "HS256"}, sign: hs256("wrong-secret"), wantErr: "invalid jwt signature"},
+		{name: "RS256 valid", header: jwtHeader{Alg: "RS256", Kid: "rsa-1"}, sign: rs256(rsaKey)},
+		{name: "RS256 bad signature", header: jwtHeader{Alg: "RS256", Kid: "rsa-1"}, sign: rs256(otherRSA), wantErr: "invalid jwt signature"},
+		{name: "ES256 valid", header: jwtHeader{Alg: "ES256", Kid: "ec-1"}, sign: es256(ecKey)},
+		{name: "ES256 bad signature", header: jwtHeader{Alg: "ES256", Kid: "ec-1"}, sign: es256(otherEC), wantErr: "invalid jwt signature"},
+		{name: "alg none", header: jwtHeader{Alg: "none"}, sign: unsigned, wantErr: "unsupported jwt alg"},
+		{name: "alg missing", header: jwtHeader{}, sign: hs256(testJWTSecret), wantErr: "unsupported jwt alg"},
+		{name: "RS256 header on EC key", header: jwtHeader{Alg: "RS256", Kid: "ec-1"}, sign: es256(ecKey), wantErr: "not an RSA key"},
+		{name: "ES256 header on RSA key", header: jwtHeader{Alg: "ES256", Kid: "rsa-1"}, sign: rs256(rsaKey), wantErr: "not an EC key"},
+		{name: "HS256 header on RS256 signature", header: jwtHeader{Alg: "HS256"}, sign: rs256(rsaKey), wantErr: "invalid jwt signature"},
+		{name: "expired", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}, sign: hs256(testJWTSecret), wantErr: "jwt expired"},
+		{name: "expired within leeway", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"exp": now.Add(-jwtLeeway / 2).Unix()}, sign: hs256(testJWTSecret)},
+		{name: "no exp", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"exp": nil}, sign: hs256(testJWTSecret), wantErr: "no exp claim"},
+		{name: "not valid yet", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}, sign: hs256(testJWTSecret), wantErr: "not valid yet"},
+		{name: "nbf within leeway", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"nbf": now.Add(jwtLeeway / 2).Unix()}, sign: hs256(testJWTSecret)},
+		{name: "audience mismatch", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"aud": "anon"}, sign: hs256(testJWTSecret), wantErr: "audience mismatch"},
+		{name: "audience array", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"aud": []string{"other", testJWTAudience}}, sign: hs256(testJWTSecret)},
+		{name: "issuer mismatch", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"iss": "https://evil.example/auth/v1"}, sign: hs256(testJWTSecret), wantErr: "issuer mismatch"},
+		{name: "no sub", header: jwtHeader{Alg: "HS256"}, claims: map[string]interface{}{"sub": nil}, sign: hs256(testJWTSecret), wantErr: "no sub claim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := makeJWT(t, tt.header, testClaims(tt.claims), tt.sign)
+			user, err := verifyTokenLocal(token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifyTokenLocal() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyTokenLocal() error = %v", err)
+			}
+			if user.ID != "user-1" || user.Email != "user@example.com" {
+				t.Errorf("verifyTokenLocal() user = %+v", user)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenLocalMalformed(t *testing.T) {
+	useTestJWTConfig(t)
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!.e30.", "e30.!!." + base64.RawURLEncoding.EncodeToString([]byte("x"))} {
+		if _, err := verifyTokenLocal(token); err == nil {
+			t.Errorf("verifyTokenLocal(%q) succeeded", token)
+		}
+	}
+}
+
+func TestVerifyTokenLocalUnknownKidRefreshesJWKS(t *testing.T) {
+	keys := useTestJWTConfig(t)
+
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.set("rsa-1", &oldKey.PublicKey)
+
+	if _, err := verifyTokenLocal(makeJWT(t, jwtHeader{Alg: "RS256", Kid: "rsa-1"}, testClaims(nil), rs256(oldKey))); err != nil {
+		t.Fatalf("first token: %v", err)
+	}
+	if got := keys.fetchCount(); got != 1 {
+		t.Fatalf("fetches after first token = %d, want 1", got)
+	}
+
+	// Supabase rotates in a new key; a token signed with it names a kid the
+	// cache hasn't seen.
+	keys.set("ec-2", &newKey.PublicKey)
+	rotated := makeJWT(t, jwtHeader{Alg: "ES256", Kid: "ec-2"}, testClaims(nil), es256(newKey))
+
+	// Right after a fetch, unknown kids don't trigger another one.
+	if _, err := verifyTokenLocal(rotated); err == nil || !strings.Contains(err.Error(), "no signing key") {
+		t.Fatalf("rate-limited lookup error = %v, want no signing key", err)
+	}
+	if got := keys.fetchCount(); got != 1 {
+		t.Fatalf("fetches while rate-limited = %d, want 1", got)
+	}
+
+	jwks.mu.Lock()
+	jwks.lastAttempt = time.Now().Add(-2 * jwksMinRefresh)
+	jwks.mu.Unlock()
+
+	if _, err := verifyTokenLocal(rotated); err != nil {
+		t.Fatalf("token with rotated kid: %v", err)
+	}
+	if got := keys.fetchCount(); got != 2 {
+		t.Fatalf("fetches after unknown kid = %d, want 2", got)
+	}
+
+	// The refreshed set still holds the old key, without another fetch.
+	if _, err := verifyTokenLocal(makeJWT(t, jwtHeader{Alg: "RS256", Kid: "rsa-1"}, testClaims(nil), rs256(oldKey))); err != nil {
+		t.Fatalf("old kid after refresh: %v", err)
+	}
+	if got := keys.fetchCount(); got != 2 {
+		t.Fatalf("fetches after known kid = %d, want 2", got)
+	}
+}
@@ -0,0 +1,1378 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Memory is a self-contained backend for offline development.
+// It mirrors the Supabase tables (rooms, room_members, messages, songs, tracks,
+// notes, song_snapshots, community_posts) in process. When a snapshot path is set, state is
+// loaded on start and rewritten to disk after every mutation.
+type Memory struct {
+	mu        sync.Mutex
+	path      string
+	rooms     map[string]Room
+	members   map[string]map[string]string // room_id -> account_id -> role
+	bans      map[string]map[string]string // room_id -> account_id -> banned_by
+	invites   map[string]Invite            // token -> invite
+	messages  []Message
+	nextMsgID int64
+	songs     map[string]Song
+	tracks    map[string]Track
+	notes     map[string]Note
+	snapshots map[string]Snapshot
+	posts     map[string]CommunityPost
+}
+
+var (
+	_ RoomStore     = (*Memory)(nil)
+	_ InviteStore   = (*Memory)(nil)
+	_ MessageStore  = (*Memory)(nil)
+	_ SongStore     = (*Memory)(nil)
+	_ TrackStore    = (*Memory)(nil)
+	_ NoteStore     = (*Memory)(nil)
+	_ SnapshotStore = (*Memory)(nil)
+	_ PostStore     = (*Memory)(nil)
+)
+
+// memorySnapshot is the on-disk JSON layout of a Memory backend.
+type memorySnapshot struct {
+	Rooms     []Room                       `json:"rooms"`
+	Members   map[string]map[string]string `json:"room_members"`
+	Bans      map[string]map[string]string `json:"room_bans,omitempty"`
+	Invites   []Invite                     `json:"room_invites,omitempty"`
+	Messages  []Message                    `json:"messages"`
+	NextMsgID int64                        `json:"next_message_id"`
+	Songs     []Song                       `json:"songs"`
+	Tracks    []Track                      `json:"tracks"`
+	Notes     []Note                       `json:"notes"`
+	Snapshots []Snapshot                   `json:"song_snapshots,omitempty"`
+	Posts     []CommunityPost              `json:"community_posts"`
+}
+
+// NewMemory returns an empty in-memory backend. If path is non-empty and the
+// file exists, its snapshot is loaded; later mutations are written back to it.
+func NewMemory(path string) (*Memory, error) {
+	m := &Memory{
+		path:      path,
+		rooms:     make(map[string]Room),
+		members:   make(map[string]map[string]string),
+		bans:      make(map[string]map[string]string),
+		invites:   make(map[string]Invite),
+		nextMsgID: 1,
+		songs:     make(map[string]Song),
+		tracks:    make(map[string]Track),
+		notes:     make(map[string]Note),
+		snapshots: make(map[string]Snapshot),
+		posts:     make(map[string]CommunityPost),
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap memorySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	for _, r := range snap.Rooms {
+		m.rooms[r.ID] = r
+	}
+	for roomID, accounts := range snap.Members {
+		m.members[roomID] = accounts
+	}
+	for roomID, accounts := range snap.Bans {
+		m.bans[roomID] = accounts
+	}
+	for _, inv := range snap.Invites {
+		m.invites[inv.Token] = inv
+	}
+	m.messages = snap.Messages
+	if snap.NextMsgID > 0 {
+		m.nextMsgID = snap.NextMsgID
+	}
+	for _, s := range snap.Songs {
+		m.songs[s.ID] = s
+	}
+	for _, t := range snap.Tracks {
+		m.tracks[t.ID] = t
+	}
+	for _, n := range snap.Notes {
+		if n.Version == 0 { // snapshots from before note versions
+			n.Version = 1
+		}
+		m.notes[n.ID] = n
+	}
+	for _, s := range snap.Snapshots {
+		m.snapshots[s.ID] = s
+	}
+	for _, p := range snap.Posts {
+		m.posts[p.ID] = p
+	}
+
+	return m, nil
+}
+
+// NewMemoryStore wires a single Memory backend into every repository.
+func NewMemoryStore(path string) (*Store, error) {
+	m, err := NewMemory(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		Rooms:     m,
+		Invites:   m,
+		Messages:  m,
+		Songs:     m,
+		Tracks:    m,
+		Notes:     m,
+		Snapshots: m,
+		Posts:     m,
+	}, nil
+}
+
+// persist writes the snapshot file. Callers must hold m.mu.
+func (m *Memory) persist() {
+	if m.path == "" {
+		return
+	}
+
+	snap := memorySnapshot{
+		Members:   m.members,
+		Bans:      m.bans,
+		Messages:  m.messages,
+		NextMsgID: m.nextMsgID,
+	}
+	for _, r := range m.rooms {
+		snap.Rooms = append(snap.Rooms, r)
+	}
+	for _, inv := range m.invites {
+		snap.Invites = append(snap.Invites, inv)
+	}
+	for _, s := range m.songs {
+		snap.Songs = append(snap.Songs, s)
+	}
+	for _, t := range m.tracks {
+		snap.Tracks = append(snap.Tracks, t)
+	}
+	for _, n := range m.notes {
+		snap.Notes = append(snap.Notes, n)
+	}
+	for _, s := range m.snapshots {
+		snap.Snapshots = append(snap.Snapshots, s)
+	}
+	for _, p := range m.posts {
+		snap.Posts = append(snap.Posts, p)
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		log.Printf("memory store: encode snapshot: %v", err)
+		return
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated snapshot.
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		log.Printf("memory store: create snapshot: %v", err)
+		return
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		log.Printf("memory store: write snapshot: %v", err)
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("memory store: replace snapshot: %v", err)
+	}
+}
+
+// newRoomCode returns a random code that no existing room uses. Callers must hold m.mu.
+func (m *Memory) newRoomCode() (string, error) {
+	for attempt := 0; attempt < 16; attempt++ {
+		code, err := randomRoomCode()
+		if err != nil {
+			return "", err
+		}
+		taken := false
+		for _, r := range m.rooms {
+			if r.Code == code {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("could not allocate a unique room code")
+}
+
+// CreateRoom mirrors create_room_with_owner: it inserts the room with a fresh
+// code and adds the owner to room_members.
+func (m *Memory) CreateRoom(ownerID, title string, isPrivate bool) (*Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code, err := m.newRoomCode()
+	if err != nil {
+		return nil, err
+	}
+
+	room := Room{
+		ID:        uuid.NewString(),
+		Code:      code,
+		OwnerID:   ownerID,
+		Title:     title,
+		IsPrivate: isPrivate,
+		CreatedAt: time.Now().UTC(),
+	}
+	m.rooms[room.ID] = room
+	m.members[room.ID] = map[string]string{ownerID: "owner"}
+	m.persist()
+
+	return &room, nil
+}
+
+// ListRoomsByUser returns rooms the user is a member of, oldest first.
+func (m *Memory) ListRoomsByUser(userID string) ([]Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rooms := make([]Room, 0)
+	for roomID, accounts := range m.members {
+		if _, ok := accounts[userID]; !ok {
+			continue
+		}
+		if r, ok := m.rooms[roomID]; ok {
+			rooms = append(rooms, r)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].CreatedAt.Before(rooms[j].CreatedAt) })
+
+	return rooms, nil
+}
+
+// FindPublicRooms follows the Supabase implementation: title search returns up
+// to 20 matches, otherwise five random picks out of the 30 newest public rooms.
+func (m *Memory) FindPublicRooms(name string, userID string) ([]Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limit := 30
+	if name != "" {
+		limit = 20
+	}
+	needle := strings.ToLower(name)
+
+	candidates := make([]Room, 0)
+	for _, r := range m.rooms {
+		if r.IsPrivate {
+			continue
+		}
+		if needle != "" && !strings.Contains(strings.ToLower(r.Title), needle) {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].CreatedAt.After(candidates[j].CreatedAt) })
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	rooms := make([]Room, 0, len(candidates))
+	for _, r := range candidates {
+		if _, joined := m.members[r.ID][userID]; joined {
+			continue
+		}
+		rooms = append(rooms, r)
+	}
+
+	if name == "" && len(rooms) > 5 {
+		mrand.Shuffle(len(rooms), func(i, j int) {
+			rooms[i], rooms[j] = rooms[j], rooms[i]
+		})
+		rooms = rooms[:5]
+	}
+
+	return rooms, nil
+}
+
+// GetRoom returns a room by id, or ErrNotFound.
+func (m *Memory) GetRoom(roomID string) (*Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &room, nil
+}
+
+// UpdateRoom applies the provided settings and returns the updated room.
+func (m *Memory) UpdateRoom(roomID string, title *string, isPrivate *bool, description *string) (*Room, error) {
+	if title == nil && isPrivate == nil && description == nil {
+		return nil, fmt.Errorf("no fields to update")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if title != nil {
+		room.Title = *title
+	}
+	if isPrivate != nil {
+		room.IsPrivate = *isPrivate
+	}
+	if description != nil {
+		room.Description = *description
+	}
+	m.rooms[roomID] = room
+	m.persist()
+
+	return &room, nil
+}
+
+// DeleteRoom removes a room with its songs, tracks, notes, song snapshots,
+// messages, invites, bans and memberships.
+func (m *Memory) DeleteRoom(roomID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[roomID]; !ok {
+		return nil
+	}
+	for songID, song := range m.songs {
+		if song.RoomID != roomID {
+			continue
+		}
+		for id, n := range m.notes {
+			if n.SongID == songID {
+				delete(m.notes, id)
+			}
+		}
+		for id, t := range m.tracks {
+			if t.SongID == songID {
+				delete(m.tracks, id)
+			}
+		}
+		for id, s := range m.snapshots {
+			if s.SongID == songID {
+				delete(m.snapshots, id)
+			}
+		}
+		delete(m.songs, songID)
+	}
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if msg.RoomID != roomID {
+			kept = append(kept, msg)
+		}
+	}
+	m.messages = kept
+	for token, inv := range m.invites {
+		if inv.RoomID == roomID {
+			delete(m.invites, token)
+		}
+	}
+	delete(m.bans, roomID)
+	delete(m.members, roomID)
+	delete(m.rooms, roomID)
+	m.persist()
+
+	return nil
+}
+
+// JoinRoomByCode adds a "member" row for the user; joining twice is a no-op.
+// Private rooms only readmit existing members.
+func (m *Memory) JoinRoomByCode(code, userID string) (*Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.rooms {
+		if r.Code != code {
+			continue
+		}
+		if _, banned := m.bans[r.ID][userID]; banned {
+			return nil, ErrBanned
+		}
+		if _, member := m.members[r.ID][userID]; r.IsPrivate && !member {
+			return nil, ErrInviteRequired
+		}
+		if m.members[r.ID] == nil {
+			m.members[r.ID] = make(map[string]string)
+		}
+		if _, exists := m.members[r.ID][userID]; !exists {
+			m.members[r.ID][userID] = roleLegacyMember
+			m.persist()
+		}
+		room := r
+		return &room, nil
+	}
+
+	return nil, fmt.Errorf("room not found: %w", ErrNotFound)
+}
+
+// RegenerateRoomCode gives the room a fresh unique code.
+func (m *Memory) RegenerateRoomCode(roomID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	code, err := m.newRoomCode()
+	if err != nil {
+		return "", err
+	}
+	room.Code = code
+	m.rooms[roomID] = room
+	m.persist()
+	return code, nil
+}
+
+// CreateInvite stores a new invite for the room.
+func (m *Memory) CreateInvite(roomID, createdBy, role string, maxUses int, expiresAt time.Time) (*Invite, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[roomID]; !ok {
+		return nil, fmt.Errorf("room not found: %w", ErrNotFound)
+	}
+	inv := Invite{
+		Token:     token,
+		RoomID:    roomID,
+		CreatedBy: createdBy,
+		Role:      role,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt.UTC(),
+		CreatedAt: time.Now().UTC(),
+	}
+	m.invites[token] = inv
+	m.persist()
+	return &inv, nil
+}
+
+// ListInvites returns the room's invites, newest first.
+func (m *Memory) ListInvites(roomID string) ([]Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invites := make([]Invite, 0)
+	for _, inv := range m.invites {
+		if inv.RoomID == roomID {
+			invites = append(invites, inv)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool { return invites[i].CreatedAt.After(invites[j].CreatedAt) })
+	return invites, nil
+}
+
+// RevokeInvite deletes an invite, or returns ErrNotFound.
+func (m *Memory) RevokeInvite(roomID, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inv, ok := m.invites[token]
+	if !ok || inv.RoomID != roomID {
+		return ErrNotFound
+	}
+	delete(m.invites, token)
+	m.persist()
+	return nil
+}
+
+// RedeemInvite joins userID to the invite's room, spending one use unless the
+// user is already a member.
+func (m *Memory) RedeemInvite(token, userID string) (*Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inv, ok := m.invites[token]
+	if !ok {
+		return nil, ErrInviteNotFound
+	}
+	room, ok := m.rooms[inv.RoomID]
+	if !ok {
+		return nil, ErrInviteNotFound
+	}
+	if _, banned := m.bans[room.ID][userID]; banned {
+		return nil, ErrBanned
+	}
+	if _, member := m.members[room.ID][userID]; member {
+		return &room, nil
+	}
+	if err := inv.usable(time.Now()); err != nil {
+		return nil, err
+	}
+
+	inv.Uses++
+	m.invites[token] = inv
+	if m.members[room.ID] == nil {
+		m.members[room.ID] = make(map[string]string)
+	}
+	m.members[room.ID][userID] = inv.joinRole()
+	m.persist()
+	return &room, nil
+}
+
+// LeaveRoom removes a user's membership from a room.
+func (m *Memory) LeaveRoom(roomID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if accounts, ok := m.members[roomID]; ok {
+		delete(accounts, userID)
+		m.persist()
+	}
+	return nil
+}
+
+// memberLocked builds a RoomMember with a normalized role. Callers must hold m.mu.
+func (m *Memory) memberLocked(roomID, userID, role string) RoomMember {
+	role = normalizeRole(role)
+	if m.rooms[roomID].OwnerID == userID {
+		role = RoleOwner
+	} else if role == RoleOwner {
+		role = RoleEditor
+	}
+	return RoomMember{RoomID: roomID, AccountID: userID, Role: role}
+}
+
+// GetMembership returns the user's membership row, or ErrNotFound.
+func (m *Memory) GetMembership(roomID, userID string) (*RoomMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role, ok := m.members[roomID][userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	member := m.memberLocked(roomID, userID, role)
+	return &member, nil
+}
+
+// ListMembers returns every member of a room ordered by account id.
+func (m *Memory) ListMembers(roomID string) ([]RoomMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make([]RoomMember, 0, len(m.members[roomID]))
+	for userID, role := range m.members[roomID] {
+		members = append(members, m.memberLocked(roomID, userID, role))
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].AccountID < members[j].AccountID })
+
+	return members, nil
+}
+
+// SetMemberRole updates a member's role, or returns ErrNotFound.
+func (m *Memory) SetMemberRole(roomID, userID, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.members[roomID][userID]; !ok {
+		return ErrNotFound
+	}
+	m.members[roomID][userID] = role
+	m.persist()
+	return nil
+}
+
+// BanMember records a ban and removes any existing membership.
+func (m *Memory) BanMember(roomID, userID, bannedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.bans[roomID] == nil {
+		m.bans[roomID] = make(map[string]string)
+	}
+	m.bans[roomID][userID] = bannedBy
+	delete(m.members[roomID], userID)
+	m.persist()
+	return nil
+}
+
+// UnbanMember lifts a ban; unbanning someone who is not banned is a no-op.
+func (m *Memory) UnbanMember(roomID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.bans[roomID][userID]; ok {
+		delete(m.bans[roomID], userID)
+		m.persist()
+	}
+	return nil
+}
+
+// IsBanned reports whether the user is banned from the room.
+func (m *Memory) IsBanned(roomID, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, banned := m.bans[roomID][userID]
+	return banned, nil
+}
+
+// TransferOwnership moves owner_id to toID and demotes fromID to editor.
+func (m *Memory) TransferOwnership(roomID, fromID, toID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[roomID]
+	if !ok || room.OwnerID != fromID {
+		return ErrNotFound
+	}
+	if _, ok := m.members[roomID][toID]; !ok {
+		return ErrNotFound
+	}
+	room.OwnerID = toID
+	m.rooms[roomID] = room
+	m.members[roomID][toID] = RoleOwner
+	if _, ok := m.members[roomID][fromID]; ok {
+		m.members[roomID][fromID] = RoleEditor
+	}
+	m.persist()
+	return nil
+}
+
+// CreateMessage appends a text message with the next sequential id.
+func (m *Memory) CreateMessage(roomID, senderID, senderName, body string) (*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := Message{
+		ID:         m.nextMsgID,
+		RoomID:     roomID,
+		SenderID:   senderID,
+		SenderName: senderName,
+		Body:       body,
+		Type:       "text",
+		SentAt:     time.Now().UTC(),
+	}
+	m.nextMsgID++
+	m.messages = append(m.messages, msg)
+	m.persist()
+
+	return &msg, nil
+}
+
+// ListMessages returns messages for a room ordered newest-first, with optional before-id pagination.
+func (m *Memory) ListMessages(roomID, beforeID string, limit int, includeSystem bool) ([]Message, bool, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	var before int64
+	if beforeID != "" {
+		id, err := strconv.ParseInt(beforeID, 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid before_id: %w", err)
+		}
+		before = id
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msgs := make([]Message, 0)
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if msg.RoomID != roomID {
+			continue
+		}
+		if before > 0 && msg.ID >= before {
+			continue
+		}
+		if !includeSystem && msg.Type != "text" {
+			continue
+		}
+		msgs = append(msgs, msg)
+		if len(msgs) > limit {
+			break
+		}
+	}
+
+	hasMore := false
+	if len(msgs) > limit {
+		hasMore = true
+		msgs = msgs[:limit]
+	}
+
+	return msgs, hasMore, nil
+}
+
+// GetSong returns a song by id, or ErrNotFound.
+func (m *Memory) GetSong(songID string) (*Song, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	song, ok := m.songs[songID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &song, nil
+}
+
+// ListSongsByRoom returns songs for a room, oldest first.
+func (m *Memory) ListSongsByRoom(roomID string) ([]Song, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	songs := make([]Song, 0)
+	for _, s := range m.songs {
+		if s.RoomID == roomID {
+			songs = append(songs, s)
+		}
+	}
+	sort.Slice(songs, func(i, j int) bool { return songs[i].CreatedAt.Before(songs[j].CreatedAt) })
+
+	return songs, nil
+}
+
+// CreateSong inserts a song using the same column defaults as the songs table.
+func (m *Memory) CreateSong(roomID, title string, bpm, steps int, userID string) (*Song, error) {
+	if bpm <= 0 {
+		bpm = 120
+	}
+	if steps <= 0 {
+		steps = 64
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[roomID]; !ok {
+		return nil, fmt.Errorf("room not found: %w", ErrNotFound)
+	}
+
+	song := Song{
+		ID:              uuid.NewString(),
+		RoomID:          roomID,
+		Title:           title,
+		BPM:             bpm,
+		Steps:           steps,
+		BeatsPerMeasure: 4,
+		Scale:           "major",
+		StartPitch:      24,
+		OctaveRange:     2,
+		CreatedBy:       userID,
+		CreatedAt:       time.Now().UTC(),
+	}
+	m.songs[song.ID] = song
+	m.persist()
+
+	return &song, nil
+}
+
+// UpdateSong applies the provided settings and returns the updated song.
+func (m *Memory) UpdateSong(songID string, title *string, bpm *int, steps *int, beatsPerMeasure *int, scale *string, startPitch *int, octaveRange *int) (*Song, error) {
+	if songID == "" {
+		return nil, fmt.Errorf("song_id is required")
+	}
+
+	payload, err := songUpdatePayload(title, bpm, steps, beatsPerMeasure, scale, startPitch, octaveRange)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	song, ok := m.songs[songID]
+	if !ok {
+		return nil, fmt.Errorf("update song returned no rows")
+	}
+	if title != nil {
+		song.Title = *title
+	}
+	if bpm != nil {
+		song.BPM = *bpm
+	}
+	if steps != nil {
+		song.Steps = *steps
+	}
+	if beatsPerMeasure != nil {
+		song.BeatsPerMeasure = *beatsPerMeasure
+	}
+	if v, ok := payload["scale"].(string); ok {
+		song.Scale = v
+	}
+	if startPitch != nil {
+		song.StartPitch = *startPitch
+	}
+	if octaveRange != nil {
+		song.OctaveRange = *octaveRange
+	}
+	m.songs[songID] = song
+	m.persist()
+
+	return &song, nil
+}
+
+// GetTrack returns a track by id, or ErrNotFound.
+func (m *Memory) GetTrack(trackID string) (*Track, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	track, ok := m.tracks[trackID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &track, nil
+}
+
+// CreateTrack inserts a new track and returns it.
+func (m *Memory) CreateTrack(songID, name, instrument string, channel *int, color string) (*Track, error) {
+	if songID == "" || name == "" {
+		return nil, fmt.Errorf("song_id and name are required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.songs[songID]; !ok {
+		return nil, fmt.Errorf("song not found")
+	}
+
+	track := Track{
+		ID:         uuid.NewString(),
+		SongID:     songID,
+		Name:       name,
+		Instrument: instrument,
+		Color:      color,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if channel != nil {
+		ch := *channel
+		track.Channel = &ch
+	}
+	m.tracks[track.ID] = track
+	m.persist()
+
+	return &track, nil
+}
+
+// DeleteTrack removes a track (and, like the notes foreign key, its notes).
+func (m *Memory) DeleteTrack(trackID, songID string) error {
+	if trackID == "" {
+		return fmt.Errorf("track_id is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tracks[trackID]
+	if !ok || (songID != "" && t.SongID != songID) {
+		return nil
+	}
+	delete(m.tracks, trackID)
+	for id, n := range m.notes {
+		if n.TrackID == trackID {
+			delete(m.notes, id)
+		}
+	}
+	m.persist()
+
+	return nil
+}
+
+// DeleteTrackIfNotes removes a track and its notes if the notes are still
+// exactly versions.
+func (m *Memory) DeleteTrackIfNotes(trackID, songID string, versions map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tracks[trackID]
+	if !ok || t.SongID != songID {
+		return ErrNotFound
+	}
+	count := 0
+	for _, n := range m.notes {
+		if n.TrackID != trackID {
+			continue
+		}
+		if v, ok := versions[n.ID]; !ok || v != n.Version {
+			return ErrStaleVersion
+		}
+		count++
+	}
+	if count != len(versions) {
+		return ErrStaleVersion
+	}
+
+	delete(m.tracks, trackID)
+	for id, n := range m.notes {
+		if n.TrackID == trackID {
+			delete(m.notes, id)
+		}
+	}
+	m.persist()
+
+	return nil
+}
+
+// RestoreTrack puts a deleted track back under its old id.
+func (m *Memory) RestoreTrack(t Track) (*Track, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.songs[t.SongID]; !ok {
+		return nil, fmt.Errorf("song not found")
+	}
+	if _, ok := m.tracks[t.ID]; ok {
+		return nil, ErrConflict
+	}
+	m.tracks[t.ID] = t
+	m.persist()
+
+	return &t, nil
+}
+
+// ListTracksBySong returns all tracks for a song ordered by created_at.
+func (m *Memory) ListTracksBySong(songID string) ([]Track, error) {
+	if songID == "" {
+		return nil, fmt.Errorf("song_id is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tracks := make([]Track, 0)
+	for _, t := range m.tracks {
+		if t.SongID == songID {
+			tracks = append(tracks, t)
+		}
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].CreatedAt.Before(tracks[j].CreatedAt) })
+
+	return tracks, nil
+}
+
+// CreateNote inserts a note; (song, track, step, pitch) must be unique.
+func (m *Memory) CreateNote(songID, trackID string, step, pitch, velocity, lengthSteps int, userID string) (*Note, error) {
+	if velocity <= 0 {
+		velocity = 100
+	}
+	if lengthSteps <= 0 {
+		lengthSteps = 1
+	}
+	if step < 0 {
+		return nil, fmt.Errorf("step must be non-negative")
+	}
+	if pitch <= 0 {
+		return nil, fmt.Errorf("pitch must be positive")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tracks[trackID]; !ok || t.SongID != songID {
+		return nil, fmt.Errorf("track not found")
+	}
+	for _, n := range m.notes {
+		if n.SongID == songID && n.TrackID == trackID && n.Step == step && n.Pitch == pitch {
+			return nil, fmt.Errorf("%w: note already exists at step %d pitch %d", ErrConflict, step, pitch)
+		}
+	}
+
+	note := Note{
+		ID:          uuid.NewString(),
+		SongID:      songID,
+		TrackID:     trackID,
+		Step:        step,
+		Pitch:       pitch,
+		Velocity:    velocity,
+		LengthSteps: lengthSteps,
+		Version:     1,
+		CreatedBy:   userID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	m.notes[note.ID] = note
+	m.persist()
+
+	return &note, nil
+}
+
+// DeleteNote removes a note by unique coordinates and returns it.
+func (m *Memory) DeleteNote(songID, trackID string, step, pitch, expectedVersion int) (*Note, error) {
+	if step < 0 {
+		return nil, fmt.Errorf("step must be non-negative")
+	}
+	if pitch <= 0 {
+		return nil, fmt.Errorf("pitch must be positive")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, n := range m.notes {
+		if n.SongID == songID && n.TrackID == trackID && n.Step == step && n.Pitch == pitch {
+			if expectedVersion > 0 && n.Version != expectedVersion {
+				return nil, ErrStaleVersion
+			}
+			delete(m.notes, id)
+			m.persist()
+			return &n, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// GetNote returns a note by id, or ErrNotFound.
+func (m *Memory) GetNote(noteID string) (*Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.notes[noteID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &n, nil
+}
+
+// UpdateNote patches a note by id; like CreateNote it keeps (song, track,
+// step, pitch) unique.
+func (m *Memory) UpdateNote(noteID string, patch NotePatch, expectedVersion int) (*Note, error) {
+	if patch.Empty() {
+		return nil, fmt.Errorf("no fields to update")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.notes[noteID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if expectedVersion > 0 && n.Version != expectedVersion {
+		return nil, ErrStaleVersion
+	}
+	updated := patch.Apply(n)
+	updated.Version++
+	if t, ok := m.tracks[updated.TrackID]; !ok || t.SongID != updated.SongID {
+		return nil, fmt.Errorf("track not found")
+	}
+	for id, other := range m.notes {
+		if id != noteID && other.SongID == updated.SongID && other.TrackID == updated.TrackID && other.Step == updated.Step && other.Pitch == updated.Pitch {
+			return nil, ErrConflict
+		}
+	}
+
+	m.notes[noteID] = updated
+	m.persist()
+	return &updated, nil
+}
+
+// ApplyNoteBatch applies ops to a copy of the song's notes and swaps it in
+// only when every op succeeded.
+func (m *Memory) ApplyNoteBatch(songID, userID string, ops []NoteOp) ([]NoteChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notes := make(map[string]Note)
+	for id, n := range m.notes {
+		if n.SongID == songID {
+			notes[id] = n
+		}
+	}
+	changes, err := applyNoteOps(notes, songID, userID, ops, func(n Note) error {
+		if t, ok := m.tracks[n.TrackID]; !ok || t.SongID != songID {
+			return fmt.Errorf("track not found")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id, n := range m.notes {
+		if n.SongID == songID {
+			delete(m.notes, id)
+		}
+	}
+	for id, n := range notes {
+		m.notes[id] = n
+	}
+	m.persist()
+	return changes, nil
+}
+
+// ListNotesBySong returns all notes for a song (optionally filtered by track) ordered by step, pitch.
+func (m *Memory) ListNotesBySong(songID, trackID string) ([]Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notes := make([]Note, 0)
+	for _, n := range m.notes {
+		if n.SongID != songID || (trackID != "" && n.TrackID != trackID) {
+			continue
+		}
+		notes = append(notes, n)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].Step != notes[j].Step {
+			return notes[i].Step < notes[j].Step
+		}
+		return notes[i].Pitch < notes[j].Pitch
+	})
+
+	return notes, nil
+}
+
+// CreateSnapshot stores a copy of s and returns it without its data.
+func (m *Memory) CreateSnapshot(s Snapshot) (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.songs[s.SongID]; !ok {
+		return nil, fmt.Errorf("song not found")
+	}
+	s.ID = uuid.NewString()
+	s.CreatedAt = time.Now().UTC()
+	m.snapshots[s.ID] = s
+	m.persist()
+
+	s.Data = nil
+	return &s, nil
+}
+
+// ListSnapshots returns a song's snapshots without their data, newest first.
+func (m *Memory) ListSnapshots(songID string) ([]Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snaps := make([]Snapshot, 0)
+	for _, s := range m.snapshots {
+		if s.SongID == songID {
+			s.Data = nil
+			snaps = append(snaps, s)
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].CreatedAt.After(snaps[j].CreatedAt) })
+
+	return snaps, nil
+}
+
+// GetSnapshot returns a snapshot with its data, or ErrNotFound.
+func (m *Memory) GetSnapshot(snapshotID string) (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.snapshots[snapshotID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+// PruneAutoSnapshots deletes a song's automatic snapshots beyond the newest keep.
+func (m *Memory) PruneAutoSnapshots(songID string, keep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var auto []Snapshot
+	for _, s := range m.snapshots {
+		if s.SongID == songID && s.Auto {
+			auto = append(auto, s)
+		}
+	}
+	if len(auto) <= keep {
+		return nil
+	}
+	sort.Slice(auto, func(i, j int) bool { return auto[i].CreatedAt.After(auto[j].CreatedAt) })
+	for _, s := range auto[keep:] {
+		delete(m.snapshots, s.ID)
+	}
+	m.persist()
+
+	return nil
+}
+
+// RestoreSnapshot puts back the snapshot's song settings, tracks and notes,
+// replacing whatever the song has now.
+func (m *Memory) RestoreSnapshot(snapshotID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap, ok := m.snapshots[snapshotID]
+	if !ok || snap.Data == nil {
+		return ErrNotFound
+	}
+	song, ok := m.songs[snap.SongID]
+	if !ok {
+		return fmt.Errorf("song not found")
+	}
+
+	saved := snap.Data.Song
+	song.Title = saved.Title
+	song.BPM = saved.BPM
+	song.Steps = saved.Steps
+	song.BeatsPerMeasure = saved.BeatsPerMeasure
+	song.Scale = saved.Scale
+	song.StartPitch = saved.StartPitch
+	song.OctaveRange = saved.OctaveRange
+	m.songs[song.ID] = song
+
+	versions := make(map[string]int)
+	for id, n := range m.notes {
+		if n.SongID == song.ID {
+			versions[id] = n.Version
+			delete(m.notes, id)
+		}
+	}
+	for id, t := range m.tracks {
+		if t.SongID == song.ID {
+			delete(m.tracks, id)
+		}
+	}
+	for _, t := range snap.Data.Tracks {
+		m.tracks[t.ID] = t
+	}
+	for _, n := range snap.Data.Notes {
+		if v := versions[n.ID]; v > n.Version {
+			n.Version = v
+		}
+		n.Version++
+		m.notes[n.ID] = n
+	}
+	m.persist()
+
+	return nil
+}
+
+// CreateCommunityPost inserts a new post authored by user.
+func (m *Memory) CreateCommunityPost(authorID, title, body string) (*CommunityPost, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	post := CommunityPost{
+		ID:        uuid.NewString(),
+		AuthorID:  authorID,
+		Title:     title,
+		Body:      body,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.posts[post.ID] = post
+	m.persist()
+
+	return &post, nil
+}
+
+// DeleteCommunityPost deletes a post by id scoped to author.
+func (m *Memory) DeleteCommunityPost(postID, authorID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.posts[postID]; ok && p.AuthorID == authorID {
+		delete(m.posts, postID)
+		m.persist()
+	}
+	return nil
+}
+
+// UpdateCommunityPost updates title/body and returns the updated row.
+func (m *Memory) UpdateCommunityPost(postID, authorID string, title *string, body *string) (*CommunityPost, error) {
+	if title == nil && body == nil {
+		return nil, fmt.Errorf("no fields to update")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.posts[postID]
+	if !ok || post.AuthorID != authorID {
+		return nil, fmt.Errorf("update post returned no rows")
+	}
+	if title != nil {
+		post.Title = *title
+	}
+	if body != nil {
+		post.Body = *body
+	}
+	post.UpdatedAt = time.Now().UTC()
+	m.posts[postID] = post
+	m.persist()
+
+	return &post, nil
+}
+
+// ListCommunityPosts returns posts in reverse chronological order. The memory
+// backend has no attachments, so includeAttachments only shapes the result type.
+func (m *Memory) ListCommunityPosts(before string, limit int, includeAttachments bool) ([]CommunityPostWithAttachments, bool, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	var beforeTime time.Time
+	if before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid before timestamp: %w", err)
+		}
+		beforeTime = t
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rows := make([]CommunityPostWithAttachments, 0)
+	for _, p := range m.posts {
+		if !beforeTime.IsZero() && !p.CreatedAt.Before(beforeTime) {
+			continue
+		}
+		rows = append(rows, CommunityPostWithAttachments{CommunityPost: p})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.After(rows[j].CreatedAt) })
+
+	hasMore := false
+	if len(rows) > limit {
+		hasMore = true
+		rows = rows[:limit]
+	}
+
+	return rows, hasMore, nil
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryPersistsAndReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	m, err := NewMemory(path)
+	if err != nil {
+		t.Fatalf("NewMemory: %v", err)
+	}
+
+	room, _ := m.CreateRoom("owner", "jam", false)
+	if _, err := m.JoinRoomByCode(room.Code, "guest"); err != nil {
+		t.Fatalf("JoinRoomByCode: %v", err)
+	}
+	song, _ := m.CreateSong(room.ID, "song", 100, 32, "owner")
+	track, _ := m.CreateTrack(song.ID, "bass", "synth", intp(2), "#f00")
+	note, _ := m.CreateNote(song.ID, track.ID, 3, 30, 90, 2, "guest")
+	m.CreateMessage(room.ID, "guest", "Guest", "hello")
+	m.CreateSnapshot(Snapshot{SongID: song.ID, Name: "v1", Data: &SnapshotData{Song: *song}})
+
+	reloaded, err := NewMemory(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	counts := []struct {
+		name      string
+		got, want int
+	}{
+		{"rooms", len(reloaded.rooms), len(m.rooms)},
+		{"room members", len(reloaded.members[room.ID]), 2},
+		{"songs", len(reloaded.songs), len(m.songs)},
+		{"tracks", len(reloaded.tracks), len(m.tracks)},
+		{"notes", len(reloaded.notes), len(m.notes)},
+		{"snapshots", len(reloaded.snapshots), len(m.snapshots)},
+	}
+	for _, c := range counts {
+		if c.got != c.want || c.got == 0 {
+			t.Errorf("reloaded %d %s, want %d", c.got, c.name, c.want)
+		}
+	}
+	if got, err := reloaded.GetNote(note.ID); err != nil || !got.CreatedAt.Equal(note.CreatedAt) || got.Version != 1 || got.CreatedBy != "guest" {
+		t.Fatalf("reloaded note = %+v, %v; want %+v", got, err, note)
+	}
+	if member, err := reloaded.GetMembership(room.ID, "guest"); err != nil || member == nil {
+		t.Fatalf("reloaded membership = %+v, %v", member, err)
+	}
+	msgs, _, _ := reloaded.ListMessages(room.ID, "", 10, true)
+	if len(msgs) != 1 || msgs[0].Body != "hello" {
+		t.Fatalf("reloaded messages = %+v", msgs)
+	}
+
+	// Message IDs carry on from where the old process stopped.
+	next, _ := reloaded.CreateMessage(room.ID, "guest", "Guest", "again")
+	if next.ID <= msgs[0].ID {
+		t.Fatalf("new message id %d reuses an old one (%d)", next.ID, msgs[0].ID)
+	}
+}
+
+func TestNewMemorySnapshotFile(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewMemory(filepath.Join(dir, "missing.json")); err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	os.WriteFile(corrupt, []byte("{not json"), 0o600)
+	if _, err := NewMemory(corrupt); err == nil {
+		t.Fatal("NewMemory loaded a corrupt snapshot")
+	}
+
+	// Snapshots written before note versions existed load as version 1.
+	old := filepath.Join(dir, "old.json")
+	os.WriteFile(old, []byte(`{"notes":[{"id":"n1","song_id":"s1","track_id":"t1","step":1,"pitch":30}]}`), 0o600)
+	m, err := NewMemory(old)
+	if err != nil {
+		t.Fatalf("old snapshot: %v", err)
+	}
+	if n, err := m.GetNote("n1"); err != nil || n.Version != 1 {
+		t.Fatalf("old note = %+v, %v; want version 1", n, err)
+	}
+}
@@ -21,9 +21,7 @@ type Message struct {
 }
 
 // CreateMessage inserts a new message into Supabase messages table.
-func CreateMessage(roomID, senderID, senderName, body string) (*Message, error) {
-	loadEnv()
-
+func (sb *Supabase) CreateMessage(roomID, senderID, senderName, body string) (*Message, error) {
 	payload := map[string]interface{}{
 		"room_id":   roomID,
 		"sender_id": senderID,
@@ -35,13 +33,13 @@ func CreateMessage(roomID, senderID, senderName, body string) (*Message, error)
 		return nil, fmt.Errorf("marshal message payload: %w", err)
 	}
 
-	req, _ := http.NewRequest("POST", supabaseURL+"/rest/v1/messages", bytes.NewReader(b))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/messages", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Prefer", "return=representation")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("send message: %w", err)
 	}
@@ -66,9 +64,7 @@ func CreateMessage(roomID, senderID, senderName, body string) (*Message, error)
 }
 
 // ListMessages returns messages for a room ordered newest-first, with optional before-id pagination.
-func ListMessages(roomID, beforeID string, limit int, includeSystem bool) ([]Message, bool, error) {
-	loadEnv()
-
+func (sb *Supabase) ListMessages(roomID, beforeID string, limit int, includeSystem bool) ([]Message, bool, error) {
 	if limit <= 0 {
 		limit = 50
 	}
@@ -88,12 +84,12 @@ func ListMessages(roomID, beforeID string, limit int, includeSystem bool) ([]Mes
 		q.Set("type", "eq.text")
 	}
 
-	endpoint := fmt.Sprintf("%s/rest/v1/messages?%s", supabaseURL, q.Encode())
+	endpoint := fmt.Sprintf("%s/rest/v1/messages?%s", sb.url, q.Encode())
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, false, fmt.Errorf("fetch messages: %w", err)
 	}
@@ -131,7 +127,7 @@ func ListMessages(roomID, beforeID string, limit int, includeSystem bool) ([]Mes
 		if cached, ok := nameCache[r.SenderID]; ok {
 			m.SenderName = cached
 		} else {
-			if name, err := fetchSenderName(r.SenderID); err == nil {
+			if name, err := sb.fetchSenderName(r.SenderID); err == nil {
 				m.SenderName = name
 				nameCache[r.SenderID] = name
 			}
@@ -149,16 +145,16 @@ func ListMessages(roomID, beforeID string, limit int, includeSystem bool) ([]Mes
 }
 
 // fetchSenderName gets user_name from auth admin endpoint using service key.
-func fetchSenderName(userID string) (string, error) {
-	if supabaseAPIKey == "" || supabaseURL == "" {
+func (sb *Supabase) fetchSenderName(userID string) (string, error) {
+	if sb.apiKey == "" || sb.url == "" {
 		return "", fmt.Errorf("supabase config missing")
 	}
-	endpoint := fmt.Sprintf("%s/auth/v1/admin/users/%s", supabaseURL, userID)
+	endpoint := fmt.Sprintf("%s/auth/v1/admin/users/%s", sb.url, userID)
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ErrBanned is returned by JoinRoomByCode when the user is banned from the room.
+var ErrBanned = errors.New("you are banned from this room")
+
+// BanMember records a ban in room_bans and removes any existing membership.
+func (sb *Supabase) BanMember(roomID, userID, bannedBy string) error {
+	payload := map[string]interface{}{
+		"room_id":    roomID,
+		"account_id": userID,
+		"banned_by":  bannedBy,
+	}
+	body, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/rest/v1/room_bans", sb.url), bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "resolution=merge-duplicates,return=minimal")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("insert ban: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 && resp.StatusCode != 204 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("insert ban failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	return sb.LeaveRoom(roomID, userID)
+}
+
+// UnbanMember deletes the user's room_bans row, if any.
+func (sb *Supabase) UnbanMember(roomID, userID string) error {
+	endpoint := fmt.Sprintf("%s/rest/v1/room_bans?room_id=eq.%s&account_id=eq.%s", sb.url, url.QueryEscape(roomID), url.QueryEscape(userID))
+	req, _ := http.NewRequest("DELETE", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Prefer", "return=minimal")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("delete ban request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 && resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete ban failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	return nil
+}
+
+// IsBanned reports whether the user has a room_bans row for the room.
+func (sb *Supabase) IsBanned(roomID, userID string) (bool, error) {
+	q := url.Values{}
+	q.Set("select", "account_id")
+	q.Set("room_id", "eq."+roomID)
+	q.Set("account_id", "eq."+userID)
+	q.Set("limit", "1")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/room_bans?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("fetch ban: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("fetch ban failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []struct {
+		AccountID string `json:"account_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return false, fmt.Errorf("decode ban: %w", err)
+	}
+	return len(rows) > 0, nil
+}
+
+// TransferOwnership runs the transfer_room_ownership database function, which
+// moves rooms.owner_id from fromID to toID and swaps their room_members roles
+// (new owner -> owner, previous owner -> editor) in one transaction, so the
+// roles never disagree with owner_id. The owner_id update is conditional on
+// fromID so concurrent transfers cannot both win; when fromID no longer owns
+// the room or toID is not a member it fails with no_data_found (404) and
+// nothing changes.
+func (sb *Supabase) TransferOwnership(roomID, fromID, toID string) error {
+	body, err := json.Marshal(map[string]string{
+		"_room_id": roomID,
+		"_from_id": fromID,
+		"_to_id":   toID,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal ownership transfer: %w", err)
+	}
+
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/rpc/transfer_room_ownership", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("transfer ownership request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("transfer ownership failed (status %d): %s", resp.StatusCode, b)
+	}
+}
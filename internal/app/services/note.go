@@ -3,30 +3,253 @@ package services
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // Note represents a grid note tied to a song/track.
 type Note struct {
-	ID          string    `json:"id"`
-	SongID      string    `json:"song_id"`
-	TrackID     string    `json:"track_id"`
-	Step        int       `json:"step"`
-	Pitch       int       `json:"pitch"`
-	Velocity    int       `json:"velocity"`
-	LengthSteps int       `json:"length_steps"`
-	CreatedBy   string    `json:"created_by"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          string `json:"id"`
+	SongID      string `json:"song_id"`
+	TrackID     string `json:"track_id"`
+	Step        int    `json:"step"`
+	Pitch       int    `json:"pitch"`
+	Velocity    int    `json:"velocity"`
+	LengthSteps int    `json:"length_steps"`
+	// Version starts at 1 and goes up by one on every update; writes can
+	// name the version they expect (compare-and-set).
+	Version   int       `json:"version"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
-// CreateNote inserts a new note row and returns it.
-func CreateNote(songID, trackID string, step, pitch, velocity, lengthSteps int, userID string) (*Note, error) {
-	loadEnv()
+// NotePatch lists the note fields to change; nil fields are left alone.
+type NotePatch struct {
+	TrackID     *string `json:"track_id,omitempty"`
+	Step        *int    `json:"step,omitempty"`
+	Pitch       *int    `json:"pitch,omitempty"`
+	Velocity    *int    `json:"velocity,omitempty"`
+	LengthSteps *int    `json:"length_steps,omitempty"`
+}
+
+// Empty reports whether the patch changes nothing.
+func (p NotePatch) Empty() bool {
+	return p.TrackID == nil && p.Step == nil && p.Pitch == nil && p.Velocity == nil && p.LengthSteps == nil
+}
+
+// Apply returns n with the patch applied.
+func (p NotePatch) Apply(n Note) Note {
+	if p.TrackID != nil {
+		n.TrackID = *p.TrackID
+	}
+	if p.Step != nil {
+		n.Step = *p.Step
+	}
+	if p.Pitch != nil {
+		n.Pitch = *p.Pitch
+	}
+	if p.Velocity != nil {
+		n.Velocity = *p.Velocity
+	}
+	if p.LengthSteps != nil {
+		n.LengthSteps = *p.LengthSteps
+	}
+	return n
+}
 
+// NoteGridError explains why a note doesn't fit its song's grid.
+type NoteGridError struct {
+	Field  string
+	Reason string
+}
+
+func (e *NoteGridError) Error() string { return e.Field + " must be " + e.Reason }
+
+// CheckNoteOnGrid validates a note against the song grid: steps 0..steps-1
+// (the note may not run past the last step), pitches start_pitch up to
+// 12*octave_range semitones above it, velocity 1..127.
+func CheckNoteOnGrid(song *Song, n Note) error {
+	switch {
+	case n.Step < 0 || n.Step >= song.Steps:
+		return &NoteGridError{"step", fmt.Sprintf("between 0 and %d", song.Steps-1)}
+	case n.LengthSteps < 1 || n.Step+n.LengthSteps > song.Steps:
+		return &NoteGridError{"length_steps", fmt.Sprintf("between 1 and %d at step %d", song.Steps-n.Step, n.Step)}
+	case n.Pitch < song.StartPitch || n.Pitch >= song.StartPitch+12*song.OctaveRange:
+		return &NoteGridError{"pitch", fmt.Sprintf("between %d and %d", song.StartPitch, song.StartPitch+12*song.OctaveRange-1)}
+	case n.Velocity < 1 || n.Velocity > 127:
+		return &NoteGridError{"velocity", "between 1 and 127"}
+	}
+	return nil
+}
+
+// Note batch operations (route 608).
+const (
+	NoteOpCreate = "create"
+	NoteOpUpdate = "update"
+	NoteOpDelete = "delete"
+)
+
+// NoteOp is one step of a note batch. Creates carry the new note's fields
+// (track_id, step and pitch required; note_id and created_by only when undo
+// restores a note, which keeps its ID and author), updates a note_id and the
+// fields to change, deletes just a note_id. Updates and deletes may name the
+// version they expect.
+type NoteOp struct {
+	Op              string `json:"op"`
+	NoteID          string `json:"note_id,omitempty"`
+	CreatedBy       string `json:"created_by,omitempty"`
+	ExpectedVersion int    `json:"expected_version,omitempty"`
+	NotePatch
+}
+
+// NoteChange is what one NoteOp did: Note is the note afterwards (nil for
+// deletes) and Before the note as it was (nil for creates).
+type NoteChange struct {
+	Op     string `json:"op"`
+	Note   *Note  `json:"note,omitempty"`
+	Before *Note  `json:"before,omitempty"`
+}
+
+var errEmptyNoteUpdate = errors.New("update has no fields to change")
+
+// NoteOpError tells which op of a batch failed. For ErrConflict and
+// ErrStaleVersion Current is the note in the way (or the note as it is now),
+// nil when there is none.
+type NoteOpError struct {
+	Index   int
+	Err     error
+	Current *Note
+}
+
+func (e *NoteOpError) Error() string { return fmt.Sprintf("ops[%d]: %v", e.Index, e.Err) }
+func (e *NoteOpError) Unwrap() error { return e.Err }
+
+// CheckNoteBatch dry-runs ops against a song's current notes and tracks and
+// returns the first op that would fail: an unknown op or missing field, a
+// track of another song or a note off the grid (*NoteGridError), a note_id
+// that isn't in the song (ErrNotFound), a taken position (ErrConflict) or a
+// missed expected_version (ErrStaleVersion), wrapped in a *NoteOpError.
+// Update and delete ops without an expected_version are pinned to the one
+// checked, so the write fails rather than acts on notes changed in between.
+func CheckNoteBatch(song *Song, tracks []Track, notes []Note, ops []NoteOp) error {
+	trackIDs := make(map[string]bool, len(tracks))
+	for _, t := range tracks {
+		trackIDs[t.ID] = true
+	}
+	byID := make(map[string]Note, len(notes))
+	for _, n := range notes {
+		byID[n.ID] = n
+	}
+	changes, err := applyNoteOps(byID, song.ID, "", ops, func(n Note) error {
+		if !trackIDs[n.TrackID] {
+			return &NoteGridError{"track_id", "a track of this song"}
+		}
+		return CheckNoteOnGrid(song, n)
+	})
+	if err != nil {
+		return err
+	}
+	for i, c := range changes {
+		if c.Before != nil && ops[i].ExpectedVersion == 0 {
+			ops[i].ExpectedVersion = c.Before.Version
+		}
+	}
+	return nil
+}
+
+// applyNoteOps applies ops in order to notes, a song's notes by id, keeping
+// (track, step, pitch) unique and versions current. validate vets every
+// created or updated note.
+// On error notes may be partly changed; callers work on a copy.
+func applyNoteOps(notes map[string]Note, songID, userID string, ops []NoteOp, validate func(Note) error) ([]NoteChange, error) {
+	changes := make([]NoteChange, 0, len(ops))
+	for i, op := range ops {
+		fail := func(err error) ([]NoteChange, error) { return nil, &NoteOpError{Index: i, Err: err} }
+		failOn := func(err error, current Note) ([]NoteChange, error) {
+			return nil, &NoteOpError{Index: i, Err: err, Current: &current}
+		}
+
+		var before, after *Note
+		switch op.Op {
+		case NoteOpCreate:
+			switch {
+			case op.TrackID == nil || *op.TrackID == "":
+				return fail(&NoteGridError{"track_id", "set"})
+			case op.Step == nil:
+				return fail(&NoteGridError{"step", "set"})
+			case op.Pitch == nil:
+				return fail(&NoteGridError{"pitch", "set"})
+			}
+			id := op.NoteID
+			if id == "" {
+				id = uuid.NewString()
+			} else if other, taken := notes[id]; taken {
+				return failOn(ErrConflict, other)
+			}
+			author := op.CreatedBy
+			if author == "" {
+				author = userID
+			}
+			n := op.Apply(Note{
+				ID:          id,
+				SongID:      songID,
+				Velocity:    100,
+				LengthSteps: 1,
+				Version:     1,
+				CreatedBy:   author,
+				CreatedAt:   time.Now().UTC(),
+			})
+			after = &n
+		case NoteOpUpdate, NoteOpDelete:
+			if op.NoteID == "" {
+				return fail(&NoteGridError{"note_id", "set"})
+			}
+			n, ok := notes[op.NoteID]
+			if !ok {
+				return fail(ErrNotFound)
+			}
+			if op.ExpectedVersion > 0 && n.Version != op.ExpectedVersion {
+				return failOn(ErrStaleVersion, n)
+			}
+			before = &n
+			if op.Op == NoteOpUpdate {
+				if op.NotePatch.Empty() {
+					return fail(errEmptyNoteUpdate)
+				}
+				updated := op.Apply(n)
+				updated.Version++
+				after = &updated
+			}
+		default:
+			return fail(&NoteGridError{"op", `"create", "update" or "delete"`})
+		}
+
+		if after == nil {
+			delete(notes, before.ID)
+		} else {
+			if err := validate(*after); err != nil {
+				return fail(err)
+			}
+			for id, other := range notes {
+				if id != after.ID && other.TrackID == after.TrackID && other.Step == after.Step && other.Pitch == after.Pitch {
+					return failOn(ErrConflict, other)
+				}
+			}
+			notes[after.ID] = *after
+		}
+		changes = append(changes, NoteChange{Op: op.Op, Note: after, Before: before})
+	}
+	return changes, nil
+}
+
+// CreateNote inserts a new note row and returns it.
+func (sb *Supabase) CreateNote(songID, trackID string, step, pitch, velocity, lengthSteps int, userID string) (*Note, error) {
 	if velocity <= 0 {
 		velocity = 100
 	}
@@ -55,18 +278,21 @@ func CreateNote(songID, trackID string, step, pitch, velocity, lengthSteps int,
 		return nil, fmt.Errorf("marshal note payload: %w", err)
 	}
 
-	req, _ := http.NewRequest("POST", supabaseURL+"/rest/v1/notes", bytes.NewReader(body))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/notes", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Prefer", "return=representation")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("create note: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrConflict
+	}
 	if resp.StatusCode != http.StatusCreated {
 		respBody, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("create note failed (status %d): %s", resp.StatusCode, respBody)
@@ -83,54 +309,192 @@ func CreateNote(songID, trackID string, step, pitch, velocity, lengthSteps int,
 	return &rows[0], nil
 }
 
-// DeleteNote removes a note by unique coordinates.
-func DeleteNote(songID, trackID string, step, pitch int) error {
-	loadEnv()
-
+// DeleteNote removes a note by unique coordinates, optionally only at
+// expectedVersion, and returns it. Nothing deleted is ErrNotFound either way;
+// callers look the note up again to tell a stale version from a missing note.
+func (sb *Supabase) DeleteNote(songID, trackID string, step, pitch, expectedVersion int) (*Note, error) {
 	if step < 0 {
-		return fmt.Errorf("step must be non-negative")
+		return nil, fmt.Errorf("step must be non-negative")
 	}
 	if pitch <= 0 {
-		return fmt.Errorf("pitch must be positive")
+		return nil, fmt.Errorf("pitch must be positive")
 	}
 
-	url := fmt.Sprintf("%s/rest/v1/notes?song_id=eq.%s&track_id=eq.%s&step=eq.%d&pitch=eq.%d", supabaseURL, songID, trackID, step, pitch)
+	url := fmt.Sprintf("%s/rest/v1/notes?song_id=eq.%s&track_id=eq.%s&step=eq.%d&pitch=eq.%d", sb.url, songID, trackID, step, pitch)
+	if expectedVersion > 0 {
+		url += fmt.Sprintf("&version=eq.%d", expectedVersion)
+	}
 	req, _ := http.NewRequest("DELETE", url, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Prefer", "return=representation")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("delete note: %w", err)
+		return nil, fmt.Errorf("delete note: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("delete note failed (status %d): %s", resp.StatusCode, respBody)
+		return nil, fmt.Errorf("delete note failed (status %d): %s", resp.StatusCode, respBody)
 	}
 
-	return nil
+	var rows []Note
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode deleted note: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &rows[0], nil
 }
 
-// ListNotesBySong fetches all notes for a song (optionally filtered by track).
-func ListNotesBySong(songID, trackID string) ([]Note, error) {
-	loadEnv()
+// GetNote fetches a single note by id, or ErrNotFound.
+func (sb *Supabase) GetNote(noteID string) (*Note, error) {
+	q := url.Values{}
+	q.Set("id", "eq."+noteID)
+	q.Set("select", "id,song_id,track_id,step,pitch,velocity,length_steps,version,created_by,created_at")
+	q.Set("limit", "1")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/notes?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch note: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch note failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var notes []Note
+	if err := json.NewDecoder(resp.Body).Decode(&notes); err != nil {
+		return nil, fmt.Errorf("decode note: %w", err)
+	}
+	if len(notes) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &notes[0], nil
+}
+
+// UpdateNote patches a note by id, optionally only at expectedVersion; the
+// notes_bump_version trigger moves the version on. The notes table's unique
+// (song, track, step, pitch) constraint surfaces as ErrConflict.
+func (sb *Supabase) UpdateNote(noteID string, patch NotePatch, expectedVersion int) (*Note, error) {
+	if patch.Empty() {
+		return nil, fmt.Errorf("no fields to update")
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, fmt.Errorf("marshal note patch: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/rest/v1/notes?id=eq.%s", sb.url, url.QueryEscape(noteID))
+	if expectedVersion > 0 {
+		endpoint += fmt.Sprintf("&version=eq.%d", expectedVersion)
+	}
+	req, _ := http.NewRequest("PATCH", endpoint, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("update note: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrConflict
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("update note failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var rows []Note
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode note response: %w", err)
+	}
+	if len(rows) == 0 {
+		if expectedVersion > 0 {
+			return nil, ErrStaleVersion
+		}
+		return nil, ErrNotFound
+	}
+
+	return &rows[0], nil
+}
+
+// ApplyNoteBatch runs ops through the apply_note_batch database function,
+// which applies them in order in one transaction. A taken position or a
+// missed expected_version surfaces as ErrConflict and an unknown note_id as
+// ErrNotFound; either way nothing is written.
+func (sb *Supabase) ApplyNoteBatch(songID, userID string, ops []NoteOp) ([]NoteChange, error) {
+	payload := map[string]interface{}{
+		"_song_id": songID,
+		"_user_id": userID,
+		"_ops":     ops,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal note batch: %w", err)
+	}
+
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/rpc/apply_note_batch", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("apply note batch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict: // unique_violation or a stale expected_version
+		return nil, ErrConflict
+	case http.StatusNotFound: // no_data_found raised for a missing note
+		return nil, ErrNotFound
+	default:
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("apply note batch failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var changes []NoteChange
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return nil, fmt.Errorf("decode note batch response: %w", err)
+	}
+	return changes, nil
+}
+
+// ListNotesBySong fetches all notes for a song (optionally filtered by track).
+func (sb *Supabase) ListNotesBySong(songID, trackID string) ([]Note, error) {
 	q := url.Values{}
 	q.Set("song_id", "eq."+songID)
-	q.Set("select", "id,song_id,track_id,step,pitch,velocity,length_steps,created_by,created_at")
+	q.Set("select", "id,song_id,track_id,step,pitch,velocity,length_steps,version,created_by,created_at")
 	q.Set("order", "step.asc,pitch.asc")
 	if trackID != "" {
 		q.Set("track_id", "eq."+trackID)
 	}
 
-	endpoint := fmt.Sprintf("%s/rest/v1/notes?%s", supabaseURL, q.Encode())
+	endpoint := fmt.Sprintf("%s/rest/v1/notes?%s", sb.url, q.Encode())
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch notes: %w", err)
 	}
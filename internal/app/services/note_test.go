@@ -0,0 +1,229 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func intp(v int) *int       { return &v }
+func strp(v string) *string { return &v }
+
+// newTestSong returns an in-memory backend holding one room, a 16-step song
+// (pitches 24..47) and a track of it.
+func newTestSong(t *testing.T) (*Memory, *Song, *Track) {
+	t.Helper()
+	m, err := NewMemory("")
+	if err != nil {
+		t.Fatalf("NewMemory: %v", err)
+	}
+	room, err := m.CreateRoom("owner", "room", false)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	song, err := m.CreateSong(room.ID, "song", 120, 16, "owner")
+	if err != nil {
+		t.Fatalf("CreateSong: %v", err)
+	}
+	track, err := m.CreateTrack(song.ID, "lead", "piano", nil, "")
+	if err != nil {
+		t.Fatalf("CreateTrack: %v", err)
+	}
+	return m, song, track
+}
+
+// createOp returns a batch op creating a note at step and pitch.
+func createOp(trackID string, step, pitch int) NoteOp {
+	return NoteOp{Op: NoteOpCreate, NotePatch: NotePatch{TrackID: strp(trackID), Step: intp(step), Pitch: intp(pitch)}}
+}
+
+func TestCheckNoteBatch(t *testing.T) {
+	song := &Song{ID: "s1", Steps: 16, StartPitch: 24, OctaveRange: 2}
+	tracks := []Track{{ID: "t1", SongID: "s1"}}
+	notes := []Note{
+		{ID: "n1", SongID: "s1", TrackID: "t1", Step: 0, Pitch: 30, Velocity: 100, LengthSteps: 1, Version: 3},
+		{ID: "n2", SongID: "s1", TrackID: "t1", Step: 4, Pitch: 30, Velocity: 100, LengthSteps: 1, Version: 1},
+	}
+
+	tests := []struct {
+		name      string
+		ops       []NoteOp
+		wantIndex int
+		wantErr   error
+		wantField string
+	}{
+		{name: "valid", ops: []NoteOp{createOp("t1", 2, 30), {Op: NoteOpUpdate, NoteID: "n1", NotePatch: NotePatch{Velocity: intp(50)}}, {Op: NoteOpDelete, NoteID: "n2"}}},
+		{name: "cell freed earlier in the batch", ops: []NoteOp{{Op: NoteOpDelete, NoteID: "n2"}, createOp("t1", 4, 30)}},
+		{name: "unknown op", ops: []NoteOp{{Op: "move", NoteID: "n1"}}, wantField: "op"},
+		{name: "create without pitch", ops: []NoteOp{{Op: NoteOpCreate, NotePatch: NotePatch{TrackID: strp("t1"), Step: intp(1)}}}, wantField: "pitch"},
+		{name: "track of another song", ops: []NoteOp{createOp("t9", 1, 30)}, wantField: "track_id"},
+		{name: "off the grid", ops: []NoteOp{createOp("t1", 1, 30), createOp("t1", 16, 30)}, wantIndex: 1, wantField: "step"},
+		{name: "runs past the last step", ops: []NoteOp{{Op: NoteOpUpdate, NoteID: "n2", NotePatch: NotePatch{Step: intp(15), LengthSteps: intp(2)}}}, wantField: "length_steps"},
+		{name: "unknown note", ops: []NoteOp{{Op: NoteOpDelete, NoteID: "nope"}}, wantErr: ErrNotFound},
+		{name: "taken cell", ops: []NoteOp{{Op: NoteOpUpdate, NoteID: "n1", NotePatch: NotePatch{Step: intp(4)}}}, wantErr: ErrConflict},
+		{name: "two creates in one cell", ops: []NoteOp{createOp("t1", 8, 30), createOp("t1", 8, 30)}, wantIndex: 1, wantErr: ErrConflict},
+		{name: "restored ID in use", ops: []NoteOp{{Op: NoteOpCreate, NoteID: "n1", NotePatch: NotePatch{TrackID: strp("t1"), Step: intp(9), Pitch: intp(30)}}}, wantErr: ErrConflict},
+		{name: "stale version", ops: []NoteOp{{Op: NoteOpDelete, NoteID: "n1", ExpectedVersion: 2}}, wantErr: ErrStaleVersion},
+		{name: "empty update", ops: []NoteOp{{Op: NoteOpUpdate, NoteID: "n1"}}, wantErr: errEmptyNoteUpdate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckNoteBatch(song, tracks, append([]Note(nil), notes...), tt.ops)
+			if tt.wantErr == nil && tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("CheckNoteBatch: %v", err)
+				}
+				return
+			}
+
+			var opErr *NoteOpError
+			if !errors.As(err, &opErr) || opErr.Index != tt.wantIndex {
+				t.Fatalf("err = %v, want a failure at ops[%d]", err, tt.wantIndex)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			var gridErr *NoteGridError
+			if tt.wantField != "" && (!errors.As(err, &gridErr) || gridErr.Field != tt.wantField) {
+				t.Fatalf("err = %v, want a grid error on %s", err, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestCheckNoteBatchPinsVersions(t *testing.T) {
+	song := &Song{ID: "s1", Steps: 16, StartPitch: 24, OctaveRange: 2}
+	notes := []Note{{ID: "n1", SongID: "s1", TrackID: "t1", Step: 0, Pitch: 30, Velocity: 100, LengthSteps: 1, Version: 3}}
+	ops := []NoteOp{
+		createOp("t1", 1, 30),
+		{Op: NoteOpUpdate, NoteID: "n1", NotePatch: NotePatch{Velocity: intp(50)}},
+		{Op: NoteOpDelete, NoteID: "n1"},
+	}
+	if err := CheckNoteBatch(song, []Track{{ID: "t1", SongID: "s1"}}, notes, ops); err != nil {
+		t.Fatalf("CheckNoteBatch: %v", err)
+	}
+	// The delete follows the update, so it expects the version the update made.
+	for i, want := range []int{0, 3, 4} {
+		if got := ops[i].ExpectedVersion; got != want {
+			t.Errorf("ops[%d].ExpectedVersion = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestApplyNoteBatchAllOrNothing(t *testing.T) {
+	m, song, track := newTestSong(t)
+	n1, err := m.CreateNote(song.ID, track.ID, 0, 30, 100, 1, "owner")
+	if err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+
+	// The second op runs into n1, so the first must not stick either.
+	_, err = m.ApplyNoteBatch(song.ID, "u1", []NoteOp{
+		createOp(track.ID, 1, 30),
+		{Op: NoteOpUpdate, NoteID: n1.ID, NotePatch: NotePatch{Velocity: intp(20)}},
+		createOp(track.ID, 0, 30),
+	})
+	var opErr *NoteOpError
+	if !errors.As(err, &opErr) || opErr.Index != 2 || !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want a conflict at ops[2]", err)
+	}
+	if opErr.Current == nil || opErr.Current.ID != n1.ID {
+		t.Fatalf("conflict Current = %+v, want the note in the way", opErr.Current)
+	}
+	notes, _ := m.ListNotesBySong(song.ID, "")
+	if len(notes) != 1 || notes[0] != *n1 {
+		t.Fatalf("notes after a failed batch = %+v, want only the untouched n1", notes)
+	}
+
+	changes, err := m.ApplyNoteBatch(song.ID, "u1", []NoteOp{
+		createOp(track.ID, 1, 30),
+		{Op: NoteOpUpdate, NoteID: n1.ID, NotePatch: NotePatch{Velocity: intp(20)}, ExpectedVersion: 1},
+	})
+	if err != nil {
+		t.Fatalf("ApplyNoteBatch: %v", err)
+	}
+	if c := changes[0]; c.Before != nil || c.Note == nil || c.Note.CreatedBy != "u1" || c.Note.Version != 1 {
+		t.Fatalf("create change = %+v, want a new version 1 note by u1", c)
+	}
+	if c := changes[1]; c.Before == nil || c.Before.Velocity != 100 || c.Note.Velocity != 20 || c.Note.Version != 2 {
+		t.Fatalf("update change = %+v, want velocity 100 -> 20 at version 2", c)
+	}
+	if notes, _ := m.ListNotesBySong(song.ID, ""); len(notes) != 2 {
+		t.Fatalf("got %d notes, want 2", len(notes))
+	}
+}
+
+func TestApplyNoteBatchStaleVersion(t *testing.T) {
+	m, song, track := newTestSong(t)
+	n1, err := m.CreateNote(song.ID, track.ID, 0, 30, 100, 1, "owner")
+	if err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	if _, err := m.UpdateNote(n1.ID, NotePatch{Velocity: intp(90)}, 1); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+
+	_, err = m.ApplyNoteBatch(song.ID, "u1", []NoteOp{{Op: NoteOpDelete, NoteID: n1.ID, ExpectedVersion: 1}})
+	var opErr *NoteOpError
+	if !errors.As(err, &opErr) || !errors.Is(err, ErrStaleVersion) {
+		t.Fatalf("err = %v, want ErrStaleVersion", err)
+	}
+	if opErr.Current == nil || opErr.Current.Version != 2 || opErr.Current.Velocity != 90 {
+		t.Fatalf("Current = %+v, want the note at version 2", opErr.Current)
+	}
+	if _, err := m.GetNote(n1.ID); err != nil {
+		t.Fatalf("note deleted despite the stale version: %v", err)
+	}
+}
+
+func TestMemoryNoteVersions(t *testing.T) {
+	m, song, track := newTestSong(t)
+	n, err := m.CreateNote(song.ID, track.ID, 2, 30, 0, 0, "u1")
+	if err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	if n.Version != 1 || n.Velocity != 100 || n.LengthSteps != 1 {
+		t.Fatalf("new note = %+v, want version 1 with default velocity and length", n)
+	}
+	if _, err := m.CreateNote(song.ID, track.ID, 2, 30, 80, 1, "u2"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second CreateNote in the cell: err = %v, want ErrConflict", err)
+	}
+
+	updated, err := m.UpdateNote(n.ID, NotePatch{Velocity: intp(70)}, 1)
+	if err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	if updated.Version != 2 || updated.Velocity != 70 {
+		t.Fatalf("updated note = %+v, want velocity 70 at version 2", updated)
+	}
+
+	// A writer still holding version 1 loses, whether it updates or deletes.
+	if _, err := m.UpdateNote(n.ID, NotePatch{Velocity: intp(10)}, 1); !errors.Is(err, ErrStaleVersion) {
+		t.Fatalf("stale UpdateNote: err = %v, want ErrStaleVersion", err)
+	}
+	if _, err := m.DeleteNote(song.ID, track.ID, 2, 30, 1); !errors.Is(err, ErrStaleVersion) {
+		t.Fatalf("stale DeleteNote: err = %v, want ErrStaleVersion", err)
+	}
+
+	// No expected version means last write wins.
+	if _, err := m.UpdateNote(n.ID, NotePatch{Pitch: intp(31)}, 0); err != nil {
+		t.Fatalf("unversioned UpdateNote: %v", err)
+	}
+	other, err := m.CreateNote(song.ID, track.ID, 2, 30, 0, 0, "u2")
+	if err != nil {
+		t.Fatalf("CreateNote in the freed cell: %v", err)
+	}
+	if _, err := m.UpdateNote(other.ID, NotePatch{Pitch: intp(31)}, 1); !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateNote onto a taken cell: err = %v, want ErrConflict", err)
+	}
+
+	deleted, err := m.DeleteNote(song.ID, track.ID, 2, 31, 3)
+	if err != nil {
+		t.Fatalf("DeleteNote: %v", err)
+	}
+	if deleted.ID != n.ID {
+		t.Fatalf("deleted %s, want %s", deleted.ID, n.ID)
+	}
+	if _, err := m.DeleteNote(song.ID, track.ID, 2, 31, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteNote of an empty cell: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+const (
+	// defaultOutboxSize is the per-session queue length (OUTBOUND_QUEUE_SIZE).
+	defaultOutboxSize = 256
+	// outboxWriteTimeout bounds a single write so one stalled socket cannot
+	// hold its writer goroutine forever.
+	outboxWriteTimeout = 10 * time.Second
+
+	// SLOW_CONSUMER_POLICY values: what to do when a session's queue is full.
+	slowPolicyDisconnect = "disconnect"
+	slowPolicyDrop       = "drop"
+)
+
+// outbox queues server-initiated frames (broadcasts and pushes) for one
+// session and writes them from its own goroutine, so a slow client never
+// blocks the sender or the other subscribers.
+type outbox struct {
+	sess      easytcp.Session
+	queue     chan outFrame
+	done      chan struct{}
+	stopped   chan struct{} // closed once the writer has returned
+	closeOnce sync.Once
+	dropped   int64
+
+	mu        sync.Mutex
+	delivered map[string]StreamPos // room ID -> last sequenced event written
+}
+
+// outFrame is one packed frame; roomID and pos are set for sequenced room
+// events so the writer can record how far each room was delivered.
+type outFrame struct {
+	data   []byte
+	roomID string
+	pos    StreamPos
+}
+
+var (
+	outboxes   = make(map[interface{}]*outbox)
+	outboxesMu sync.Mutex
+)
+
+// outboxFor returns the session's outbox, starting its writer on first use.
+func outboxFor(sess easytcp.Session) *outbox {
+	loadEnv()
+
+	outboxesMu.Lock()
+	defer outboxesMu.Unlock()
+	if o, ok := outboxes[sess.ID()]; ok {
+		return o
+	}
+	o := &outbox{
+		sess:      sess,
+		queue:     make(chan outFrame, outboxSize),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		delivered: make(map[string]StreamPos),
+	}
+	select {
+	case <-sess.AfterCloseHook():
+		// Late push to a connection that is already gone: hand back a closed
+		// outbox instead of starting a writer nobody will stop.
+		o.close()
+		close(o.stopped)
+		return o
+	default:
+	}
+	outboxes[sess.ID()] = o
+	go o.run()
+	return o
+}
+
+// CloseOutbox stops the session's writer and discards anything still queued.
+// Call it when the connection closes.
+func CloseOutbox(sess easytcp.Session) {
+	outboxesMu.Lock()
+	o, ok := outboxes[sess.ID()]
+	delete(outboxes, sess.ID())
+	outboxesMu.Unlock()
+	if ok {
+		o.close()
+	}
+}
+
+// DeliveredPositions stops the session's writer, waits for a write in flight
+// to finish, and returns per room the position of the last sequenced event
+// actually written to the connection. Rooms with nothing queued since the
+// session subscribed are missing. Call it from the close hook, before
+// CloseOutbox; frames still queued are never written.
+func DeliveredPositions(sess easytcp.Session) map[string]StreamPos {
+	outboxesMu.Lock()
+	o, ok := outboxes[sess.ID()]
+	outboxesMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	o.close()
+	<-o.stopped
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	positions := make(map[string]StreamPos, len(o.delivered))
+	for roomID, pos := range o.delivered {
+		positions[roomID] = pos
+	}
+	return positions
+}
+
+// forgetDelivered drops the session's delivery position for a room it left,
+// so a later subscription starts counting afresh.
+func forgetDelivered(sess easytcp.Session, roomID string) {
+	outboxesMu.Lock()
+	o, ok := outboxes[sess.ID()]
+	outboxesMu.Unlock()
+	if ok {
+		o.mu.Lock()
+		delete(o.delivered, roomID)
+		o.mu.Unlock()
+	}
+}
+
+func (o *outbox) close() {
+	o.closeOnce.Do(func() { close(o.done) })
+}
+
+// free reports how many more frames fit in the queue right now.
+func (o *outbox) free() int {
+	return cap(o.queue) - len(o.queue)
+}
+
+// push enqueues a packed frame without blocking. When the queue is full the
+// slow-consumer policy either drops the frame or disconnects the session.
+func (o *outbox) push(data []byte) {
+	o.enqueue(outFrame{data: data})
+}
+
+// pushEvent enqueues a sequenced room event at pos. The first event queued
+// for a room marks everything before it as delivered: those came with the
+// subscription (a fresh load, or a replay that started there).
+func (o *outbox) pushEvent(roomID string, pos StreamPos, data []byte) {
+	o.mu.Lock()
+	if cur, ok := o.delivered[roomID]; !ok || cur.Epoch != pos.Epoch {
+		o.delivered[roomID] = StreamPos{Epoch: pos.Epoch, Seq: pos.Seq - 1}
+	}
+	o.mu.Unlock()
+
+	o.enqueue(outFrame{data: data, roomID: roomID, pos: pos})
+}
+
+func (o *outbox) enqueue(f outFrame) {
+	select {
+	case <-o.done:
+		return
+	case o.queue <- f:
+		return
+	default:
+	}
+
+	if slowPolicy == slowPolicyDrop {
+		if n := atomic.AddInt64(&o.dropped, 1); n == 1 || n%100 == 0 {
+			log.Printf("session %v outbound queue full, dropped %d message(s)", o.sess.ID(), n)
+		}
+		return
+	}
+	log.Printf("session %v outbound queue full (%d), disconnecting slow consumer", o.sess.ID(), cap(o.queue))
+	o.close()
+	o.sess.Close()
+}
+
+// run writes queued frames until the outbox or the session closes. It leaves
+// the outbox registered after a failed write: the close hook that follows
+// still needs its DeliveredPositions, and unregisters it with CloseOutbox.
+func (o *outbox) run() {
+	defer close(o.stopped)
+
+	conn := o.sess.Conn()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-o.sess.AfterCloseHook():
+			return
+		case f := <-o.queue:
+			conn.SetWriteDeadline(time.Now().Add(outboxWriteTimeout))
+			_, err := conn.Write(f.data)
+			conn.SetWriteDeadline(time.Time{})
+			if err != nil {
+				log.Printf("push to session %v failed: %v", o.sess.ID(), err)
+				o.sess.Close()
+				return
+			}
+			if f.roomID != "" {
+				o.mu.Lock()
+				o.delivered[f.roomID] = f.pos
+				o.mu.Unlock()
+			}
+		}
+	}
+}
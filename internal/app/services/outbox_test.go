@@ -0,0 +1,88 @@
+package services
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// useOutboxConfig sets the queue length and slow-consumer policy for the
+// test's outboxes, restoring the previous ones afterwards.
+func useOutboxConfig(t *testing.T, size int, policy string) {
+	t.Helper()
+	loadEnv()
+	oldSize, oldPolicy := outboxSize, slowPolicy
+	outboxSize, slowPolicy = size, policy
+	t.Cleanup(func() { outboxSize, slowPolicy = oldSize, oldPolicy })
+}
+
+// fillOutbox leaves one frame stuck in the writer (nobody reads the pipe yet)
+// and one in a queue of length one, so the next push overflows.
+func fillOutbox(t *testing.T, sess *testSession) *outbox {
+	t.Helper()
+	o := outboxFor(sess)
+	SendToSession(sess, easytcp.NewMessage(20, []byte(`"a"`)))
+	waitFor(t, "writer to take the first frame", func() bool { return len(o.queue) == 0 })
+	SendToSession(sess, easytcp.NewMessage(20, []byte(`"b"`)))
+	return o
+}
+
+func TestOutboxDropPolicy(t *testing.T) {
+	useOutboxConfig(t, 1, slowPolicyDrop)
+	sess := newTestSession(t, CodecJSON)
+	o := fillOutbox(t, sess)
+
+	SendToSession(sess, easytcp.NewMessage(20, []byte(`"c"`)))
+	if n := atomic.LoadInt64(&o.dropped); n != 1 {
+		t.Fatalf("dropped = %d, want 1", n)
+	}
+	if sess.isClosed() {
+		t.Fatal("drop policy closed the session")
+	}
+
+	for _, want := range []string{`"a"`, `"b"`} {
+		if got := string(sess.readFrame(t).Data()); got != want {
+			t.Fatalf("frame = %s, want %s", got, want)
+		}
+	}
+	sess.expectNoFrame(t)
+}
+
+func TestOutboxDisconnectPolicy(t *testing.T) {
+	useOutboxConfig(t, 1, slowPolicyDisconnect)
+	sess := newTestSession(t, CodecJSON)
+	o := fillOutbox(t, sess)
+
+	SendToSession(sess, easytcp.NewMessage(20, []byte(`"c"`)))
+	if !sess.isClosed() {
+		t.Fatal("disconnect policy left the session open")
+	}
+	select {
+	case <-o.done:
+	default:
+		t.Fatal("outbox still accepting frames after disconnect")
+	}
+
+	// Once closed, pushes are discarded rather than counted or queued.
+	SendToSession(sess, easytcp.NewMessage(20, []byte(`"d"`)))
+	if n := atomic.LoadInt64(&o.dropped); n != 0 {
+		t.Fatalf("dropped = %d, want 0", n)
+	}
+}
+
+func TestOutboxAfterClose(t *testing.T) {
+	useOutboxConfig(t, 4, slowPolicyDisconnect)
+	sess := newTestSession(t, CodecJSON)
+	sess.closeHook()
+
+	// A push to a session that already closed must not start a writer.
+	o := outboxFor(sess)
+	select {
+	case <-o.stopped:
+	default:
+		t.Fatal("outbox for a closed session has a running writer")
+	}
+	SendToSession(sess, easytcp.NewMessage(20, []byte(`"a"`)))
+	sess.expectNoFrame(t)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+const (
+	// ProtocolVersionLegacy is assumed for connections that never send a
+	// handshake (clients built before route 2 existed).
+	ProtocolVersionLegacy = 1
+	// ProtocolVersionFeatures is the first protocol with feature flags.
+	ProtocolVersionFeatures = 2
+	// ProtocolVersionCurrent is the newest protocol this server speaks.
+	ProtocolVersionCurrent = 2
+
+	CodecJSON = "json"
+)
+
+// Feature flags a client can ask for in the handshake. They exist from
+// protocol 2 on; connections on protocol 1 (legacy or negotiated down) get
+// none, so they keep the payloads clients saw before the handshake existed.
+const (
+	FeatureEventSeq   = "event_seq"   // seq on room broadcasts, resync (260)
+	FeatureResume     = "resume"      // session resumption (13)
+	FeatureErrorCodes = "error_codes" // error envelope with codes
+	FeatureRequestID  = "request_id"  // request_id echo
+	FeatureHeartbeat  = "heartbeat"   // client pings (3); silent connections are closed
+)
+
+var (
+	// ErrHandshakeTooOld means the client's newest version is below the
+	// server's minimum; ErrHandshakeTooNew that its oldest is above ours.
+	ErrHandshakeTooOld = errors.New("client protocol is too old")
+	ErrHandshakeTooNew = errors.New("client protocol is newer than this server")
+	ErrNoCommonCodec   = errors.New("no supported codec")
+)
+
+// serverCodecs lists the payload codecs this server can speak, preferred first.
+var serverCodecs = []string{CodecJSON, CodecMsgpack, CodecProtobuf}
+
+// serverFeatures lists the feature flags this server can enable.
+var serverFeatures = []string{FeatureEventSeq, FeatureResume, FeatureErrorCodes, FeatureRequestID, FeatureHeartbeat}
+
+// Protocol is what a connection negotiated on route 2.
+type Protocol struct {
+	Version  int
+	Codec    string
+	Features map[string]bool
+	// Negotiated is false for connections that skipped the handshake.
+	Negotiated bool
+}
+
+// Has reports whether feature was negotiated. Behaviour that changes what a
+// client receives must check it, so clients that didn't ask keep the old
+// payloads.
+func (p Protocol) Has(feature string) bool {
+	return p.Features[feature]
+}
+
+var (
+	protocols   = make(map[interface{}]Protocol)
+	protocolsMu sync.RWMutex
+)
+
+// ProtocolVersionRange returns the oldest and newest protocol versions the
+// server accepts. The oldest comes from MIN_PROTOCOL_VERSION.
+func ProtocolVersionRange() (int, int) {
+	loadEnv()
+	return minProtocolVersion, ProtocolVersionCurrent
+}
+
+// ServerCodecs returns the codecs the server supports, preferred first.
+func ServerCodecs() []string {
+	return append([]string(nil), serverCodecs...)
+}
+
+// ServerFeatures returns every feature flag the server supports.
+func ServerFeatures() []string {
+	return append([]string(nil), serverFeatures...)
+}
+
+// NegotiateProtocol picks the highest version both sides speak, the first of
+// the client's codecs the server supports (JSON when the client lists none)
+// and the intersection of feature flags (none below ProtocolVersionFeatures),
+// then records it for sess.
+func NegotiateProtocol(sess easytcp.Session, clientMin, clientMax int, codecs, features []string) (Protocol, error) {
+	serverMin, serverMax := ProtocolVersionRange()
+	if clientMin <= 0 || clientMin > clientMax {
+		clientMin = clientMax
+	}
+
+	version := clientMax
+	if version > serverMax {
+		version = serverMax
+	}
+	switch {
+	case clientMin > serverMax:
+		return Protocol{}, fmt.Errorf("%w (server speaks %d-%d)", ErrHandshakeTooNew, serverMin, serverMax)
+	case version < serverMin:
+		return Protocol{}, fmt.Errorf("%w (server speaks %d-%d)", ErrHandshakeTooOld, serverMin, serverMax)
+	}
+
+	codec := ""
+	if len(codecs) == 0 {
+		codec = CodecJSON
+	}
+	for _, c := range codecs {
+		if contains(serverCodecs, c) {
+			codec = c
+			break
+		}
+	}
+	if codec == "" {
+		return Protocol{}, fmt.Errorf("%w (server speaks %v)", ErrNoCommonCodec, serverCodecs)
+	}
+
+	p := Protocol{Version: version, Codec: codec, Features: make(map[string]bool), Negotiated: true}
+	for _, f := range features {
+		if version >= ProtocolVersionFeatures && contains(serverFeatures, f) {
+			p.Features[f] = true
+		}
+	}
+
+	protocolsMu.Lock()
+	protocols[sess.ID()] = p
+	protocolsMu.Unlock()
+	return p, nil
+}
+
+// ProtocolFor returns what sess negotiated, or the legacy protocol (JSON, no
+// feature flags) when it never sent a handshake.
+func ProtocolFor(sess easytcp.Session) Protocol {
+	protocolsMu.RLock()
+	p, ok := protocols[sess.ID()]
+	protocolsMu.RUnlock()
+	if !ok {
+		return Protocol{Version: ProtocolVersionLegacy, Codec: CodecJSON}
+	}
+	return p
+}
+
+// HandshakeRequired reports whether sess must complete route 2 before using
+// other routes, which is the case once MIN_PROTOCOL_VERSION drops legacy clients.
+func HandshakeRequired(sess easytcp.Session) bool {
+	if min, _ := ProtocolVersionRange(); min <= ProtocolVersionLegacy {
+		return false
+	}
+	return !ProtocolFor(sess).Negotiated
+}
+
+// ForgetProtocol drops the negotiated protocol when the connection closes.
+func ForgetProtocol(sess easytcp.Session) {
+	protocolsMu.Lock()
+	delete(protocols, sess.ID())
+	protocolsMu.Unlock()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	protodefs "musick-server/proto"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// protoRoutes maps route IDs to the message types of their request and of
+// their response or push; routes not listed travel as JsonPayload.
+var protoRoutes = map[int][2]string{
+	10:  {"LoginRequest", "LoginResponse"},
+	301: {"SendMessageRequest", "ChatMessage"},
+	302: {"", "ChatMessage"},
+	601: {"CreateNoteRequest", "CreateNoteResponse"},
+	602: {"DeleteNoteRequest", "DeleteNoteResponse"},
+	603: {"", "NoteBroadcast"},
+	610: {"ListNotesRequest", "ListNotesResponse"},
+}
+
+const protoJSONPayload = "JsonPayload"
+
+// protoSchemas holds the message descriptors built from proto/*.proto by
+// LoadProtoSchemas, keyed by name relative to the package ("Note",
+// "Outer.Inner").
+var protoSchemas map[string]protoreflect.MessageDescriptor
+
+// protoMessage returns the descriptor for a message in proto/*.proto.
+func protoMessage(name string) protoreflect.MessageDescriptor {
+	return protoSchemas[name]
+}
+
+// LoadProtoSchemas compiles the embedded proto/*.proto files and checks that
+// every message protoRoutes names exists. It must succeed before any
+// connection can negotiate the protobuf codec.
+func LoadProtoSchemas() error {
+	schemas, err := loadProtoSchemas(protodefs.Files)
+	if err != nil {
+		return fmt.Errorf("load proto schemas: %w", err)
+	}
+	for route, names := range protoRoutes {
+		for _, name := range append([]string{protoJSONPayload}, names[:]...) {
+			if name != "" && schemas[name] == nil {
+				return fmt.Errorf("route %d: unknown proto message %s", route, name)
+			}
+		}
+	}
+	protoSchemas = schemas
+	return nil
+}
+
+// loadProtoSchemas compiles every .proto file in fsys with protocompile, so
+// the full language (imports of the well-known types, options, enums, nested
+// messages, oneofs, maps) is accepted.
+func loadProtoSchemas(fsys fs.FS) (map[string]protoreflect.MessageDescriptor, error) {
+	paths, err := fs.Glob(fsys, "*.proto")
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: func(path string) (io.ReadCloser, error) { return fsys.Open(path) },
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make(map[string]protoreflect.MessageDescriptor)
+	for _, fd := range files {
+		addProtoMessages(schemas, string(fd.Package()), fd.Messages())
+	}
+	return schemas, nil
+}
+
+// addProtoMessages records msgs and the messages nested in them. Map entry
+// types are skipped; they are only reachable through their map field.
+func addProtoMessages(schemas map[string]protoreflect.MessageDescriptor, pkg string, msgs protoreflect.MessageDescriptors) {
+	for i := 0; i < msgs.Len(); i++ {
+		md := msgs.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+		name := strings.TrimPrefix(string(md.FullName()), pkg+".")
+		schemas[name] = md
+		addProtoMessages(schemas, pkg, md.Messages())
+	}
+}
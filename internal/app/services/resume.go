@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// ResumeWindow is how long a dropped connection's session can be resumed.
+const ResumeWindow = 2 * time.Minute
+
+// ErrResumeInvalid is returned for unknown, used or expired resume tokens.
+var ErrResumeInvalid = errors.New("resume token is invalid or expired; log in again")
+
+// parkedSession is a closed connection's session kept for ResumeWindow.
+type parkedSession struct {
+	us    *UserSession
+	rooms map[string]StreamPos // room ID -> stream position when the connection closed
+	until time.Time
+}
+
+var (
+	parked   = make(map[string]*parkedSession) // by resume token
+	parkedMu sync.Mutex
+)
+
+// ParkSession keeps a closing connection's session and room subscriptions so
+// the client can pick them up with ResumeSession. Call it from the close hook
+// before the session, its subscriptions and its outbox are removed. Each room
+// resumes after the last event actually written to the old connection, so
+// events still queued when it closed are replayed.
+func ParkSession(sess easytcp.Session) {
+	us := GetSession(sess)
+	if us == nil || !us.Authenticated || us.ResumeToken == "" {
+		return
+	}
+
+	// Rooms with nothing queued resume from where the room is now. Take that
+	// before stopping the writer: an event published in between is then
+	// either in DeliveredPositions or after this position.
+	rooms := make(map[string]StreamPos)
+	for _, roomID := range SessionRooms(sess) {
+		rooms[roomID] = RoomPos(roomID)
+	}
+	for roomID, pos := range DeliveredPositions(sess) {
+		if _, ok := rooms[roomID]; ok {
+			rooms[roomID] = pos
+		}
+	}
+
+	parkedMu.Lock()
+	parked[us.ResumeToken] = &parkedSession{us: us, rooms: rooms, until: time.Now().Add(ResumeWindow)}
+	parkedMu.Unlock()
+}
+
+// ResumeSession claims the parked session for token and installs it on sess
+// with a fresh resume token. Tokens are single use. It returns the restored
+// session and the rooms it was subscribed to, with the stream position each
+// had reached when the old connection closed; the caller re-checks access and
+// resubscribes.
+func ResumeSession(sess easytcp.Session, token string) (*UserSession, map[string]StreamPos, error) {
+	now := time.Now()
+
+	parkedMu.Lock()
+	p, ok := parked[token]
+	delete(parked, token)
+	parkedMu.Unlock()
+
+	if !ok || now.After(p.until) {
+		return nil, nil, ErrResumeInvalid
+	}
+	if !p.us.ExpiresAt.IsZero() && !now.Before(p.us.ExpiresAt) {
+		return nil, nil, ErrResumeInvalid
+	}
+
+	next, err := newRandomToken()
+	if err != nil {
+		log.Printf("resume token generation failed: %v", err)
+	}
+
+	restored := *p.us
+	restored.ResumeToken = next
+	restored.conn = sess
+	restored.warned = false
+	restored.access = newAccessCache()
+
+	sessionsMu.Lock()
+	sessions[sess.ID()] = &restored
+	sessionsMu.Unlock()
+
+	return &restored, p.rooms, nil
+}
+
+func sweepParkedSessions(now time.Time) {
+	parkedMu.Lock()
+	defer parkedMu.Unlock()
+	for token, p := range parked {
+		if now.After(p.until) {
+			delete(parked, token)
+		}
+	}
+}
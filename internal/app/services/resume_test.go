@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParkSessionResumesFromDeliveredPosition(t *testing.T) {
+	busy, quiet := testRoom(t), testRoom(t)
+	sess := newTestSession(t, CodecJSON, FeatureEventSeq, FeatureResume)
+	token := StoreSession(sess, "user-1", "", "user-1", "", time.Time{})
+	if token == "" {
+		t.Fatal("no resume token issued with FeatureResume")
+	}
+	AddSessionToRoom(busy, sess)
+	AddSessionToRoom(quiet, sess)
+	publishN(quiet, 1)
+	publishN(busy, 1)
+	readEvent(t, sess)
+	readEvent(t, sess)
+
+	// Seq 2 and 3 are still queued when the client goes away, and the
+	// writer gives up before the close hook parks the session.
+	publishN(busy, 2)
+	o := outboxFor(sess)
+	sess.peer.Close()
+	waitFor(t, "writer to stop", func() bool {
+		select {
+		case <-o.stopped:
+			return true
+		default:
+			return false
+		}
+	})
+	ParkSession(sess)
+	RemoveSession(sess)
+	CloseOutbox(sess)
+
+	next := newTestSession(t, CodecJSON, FeatureEventSeq, FeatureResume)
+	us, rooms, err := ResumeSession(next, token)
+	if err != nil {
+		t.Fatalf("ResumeSession: %v", err)
+	}
+	if us.UserID != "user-1" || us.ResumeToken == "" || us.ResumeToken == token {
+		t.Fatalf("restored session %+v, want user-1 with a fresh token", us)
+	}
+	want := map[string]StreamPos{
+		busy:  {Epoch: RoomPos(busy).Epoch, Seq: 1},
+		quiet: RoomPos(quiet),
+	}
+	if len(rooms) != len(want) || rooms[busy] != want[busy] || rooms[quiet] != want[quiet] {
+		t.Fatalf("parked rooms = %v, want %v", rooms, want)
+	}
+
+	// The undelivered events are replayed on the new connection.
+	if _, replayed, ok := ResumeRoom(busy, next, rooms[busy]); !ok || replayed != 2 {
+		t.Fatalf("ResumeRoom replayed %d (ok %v), want 2", replayed, ok)
+	}
+}
+
+func TestResumeSessionTokenIsSingleUse(t *testing.T) {
+	sess := newTestSession(t, CodecJSON, FeatureResume)
+	token := StoreSession(sess, "user-1", "", "user-1", "", time.Time{})
+	ParkSession(sess)
+
+	if _, _, err := ResumeSession(newTestSession(t, CodecJSON, FeatureResume), token); err != nil {
+		t.Fatalf("first ResumeSession: %v", err)
+	}
+	if _, _, err := ResumeSession(newTestSession(t, CodecJSON, FeatureResume), token); !errors.Is(err, ErrResumeInvalid) {
+		t.Fatalf("second ResumeSession err = %v, want ErrResumeInvalid", err)
+	}
+}
+
+func TestResumeSessionExpired(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		sweep     bool
+	}{
+		{"token expired", time.Now().Add(-time.Second), false},
+		{"window passed", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := newTestSession(t, CodecJSON, FeatureResume)
+			token := StoreSession(sess, "user-1", "", "user-1", "", tt.expiresAt)
+			ParkSession(sess)
+			if tt.sweep {
+				sweepParkedSessions(time.Now().Add(ResumeWindow + time.Second))
+			}
+			if _, _, err := ResumeSession(newTestSession(t, CodecJSON, FeatureResume), token); !errors.Is(err, ErrResumeInvalid) {
+				t.Fatalf("ResumeSession err = %v, want ErrResumeInvalid", err)
+			}
+		})
+	}
+}
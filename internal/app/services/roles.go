@@ -0,0 +1,83 @@
+package services
+
+import "errors"
+
+// Room roles stored in room_members.role.
+const (
+	RoleOwner     = "owner"
+	RoleEditor    = "editor"
+	RoleCommenter = "commenter"
+	RoleViewer    = "viewer"
+
+	// roleLegacyMember is what JoinRoomByCode has always written; it behaves as editor.
+	roleLegacyMember = "member"
+)
+
+// Permission is an action gated by a member's role.
+type Permission int
+
+const (
+	PermChat Permission = iota
+	PermEditSongs
+	PermEditTracks
+	PermEditNotes
+	PermManageMembers
+	PermManageRoom
+)
+
+// ErrForbidden is returned when the member's role does not allow an action.
+var ErrForbidden = errors.New("your role does not allow this action")
+
+// rolePermissions is the permission matrix. Every role may read room content.
+var rolePermissions = map[string]map[Permission]bool{
+	RoleOwner: {
+		PermChat:          true,
+		PermEditSongs:     true,
+		PermEditTracks:    true,
+		PermEditNotes:     true,
+		PermManageMembers: true,
+		PermManageRoom:    true,
+	},
+	RoleEditor: {
+		PermChat:       true,
+		PermEditSongs:  true,
+		PermEditTracks: true,
+		PermEditNotes:  true,
+	},
+	RoleCommenter: {
+		PermChat: true,
+	},
+	RoleViewer: {},
+}
+
+// normalizeRole maps stored role values onto the known roles; unknown values
+// fall back to viewer so a bad row never grants write access.
+func normalizeRole(role string) string {
+	switch role {
+	case roleLegacyMember:
+		return RoleEditor
+	case RoleOwner, RoleEditor, RoleCommenter, RoleViewer:
+		return role
+	default:
+		return RoleViewer
+	}
+}
+
+// IsAssignableRole reports whether role can be given through promote/demote.
+// Ownership is not assignable; it moves only with the room's owner_id.
+func IsAssignableRole(role string) bool {
+	return role == RoleEditor || role == RoleCommenter || role == RoleViewer
+}
+
+// Can reports whether the member's role grants p.
+func (rm *RoomMember) Can(p Permission) bool {
+	return rolePermissions[normalizeRole(rm.Role)][p]
+}
+
+// Require returns ErrForbidden unless the member's role grants p.
+func (rm *RoomMember) Require(p Permission) error {
+	if !rm.Can(p) {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -12,18 +12,17 @@ import (
 )
 
 type Room struct {
-	ID        string    `json:"id,omitempty"`
-	Code      string    `json:"code"`
-	OwnerID   string    `json:"owner_id"`
-	Title     string    `json:"title"`
-	IsPrivate bool      `json:"is_private"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	ID          string    `json:"id,omitempty"`
+	Code        string    `json:"code"`
+	OwnerID     string    `json:"owner_id"`
+	Title       string    `json:"title"`
+	IsPrivate   bool      `json:"is_private"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
 }
 
 // CreateRoom inserts a new room into Supabase database using the create_room_with_owner function.
-func CreateRoom(ownerID, title string, isPrivate bool) (*Room, error) {
-	loadEnv()
-
+func (sb *Supabase) CreateRoom(ownerID, title string, isPrivate bool) (*Room, error) {
 	// Call the PostgreSQL function with owner_id parameter
 	payload := map[string]interface{}{
 		"_owner_id":   ownerID,
@@ -36,12 +35,12 @@ func CreateRoom(ownerID, title string, isPrivate bool) (*Room, error) {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req, _ := http.NewRequest("POST", supabaseURL+"/rest/v1/rpc/create_room_with_owner", bytes.NewReader(payloadBytes))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/rpc/create_room_with_owner", bytes.NewReader(payloadBytes))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create room: %w", err)
 	}
@@ -68,20 +67,18 @@ func CreateRoom(ownerID, title string, isPrivate bool) (*Room, error) {
 }
 
 // ListRoomsByUser returns rooms the user has joined (via room_members).
-func ListRoomsByUser(userID string) ([]Room, error) {
-	loadEnv()
-
+func (sb *Supabase) ListRoomsByUser(userID string) ([]Room, error) {
 	// Query rooms with an inner join on room_members to ensure the user is a member.
 	q := url.Values{}
-	q.Set("select", "id,code,owner_id,title,is_private,created_at,room_members!inner(role,account_id)")
+	q.Set("select", "id,code,owner_id,title,is_private,description,created_at,room_members!inner(role,account_id)")
 	q.Set("room_members.account_id", "eq."+userID)
 
-	endpoint := fmt.Sprintf("%s/rest/v1/rooms?%s", supabaseURL, q.Encode())
+	endpoint := fmt.Sprintf("%s/rest/v1/rooms?%s", sb.url, q.Encode())
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch rooms: %w", err)
 	}
@@ -94,12 +91,13 @@ func ListRoomsByUser(userID string) ([]Room, error) {
 
 	// Response is rows from rooms table; we only need the room fields.
 	var rows []struct {
-		ID        string    `json:"id"`
-		Code      string    `json:"code"`
-		OwnerID   string    `json:"owner_id"`
-		Title     string    `json:"title"`
-		IsPrivate bool      `json:"is_private"`
-		CreatedAt time.Time `json:"created_at"`
+		ID          string    `json:"id"`
+		Code        string    `json:"code"`
+		OwnerID     string    `json:"owner_id"`
+		Title       string    `json:"title"`
+		IsPrivate   bool      `json:"is_private"`
+		Description string    `json:"description"`
+		CreatedAt   time.Time `json:"created_at"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
 		return nil, fmt.Errorf("failed to decode rooms: %w", err)
@@ -108,12 +106,13 @@ func ListRoomsByUser(userID string) ([]Room, error) {
 	rooms := make([]Room, 0, len(rows))
 	for _, r := range rows {
 		rooms = append(rooms, Room{
-			ID:        r.ID,
-			Code:      r.Code,
-			OwnerID:   r.OwnerID,
-			Title:     r.Title,
-			IsPrivate: r.IsPrivate,
-			CreatedAt: r.CreatedAt,
+			ID:          r.ID,
+			Code:        r.Code,
+			OwnerID:     r.OwnerID,
+			Title:       r.Title,
+			IsPrivate:   r.IsPrivate,
+			Description: r.Description,
+			CreatedAt:   r.CreatedAt,
 		})
 	}
 
@@ -123,10 +122,8 @@ func ListRoomsByUser(userID string) ([]Room, error) {
 // FindPublicRooms returns public rooms filtered by title if provided.
 // When no title is provided, it fetches a set of recent public rooms and picks up to five at random.
 // Rooms the user is already a member of are excluded client-side after fetch.
-func FindPublicRooms(name string, userID string) ([]Room, error) {
-	loadEnv()
-
-	joined, err := ListRoomsByUser(userID)
+func (sb *Supabase) FindPublicRooms(name string, userID string) ([]Room, error) {
+	joined, err := sb.ListRoomsByUser(userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch user rooms: %w", err)
 	}
@@ -136,7 +133,7 @@ func FindPublicRooms(name string, userID string) ([]Room, error) {
 	}
 
 	q := url.Values{}
-	q.Set("select", "id,code,owner_id,title,is_private,created_at")
+	q.Set("select", "id,code,owner_id,title,is_private,description,created_at")
 	q.Set("is_private", "eq.false")
 
 	if name != "" {
@@ -148,12 +145,12 @@ func FindPublicRooms(name string, userID string) ([]Room, error) {
 		q.Set("limit", "30")
 	}
 
-	endpoint := fmt.Sprintf("%s/rest/v1/rooms?%s", supabaseURL, q.Encode())
+	endpoint := fmt.Sprintf("%s/rest/v1/rooms?%s", sb.url, q.Encode())
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search public rooms: %w", err)
 	}
@@ -165,12 +162,13 @@ func FindPublicRooms(name string, userID string) ([]Room, error) {
 	}
 
 	var rows []struct {
-		ID        string    `json:"id"`
-		Code      string    `json:"code"`
-		OwnerID   string    `json:"owner_id"`
-		Title     string    `json:"title"`
-		IsPrivate bool      `json:"is_private"`
-		CreatedAt time.Time `json:"created_at"`
+		ID          string    `json:"id"`
+		Code        string    `json:"code"`
+		OwnerID     string    `json:"owner_id"`
+		Title       string    `json:"title"`
+		IsPrivate   bool      `json:"is_private"`
+		Description string    `json:"description"`
+		CreatedAt   time.Time `json:"created_at"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
 		return nil, fmt.Errorf("failed to decode public rooms: %w", err)
@@ -182,12 +180,13 @@ func FindPublicRooms(name string, userID string) ([]Room, error) {
 			continue
 		}
 		rooms = append(rooms, Room{
-			ID:        r.ID,
-			Code:      r.Code,
-			OwnerID:   r.OwnerID,
-			Title:     r.Title,
-			IsPrivate: r.IsPrivate,
-			CreatedAt: r.CreatedAt,
+			ID:          r.ID,
+			Code:        r.Code,
+			OwnerID:     r.OwnerID,
+			Title:       r.Title,
+			IsPrivate:   r.IsPrivate,
+			Description: r.Description,
+			CreatedAt:   r.CreatedAt,
 		})
 	}
 
@@ -201,3 +200,77 @@ func FindPublicRooms(name string, userID string) ([]Room, error) {
 
 	return rooms, nil
 }
+
+// UpdateRoom applies the provided settings and returns the updated room.
+func (sb *Supabase) UpdateRoom(roomID string, title *string, isPrivate *bool, description *string) (*Room, error) {
+	payload := map[string]interface{}{}
+	if title != nil {
+		payload["title"] = *title
+	}
+	if isPrivate != nil {
+		payload["is_private"] = *isPrivate
+	}
+	if description != nil {
+		payload["description"] = *description
+	}
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("no fields to update")
+	}
+	body, _ := json.Marshal(payload)
+
+	endpoint := fmt.Sprintf("%s/rest/v1/rooms?id=eq.%s&select=id,code,owner_id,title,is_private,description,created_at", sb.url, url.QueryEscape(roomID))
+	req, _ := http.NewRequest("PATCH", endpoint, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("update room request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("update room failed (status %d): %s", resp.StatusCode, b)
+	}
+
+	var rows []Room
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode room update: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+	return &rows[0], nil
+}
+
+// DeleteRoom runs the delete_room database function, which removes a room and
+// everything that belongs to it (notes, tracks, song snapshots, songs,
+// messages, invites, bans, members) in one transaction, so the cascade does
+// not depend on foreign key settings and never stops halfway. Deleting a room
+// that is already gone succeeds.
+func (sb *Supabase) DeleteRoom(roomID string) error {
+	body, err := json.Marshal(map[string]interface{}{"_room_id": roomID})
+	if err != nil {
+		return fmt.Errorf("marshal room delete: %w", err)
+	}
+
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/rpc/delete_room", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("delete room request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete room failed (status %d): %s", resp.StatusCode, b)
+	}
+	return nil
+}
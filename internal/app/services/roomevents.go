@@ -0,0 +1,235 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// roomEventBufferSize is how many recent events each room keeps for replay.
+const roomEventBufferSize = 256
+
+// roomEvent is one sequenced broadcast kept for replay, with its frames
+// cached per codec. Guarded by the stream's mu.
+type roomEvent struct {
+	seq    uint64
+	frames *frameCache
+	reset  bool // replaced room content wholesale; replay can't bridge it
+}
+
+// StreamPos is a position in a room's event stream. Epoch names the stream:
+// a room gets a new one whenever its seq starts over (after a restart, or once
+// an idle room's buffer is freed), so a Seq means nothing without its Epoch.
+type StreamPos struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+// roomStream orders a room's content events. mu is held while an event is
+// numbered and queued, so every subscriber sees events in seq order.
+type roomStream struct {
+	mu      sync.Mutex
+	epoch   string
+	seq     uint64
+	events  []roomEvent // oldest first, at most roomEventBufferSize
+	dropped bool        // removed from roomStreams; lockStream starts a new one
+}
+
+var (
+	roomStreams   = make(map[string]*roomStream)
+	roomStreamsMu sync.Mutex
+)
+
+// lockStream returns the room's stream, created on first use, with its mu
+// held. A stream dropped while the caller waited for it is skipped, so
+// nothing is published to or replayed from a stream nobody can reach.
+func lockStream(roomID string) *roomStream {
+	for {
+		roomStreamsMu.Lock()
+		st, ok := roomStreams[roomID]
+		if !ok {
+			st = &roomStream{epoch: newStreamEpoch()}
+			roomStreams[roomID] = st
+		}
+		roomStreamsMu.Unlock()
+
+		st.mu.Lock()
+		if !st.dropped {
+			return st
+		}
+		st.mu.Unlock()
+	}
+}
+
+// newStreamEpoch returns a short random stream name.
+func newStreamEpoch() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// dropRoomStream forgets a room's sequence and buffer (after it is deleted).
+func dropRoomStream(roomID string) {
+	roomStreamsMu.Lock()
+	st, ok := roomStreams[roomID]
+	delete(roomStreams, roomID)
+	roomStreamsMu.Unlock()
+
+	if ok {
+		st.mu.Lock()
+		st.dropped = true
+		st.mu.Unlock()
+	}
+}
+
+// sweepRoomStreams frees the sequence and replay buffer of rooms nobody has
+// been subscribed to for ResumeWindow: by then every parked session that could
+// resume into them is gone too. A room that becomes active again starts a new
+// stream at seq 1 under a new epoch, so clients still holding a position in
+// the old one are told to reload (see ResumeRoom), as after a restart.
+func sweepRoomStreams(now time.Time) {
+	roomStreamsMu.Lock()
+	defer roomStreamsMu.Unlock()
+	for roomID, st := range roomStreams {
+		// Under st.mu nobody can subscribe through ResumeRoom or publish.
+		st.mu.Lock()
+		if roomIdleFor(roomID, now) >= ResumeWindow {
+			st.dropped = true
+			delete(roomStreams, roomID)
+		}
+		st.mu.Unlock()
+	}
+
+	// Rooms that went idle without ever having a stream need no clock either.
+	roomSubsMu.Lock()
+	for roomID, since := range roomIdle {
+		if _, ok := roomStreams[roomID]; !ok && now.Sub(since) >= ResumeWindow {
+			delete(roomIdle, roomID)
+		}
+	}
+	roomSubsMu.Unlock()
+}
+
+// stampSeq adds "epoch" and "seq" as the first fields of a JSON object
+// payload. Epochs are base64url, so they need no escaping.
+func stampSeq(data []byte, pos StreamPos) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	out := make([]byte, 0, len(data)+48)
+	out = append(out, `{"epoch":"`...)
+	out = append(out, pos.Epoch...)
+	out = append(out, `","seq":`...)
+	out = strconv.AppendUint(out, pos.Seq, 10)
+	if data[1] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...)
+}
+
+// PublishToRoom broadcasts a room content event (302, 603, 606, ...) with the
+// room's epoch and next sequence number stamped into its JSON payload and keeps it in
+// the replay buffer used by ResumeRoom. Sessions without FeatureEventSeq get
+// the payload unstamped.
+func PublishToRoom(roomID string, msg *easytcp.Message) {
+	publishToRoom(roomID, msg, false)
+}
+
+// PublishResetToRoom publishes a sequenced event that replaced room content
+// wholesale (a snapshot restore, 633). Subscribers get it like any other, but
+// a resync or resume whose gap includes it answers reload instead of
+// replaying: events before it describe content that no longer exists.
+func PublishResetToRoom(roomID string, msg *easytcp.Message) {
+	publishToRoom(roomID, msg, true)
+}
+
+func publishToRoom(roomID string, msg *easytcp.Message, reset bool) {
+	st := lockStream(roomID)
+	defer st.mu.Unlock()
+
+	st.seq++
+	frames := newFrameCache(easytcp.NewMessage(msg.ID(), stampSeq(msg.Data(), st.pos())))
+	frames.plain = msg
+	st.events = append(st.events, roomEvent{seq: st.seq, frames: frames, reset: reset})
+	if n := len(st.events); n > roomEventBufferSize {
+		st.events = append([]roomEvent(nil), st.events[n-roomEventBufferSize:]...)
+	}
+
+	for _, sess := range roomTargets(roomID, nil) {
+		data, err := frames.frameFor(sess)
+		if err != nil {
+			log.Printf("broadcast pack failed for room %s: %v", roomID, err)
+			continue
+		}
+		outboxFor(sess).pushEvent(roomID, st.pos(), data)
+	}
+}
+
+// pos returns the stream's latest position. Callers must hold st.mu.
+func (st *roomStream) pos() StreamPos {
+	return StreamPos{Epoch: st.epoch, Seq: st.seq}
+}
+
+// RoomPos returns the position of the room's latest event.
+func RoomPos(roomID string) StreamPos {
+	st := lockStream(roomID)
+	defer st.mu.Unlock()
+	return st.pos()
+}
+
+// ResumeRoom subscribes sess to the room and queues every buffered event
+// after the position after, in order, ahead of any new ones. It returns the
+// room's current position and how many events were replayed. A zero
+// after.Seq means the client has applied nothing yet, whatever its epoch. ok
+// is false when the gap can't be filled (after is from another epoch, events
+// fell out of the buffer, the gap spans a PublishResetToRoom event, or the
+// replay would overflow the session's outbound queue); the session is still
+// subscribed and the client should reload through 510/610.
+func ResumeRoom(roomID string, sess easytcp.Session, after StreamPos) (pos StreamPos, replayed int, ok bool) {
+	st := lockStream(roomID)
+	defer st.mu.Unlock()
+
+	// Subscribing under the stream lock means nothing published after the
+	// snapshot below can be missed.
+	AddSessionToRoom(roomID, sess)
+
+	pos = st.pos()
+	if (after.Seq > 0 && after.Epoch != st.epoch) || after.Seq > st.seq {
+		return pos, 0, false
+	}
+	missing := int(st.seq - after.Seq)
+	if missing == 0 {
+		return pos, 0, true
+	}
+	if missing > len(st.events) {
+		return pos, 0, false
+	}
+
+	replay := st.events[len(st.events)-missing:]
+	for _, ev := range replay {
+		if ev.reset {
+			return pos, 0, false
+		}
+	}
+
+	o := outboxFor(sess)
+	if missing > o.free() {
+		return pos, 0, false
+	}
+	for _, ev := range replay {
+		data, err := ev.frames.frameFor(sess)
+		if err != nil {
+			log.Printf("replay pack failed for room %s: %v", roomID, err)
+			return pos, 0, false
+		}
+		o.pushEvent(roomID, StreamPos{Epoch: st.epoch, Seq: ev.seq}, data)
+	}
+	return pos, missing, true
+}
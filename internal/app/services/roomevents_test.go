@@ -0,0 +1,214 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// testRoom returns a room ID unique to the test whose stream is dropped
+// when the test ends.
+func testRoom(t *testing.T) string {
+	t.Helper()
+	roomID := "room-" + newStreamEpoch()
+	t.Cleanup(func() { dropRoomStream(roomID) })
+	return roomID
+}
+
+// publishN publishes n numbered events (route 603) to the room.
+func publishN(roomID string, n int) {
+	for i := 0; i < n; i++ {
+		PublishToRoom(roomID, easytcp.NewMessage(603, []byte(fmt.Sprintf(`{"n":%d}`, i))))
+	}
+}
+
+type stampedEvent struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+	N     int    `json:"n"`
+}
+
+func readEvent(t *testing.T, sess *testSession) stampedEvent {
+	t.Helper()
+	var ev stampedEvent
+	msg := sess.readFrame(t)
+	if err := json.Unmarshal(msg.Data(), &ev); err != nil {
+		t.Fatalf("decode event %s: %v", msg.Data(), err)
+	}
+	return ev
+}
+
+func TestStampSeq(t *testing.T) {
+	pos := StreamPos{Epoch: "abc", Seq: 7}
+	tests := []struct {
+		in, want string
+	}{
+		{`{"n":1}`, `{"epoch":"abc","seq":7,"n":1}`},
+		{`{}`, `{"epoch":"abc","seq":7}`},
+		{`[1,2]`, `[1,2]`},
+		{`""`, `""`},
+	}
+	for _, tt := range tests {
+		if got := string(stampSeq([]byte(tt.in), pos)); got != tt.want {
+			t.Errorf("stampSeq(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPublishToRoomStampsOnlyEventSeqSessions(t *testing.T) {
+	roomID := testRoom(t)
+	seq := newTestSession(t, CodecJSON, FeatureEventSeq)
+	plain := newTestSession(t, CodecJSON)
+	AddSessionToRoom(roomID, seq)
+	AddSessionToRoom(roomID, plain)
+
+	PublishToRoom(roomID, easytcp.NewMessage(603, []byte(`{"n":5}`)))
+
+	ev := readEvent(t, seq)
+	if want := RoomPos(roomID); ev.Epoch != want.Epoch || ev.Seq != 1 || ev.N != 5 {
+		t.Fatalf("stamped event = %+v, want epoch %s seq 1 n 5", ev, want.Epoch)
+	}
+	if got := string(plain.readFrame(t).Data()); got != `{"n":5}` {
+		t.Fatalf("plain event = %s, want unstamped payload", got)
+	}
+}
+
+func TestResumeRoomReplaysInOrder(t *testing.T) {
+	roomID := testRoom(t)
+	publishN(roomID, 3)
+	epoch := RoomPos(roomID).Epoch
+
+	sess := newTestSession(t, CodecJSON, FeatureEventSeq)
+	pos, replayed, ok := ResumeRoom(roomID, sess, StreamPos{Epoch: epoch, Seq: 1})
+	if !ok || replayed != 2 || pos != (StreamPos{Epoch: epoch, Seq: 3}) {
+		t.Fatalf("ResumeRoom = %+v, %d, %v; want seq 3, 2 replayed, ok", pos, replayed, ok)
+	}
+	for _, want := range []uint64{2, 3} {
+		if ev := readEvent(t, sess); ev.Epoch != epoch || ev.Seq != want || ev.N != int(want-1) {
+			t.Fatalf("replayed %+v, want seq %d", ev, want)
+		}
+	}
+
+	// Live events follow the replay.
+	publishN(roomID, 1)
+	if ev := readEvent(t, sess); ev.Seq != 4 {
+		t.Fatalf("live event seq = %d, want 4", ev.Seq)
+	}
+}
+
+func TestResumeRoom(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(roomID string)
+		after     func(epoch string) StreamPos
+		queue     int
+		wantOK    bool
+		wantCount int
+	}{
+		{
+			name:   "up to date",
+			setup:  func(roomID string) { publishN(roomID, 2) },
+			after:  func(epoch string) StreamPos { return StreamPos{Epoch: epoch, Seq: 2} },
+			wantOK: true,
+		},
+		{
+			name:      "fresh client replays everything",
+			setup:     func(roomID string) { publishN(roomID, 2) },
+			after:     func(string) StreamPos { return StreamPos{Epoch: "other", Seq: 0} },
+			wantOK:    true,
+			wantCount: 2,
+		},
+		{
+			name:  "other epoch",
+			setup: func(roomID string) { publishN(roomID, 2) },
+			after: func(string) StreamPos { return StreamPos{Epoch: "other", Seq: 1} },
+		},
+		{
+			name:  "ahead of the stream",
+			setup: func(roomID string) { publishN(roomID, 2) },
+			after: func(epoch string) StreamPos { return StreamPos{Epoch: epoch, Seq: 5} },
+		},
+		{
+			name:  "fell out of the buffer",
+			setup: func(roomID string) { publishN(roomID, roomEventBufferSize+2) },
+			after: func(epoch string) StreamPos { return StreamPos{Epoch: epoch, Seq: 1} },
+			queue: roomEventBufferSize * 2,
+		},
+		{
+			name: "gap spans a reset",
+			setup: func(roomID string) {
+				publishN(roomID, 1)
+				PublishResetToRoom(roomID, easytcp.NewMessage(633, []byte(`{}`)))
+				publishN(roomID, 1)
+			},
+			after: func(epoch string) StreamPos { return StreamPos{Epoch: epoch, Seq: 1} },
+		},
+		{
+			name: "reset already applied",
+			setup: func(roomID string) {
+				publishN(roomID, 1)
+				PublishResetToRoom(roomID, easytcp.NewMessage(633, []byte(`{}`)))
+				publishN(roomID, 1)
+			},
+			after:     func(epoch string) StreamPos { return StreamPos{Epoch: epoch, Seq: 2} },
+			wantOK:    true,
+			wantCount: 1,
+		},
+		{
+			name:  "replay overflows the queue",
+			setup: func(roomID string) { publishN(roomID, 3) },
+			after: func(epoch string) StreamPos { return StreamPos{Epoch: epoch, Seq: 0} },
+			queue: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.queue > 0 {
+				useOutboxConfig(t, tt.queue, slowPolicyDisconnect)
+			}
+			roomID := testRoom(t)
+			tt.setup(roomID)
+			cur := RoomPos(roomID)
+
+			sess := newTestSession(t, CodecJSON, FeatureEventSeq)
+			pos, replayed, ok := ResumeRoom(roomID, sess, tt.after(cur.Epoch))
+			if ok != tt.wantOK || replayed != tt.wantCount || pos != cur {
+				t.Fatalf("ResumeRoom = %+v, %d, %v; want %+v, %d, %v", pos, replayed, ok, cur, tt.wantCount, tt.wantOK)
+			}
+			if !slices.Contains(SessionRooms(sess), roomID) {
+				t.Fatal("session not subscribed after ResumeRoom")
+			}
+			for i := 0; i < tt.wantCount; i++ {
+				readEvent(t, sess)
+			}
+			sess.expectNoFrame(t)
+		})
+	}
+}
+
+func TestSweepRoomStreamsStartsNewEpoch(t *testing.T) {
+	roomID := testRoom(t)
+	publishN(roomID, 2)
+	old := RoomPos(roomID)
+
+	now := time.Now()
+	sweepRoomStreams(now) // starts the room's idle clock
+	if got := RoomPos(roomID); got != old {
+		t.Fatalf("stream swept too early: %+v, want %+v", got, old)
+	}
+	sweepRoomStreams(now.Add(ResumeWindow))
+
+	got := RoomPos(roomID)
+	if got.Seq != 0 || got.Epoch == old.Epoch {
+		t.Fatalf("after sweep RoomPos = %+v, want seq 0 under a new epoch", got)
+	}
+	sess := newTestSession(t, CodecJSON, FeatureEventSeq)
+	if _, _, ok := ResumeRoom(roomID, sess, old); ok {
+		t.Fatal("resume from the swept epoch did not ask for a reload")
+	}
+}
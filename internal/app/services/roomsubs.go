@@ -1,75 +1,272 @@
 package services
 
 import (
+	"encoding/json"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/DarthPestilane/easytcp"
 )
 
-// roomSubs tracks active sessions per room for broadcasting.
+// roomSub is one subscribed session; the user is captured when it subscribes
+// so presence still knows who left after the session itself is gone.
+type roomSub struct {
+	sess     easytcp.Session
+	userID   string
+	userName string
+}
+
+// roomSubs tracks active sessions per room for broadcasting; roomIdle when
+// rooms lost their last subscriber, for sweepRoomStreams.
 var (
-	roomSubs   = make(map[string]map[interface{}]easytcp.Session)
+	roomSubs   = make(map[string]map[interface{}]roomSub)
+	roomIdle   = make(map[string]time.Time)
 	roomSubsMu sync.RWMutex
 	roomPacker = easytcp.NewDefaultPacker()
 )
 
+// PresenceEntry is one online user in a room; several connections from the
+// same user collapse into one entry.
+type PresenceEntry struct {
+	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name,omitempty"`
+	Connections int    `json:"connections"`
+}
+
+// PresenceEvent is broadcast on route 251 when a user comes online in a room
+// (first connection) or goes offline (last connection).
+type PresenceEvent struct {
+	Event    string `json:"event"` // "joined" or "left"
+	RoomID   string `json:"room_id"`
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name,omitempty"`
+}
+
+// userPresentLocked reports whether any subscription in subs belongs to userID.
+// Callers must hold roomSubsMu.
+func userPresentLocked(subs map[interface{}]roomSub, userID string) bool {
+	for _, sub := range subs {
+		if sub.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// removeSubLocked drops one session from a room and returns a "left" event if
+// it was the user's last connection there. Callers must hold roomSubsMu.
+func removeSubLocked(roomID string, id interface{}) *PresenceEvent {
+	subs, ok := roomSubs[roomID]
+	if !ok {
+		return nil
+	}
+	sub, ok := subs[id]
+	if !ok {
+		return nil
+	}
+	delete(subs, id)
+	if len(subs) == 0 {
+		delete(roomSubs, roomID)
+		roomIdle[roomID] = time.Now()
+	}
+	if sub.userID == "" || userPresentLocked(subs, sub.userID) {
+		return nil
+	}
+	return &PresenceEvent{Event: "left", RoomID: roomID, UserID: sub.userID, UserName: sub.userName}
+}
+
+// broadcastPresence sends presence events; call it without holding roomSubsMu.
+func broadcastPresence(events []*PresenceEvent, skipID interface{}) {
+	for _, ev := range events {
+		if ev == nil {
+			continue
+		}
+		if b, err := json.Marshal(ev); err == nil {
+			BroadcastToRoom(ev.RoomID, easytcp.NewMessage(251, b), skipID)
+		}
+	}
+}
+
 // AddSessionToRoom tracks a session as present in a room.
 func AddSessionToRoom(roomID string, sess easytcp.Session) {
+	sub := roomSub{sess: sess}
+	if us := GetSession(sess); us != nil {
+		sub.userID = us.UserID
+		sub.userName = us.UserName
+	}
+
 	roomSubsMu.Lock()
-	defer roomSubsMu.Unlock()
 	if roomSubs[roomID] == nil {
-		roomSubs[roomID] = make(map[interface{}]easytcp.Session)
+		roomSubs[roomID] = make(map[interface{}]roomSub)
+		delete(roomIdle, roomID)
+	}
+	_, already := roomSubs[roomID][sess.ID()]
+	arrived := !already && sub.userID != "" && !userPresentLocked(roomSubs[roomID], sub.userID)
+	roomSubs[roomID][sess.ID()] = sub
+	roomSubsMu.Unlock()
+
+	if arrived {
+		broadcastPresence([]*PresenceEvent{{Event: "joined", RoomID: roomID, UserID: sub.userID, UserName: sub.userName}}, sess.ID())
 	}
-	roomSubs[roomID][sess.ID()] = sess
 }
 
 // RemoveSessionFromRoom removes a session from a specific room.
 func RemoveSessionFromRoom(roomID string, sess easytcp.Session) {
 	roomSubsMu.Lock()
-	defer roomSubsMu.Unlock()
-	if subs, ok := roomSubs[roomID]; ok {
-		delete(subs, sess.ID())
-		if len(subs) == 0 {
-			delete(roomSubs, roomID)
-		}
-	}
+	ev := removeSubLocked(roomID, sess.ID())
+	roomSubsMu.Unlock()
+
+	forgetDelivered(sess, roomID)
+
+	broadcastPresence([]*PresenceEvent{ev}, nil)
 }
 
 // RemoveSessionFromAllRooms removes a session from all tracked rooms (on disconnect).
 func RemoveSessionFromAllRooms(sess easytcp.Session) {
+	var events []*PresenceEvent
+	roomSubsMu.Lock()
+	for roomID := range roomSubs {
+		events = append(events, removeSubLocked(roomID, sess.ID()))
+	}
+	roomSubsMu.Unlock()
+
+	broadcastPresence(events, nil)
+}
+
+// RemoveUserFromRoom drops every session of userID from a room's subscriptions
+// and forgets their cached membership (after a kick or ban).
+func RemoveUserFromRoom(roomID, userID string) {
+	var events []*PresenceEvent
+	var removed []easytcp.Session
+	roomSubsMu.Lock()
+	for id, sub := range roomSubs[roomID] {
+		if sub.userID == userID {
+			events = append(events, removeSubLocked(roomID, id))
+			removed = append(removed, sub.sess)
+		}
+	}
+	roomSubsMu.Unlock()
+
+	for _, sess := range removed {
+		forgetDelivered(sess, roomID)
+	}
+
+	InvalidateMembership(userID, roomID)
+	broadcastPresence(events, nil)
+}
+
+// EvictRoom drops every subscription to a room (after it is deleted).
+func EvictRoom(roomID string) {
+	roomSubsMu.Lock()
+	delete(roomSubs, roomID)
+	delete(roomIdle, roomID)
+	roomSubsMu.Unlock()
+
+	dropRoomStream(roomID)
+	dropRoomHistory(roomID)
+	InvalidateRoom(roomID)
+}
+
+// roomIdleFor returns how long roomID has had no subscribers, 0 while it has
+// some. A room never seen idle starts its idle clock now.
+func roomIdleFor(roomID string, now time.Time) time.Duration {
 	roomSubsMu.Lock()
 	defer roomSubsMu.Unlock()
+	if len(roomSubs[roomID]) > 0 {
+		return 0
+	}
+	since, ok := roomIdle[roomID]
+	if !ok {
+		roomIdle[roomID] = now
+		return 0
+	}
+	return now.Sub(since)
+}
+
+// SessionRooms lists the rooms a session is subscribed to.
+func SessionRooms(sess easytcp.Session) []string {
+	roomSubsMu.RLock()
+	defer roomSubsMu.RUnlock()
+	var rooms []string
 	for roomID, subs := range roomSubs {
-		delete(subs, sess.ID())
-		if len(subs) == 0 {
-			delete(roomSubs, roomID)
+		if _, ok := subs[sess.ID()]; ok {
+			rooms = append(rooms, roomID)
 		}
 	}
+	sort.Strings(rooms)
+	return rooms
 }
 
-// BroadcastToRoom sends a message to all sessions tracked in the room.
-// If skipID is non-nil, that session ID will not receive the broadcast.
-func BroadcastToRoom(roomID string, msg *easytcp.Message, skipID interface{}) {
+// RoomPresence lists the users currently subscribed to a room, one entry per user.
+func RoomPresence(roomID string) []PresenceEntry {
 	roomSubsMu.RLock()
-	subs := roomSubs[roomID]
-	roomSubsMu.RUnlock()
-	if len(subs) == 0 {
-		return
+	byUser := make(map[string]*PresenceEntry)
+	for _, sub := range roomSubs[roomID] {
+		if sub.userID == "" {
+			continue
+		}
+		entry, ok := byUser[sub.userID]
+		if !ok {
+			entry = &PresenceEntry{UserID: sub.userID, UserName: sub.userName}
+			byUser[sub.userID] = entry
+		}
+		entry.Connections++
 	}
+	roomSubsMu.RUnlock()
 
-	data, err := roomPacker.Pack(msg)
-	if err != nil {
-		log.Printf("broadcast pack failed for room %s: %v", roomID, err)
-		return
+	entries := make([]PresenceEntry, 0, len(byUser))
+	for _, e := range byUser {
+		entries = append(entries, *e)
 	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UserID < entries[j].UserID })
+	return entries
+}
 
-	for id, sess := range subs {
+// roomTargets snapshots the sessions subscribed to a room, minus skipID.
+func roomTargets(roomID string, skipID interface{}) []easytcp.Session {
+	roomSubsMu.RLock()
+	defer roomSubsMu.RUnlock()
+	targets := make([]easytcp.Session, 0, len(roomSubs[roomID]))
+	for id, sub := range roomSubs[roomID] {
 		if skipID != nil && id == skipID {
 			continue
 		}
-		if _, err := sess.Conn().Write(data); err != nil {
-			log.Printf("broadcast to room %s failed for session %v: %v", roomID, id, err)
+		targets = append(targets, sub.sess)
+	}
+	return targets
+}
+
+// BroadcastToRoom queues a message for all sessions tracked in the room.
+// If skipID is non-nil, that session ID will not receive the broadcast.
+// Subscribers are snapshotted under the lock; delivery happens on each
+// session's outbox so a slow client never holds up the others. The message
+// is encoded once per codec in use among the subscribers.
+// Room content events should go through PublishToRoom so they are sequenced.
+func BroadcastToRoom(roomID string, msg *easytcp.Message, skipID interface{}) {
+	targets := roomTargets(roomID, skipID)
+	if len(targets) == 0 {
+		return
+	}
+
+	frames := newFrameCache(msg)
+	for _, sess := range targets {
+		data, err := frames.frameFor(sess)
+		if err != nil {
+			log.Printf("broadcast pack failed for room %s: %v", roomID, err)
+			continue
 		}
+		outboxFor(sess).push(data)
+	}
+}
+
+// SendToSession queues a server-initiated message for a single session.
+func SendToSession(sess easytcp.Session, msg *easytcp.Message) {
+	data, err := packFor(ProtocolFor(sess).Codec, msg)
+	if err != nil {
+		log.Printf("push pack failed for session %v: %v", sess.ID(), err)
+		return
 	}
+	outboxFor(sess).push(data)
 }
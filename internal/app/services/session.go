@@ -1,17 +1,46 @@
 package services
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"sync"
+	"time"
 
 	"github.com/DarthPestilane/easytcp"
 )
 
+const (
+	// tokenWarnBefore is how long before expiry the client is asked to refresh.
+	tokenWarnBefore = 2 * time.Minute
+	// expirySweepInterval is how often sessions are checked for expiry.
+	expirySweepInterval = 10 * time.Second
+)
+
 // UserSession holds authenticated user data for a connection.
 type UserSession struct {
 	UserID        string
 	Email         string
 	UserName      string
 	Authenticated bool
+	// ExpiresAt is the access token expiry; zero means the session never expires.
+	ExpiresAt time.Time
+	// ResumeToken lets a reconnecting client restore this session (route 13).
+	ResumeToken string
+	// Locale picks the language of error messages ("en" when empty).
+	Locale string
+
+	conn   easytcp.Session
+	warned bool
+	access *accessCache
+}
+
+// TokenNotice is pushed on route 12 when a session's token is about to expire
+// ("expiring") or has expired and the session was dropped ("expired").
+type TokenNotice struct {
+	Event     string `json:"event"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	ExpiresIn int    `json:"expires_in"`
 }
 
 var (
@@ -19,8 +48,18 @@ var (
 	sessionsMu sync.RWMutex
 )
 
-// StoreSession saves user info for the connection's lifetime.
-func StoreSession(sess easytcp.Session, userID, email, userName string) {
+// StoreSession saves user info for the connection's lifetime (or until the token
+// expires) and returns the session's resume token, which is empty unless the
+// connection negotiated FeatureResume.
+func StoreSession(sess easytcp.Session, userID, email, userName, locale string, expiresAt time.Time) string {
+	var token string
+	if ProtocolFor(sess).Has(FeatureResume) {
+		var err error
+		if token, err = newRandomToken(); err != nil {
+			log.Printf("resume token generation failed: %v", err)
+		}
+	}
+
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
 	sessions[sess.ID()] = &UserSession{
@@ -28,7 +67,50 @@ func StoreSession(sess easytcp.Session, userID, email, userName string) {
 		Email:         email,
 		UserName:      userName,
 		Authenticated: true,
+		ExpiresAt:     expiresAt,
+		ResumeToken:   token,
+		Locale:        locale,
+		conn:          sess,
+		access:        newAccessCache(),
+	}
+	return token
+}
+
+// NewRequestSession builds a session for a single stateless request (the HTTP
+// gateway). It is not stored, so it is never pushed to, swept or resumed.
+func NewRequestSession(user *SupabaseUser, locale string) *UserSession {
+	return &UserSession{
+		UserID:        user.ID,
+		Email:         user.Email,
+		UserName:      user.GetUserName(),
+		Authenticated: true,
+		ExpiresAt:     user.ExpiresAt,
+		Locale:        locale,
+		access:        newAccessCache(),
+	}
+}
+
+// RefreshSession swaps in a new token expiry for an authenticated session.
+// The token must belong to the same user; room subscriptions are untouched.
+func RefreshSession(sess easytcp.Session, userID, email, userName string, expiresAt time.Time) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	current, ok := sessions[sess.ID()]
+	if !ok || !current.Authenticated {
+		return fmt.Errorf("session is not authenticated")
+	}
+	if current.UserID != userID {
+		return fmt.Errorf("token belongs to a different user")
 	}
+
+	// Replace rather than mutate so handlers holding the old pointer stay race-free.
+	updated := *current
+	updated.Email = email
+	updated.UserName = userName
+	updated.ExpiresAt = expiresAt
+	updated.warned = false
+	sessions[sess.ID()] = &updated
+	return nil
 }
 
 // GetSession retrieves the user session, returns nil if not found.
@@ -38,6 +120,19 @@ func GetSession(sess easytcp.Session) *UserSession {
 	return sessions[sess.ID()]
 }
 
+// SessionsForUser returns every live connection authenticated as userID.
+func SessionsForUser(userID string) []easytcp.Session {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	var out []easytcp.Session
+	for _, us := range sessions {
+		if us.UserID == userID {
+			out = append(out, us.conn)
+		}
+	}
+	return out
+}
+
 // RemoveSession cleans up session data when connection closes.
 func RemoveSession(sess easytcp.Session) {
 	sessionsMu.Lock()
@@ -52,3 +147,60 @@ func IsAuthenticated(sess easytcp.Session) bool {
 	userSession, exists := sessions[sess.ID()]
 	return exists && userSession.Authenticated
 }
+
+// WatchSessionExpiry warns sessions whose token is about to expire, drops the
+// ones that expired without a refresh (route 11), forgets parked sessions
+// whose resume window has passed, frees the event streams of rooms idle
+// that long and expires unused undo histories. It never returns.
+func WatchSessionExpiry() {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		sweepExpiredSessions(now)
+		sweepParkedSessions(now)
+		sweepRoomStreams(now)
+		sweepHistories(now)
+	}
+}
+
+func sweepExpiredSessions(now time.Time) {
+	var warn, expired []*UserSession
+
+	sessionsMu.Lock()
+	for id, us := range sessions {
+		if us.ExpiresAt.IsZero() {
+			continue
+		}
+		switch {
+		case !now.Before(us.ExpiresAt):
+			delete(sessions, id)
+			expired = append(expired, us)
+		case !us.warned && us.ExpiresAt.Sub(now) <= tokenWarnBefore:
+			us.warned = true
+			warn = append(warn, us)
+		}
+	}
+	sessionsMu.Unlock()
+
+	for _, us := range warn {
+		sendTokenNotice(us, "expiring", now)
+	}
+	for _, us := range expired {
+		log.Printf("session expired for user %s", us.UserID)
+		RemoveSessionFromAllRooms(us.conn)
+		sendTokenNotice(us, "expired", now)
+	}
+}
+
+func sendTokenNotice(us *UserSession, event string, now time.Time) {
+	notice := TokenNotice{
+		Event:     event,
+		ExpiresAt: us.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if left := us.ExpiresAt.Sub(now); left > 0 {
+		notice.ExpiresIn = int(left.Seconds())
+	}
+	if b, err := json.Marshal(notice); err == nil {
+		SendToSession(us.conn, easytcp.NewMessage(12, b))
+	}
+}
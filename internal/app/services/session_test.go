@@ -0,0 +1,120 @@
+package services
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+)
+
+// testSession is an easytcp.Session over one end of a net.Pipe; tests read
+// what the server writes from peer.
+type testSession struct {
+	id        string
+	conn      net.Conn
+	peer      net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+	hook      chan struct{}
+}
+
+// newTestSession returns a session that negotiated protocol 2 with codec and
+// features, removed from every registry when the test ends.
+func newTestSession(t *testing.T, codec string, features ...string) *testSession {
+	t.Helper()
+	conn, peer := net.Pipe()
+	sess := &testSession{
+		id:     t.Name() + "/" + newStreamEpoch(),
+		conn:   conn,
+		peer:   peer,
+		closed: make(chan struct{}),
+		hook:   make(chan struct{}),
+	}
+	if _, err := NegotiateProtocol(sess, 2, 2, []string{codec}, features); err != nil {
+		t.Fatalf("negotiate protocol: %v", err)
+	}
+	t.Cleanup(func() {
+		RemoveSessionFromAllRooms(sess)
+		RemoveSession(sess)
+		CloseOutbox(sess)
+		ForgetProtocol(sess)
+		sess.closeHook()
+		conn.Close()
+		peer.Close()
+	})
+	return sess
+}
+
+// login installs an authenticated user session for userID.
+func (s *testSession) login(userID string) *UserSession {
+	us := &UserSession{UserID: userID, UserName: userID, Authenticated: true, conn: s, access: newAccessCache()}
+	sessionsMu.Lock()
+	sessions[s.ID()] = us
+	sessionsMu.Unlock()
+	return us
+}
+
+func (s *testSession) ID() interface{}                  { return s.id }
+func (s *testSession) SetID(id interface{})             {}
+func (s *testSession) Send(easytcp.Context) bool        { return false }
+func (s *testSession) Codec() easytcp.Codec             { return nil }
+func (s *testSession) AfterCreateHook() <-chan struct{} { return nil }
+func (s *testSession) AfterCloseHook() <-chan struct{}  { return s.hook }
+func (s *testSession) AllocateContext() easytcp.Context { return easytcp.NewContext().SetSession(s) }
+func (s *testSession) Conn() net.Conn                   { return s.conn }
+
+func (s *testSession) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// closeHook plays the server's part once the close hook has run.
+func (s *testSession) closeHook() {
+	select {
+	case <-s.hook:
+	default:
+		close(s.hook)
+	}
+}
+
+func (s *testSession) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// readFrame reads the next frame the server wrote to s.
+func (s *testSession) readFrame(t *testing.T) *easytcp.Message {
+	t.Helper()
+	s.peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := easytcp.NewDefaultPacker().Unpack(s.peer)
+	if err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	return msg
+}
+
+// expectNoFrame fails if the server writes anything to s within a short wait.
+func (s *testSession) expectNoFrame(t *testing.T) {
+	t.Helper()
+	s.peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if msg, err := easytcp.NewDefaultPacker().Unpack(s.peer); err == nil {
+		t.Fatalf("unexpected frame on route %v: %s", msg.ID(), msg.Data())
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
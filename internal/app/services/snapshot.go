@@ -0,0 +1,334 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAutoSnapshots is how many automatic snapshots are kept per song; named
+// snapshots are never pruned.
+const maxAutoSnapshots = 20
+
+// defaultSnapshotInterval is how often edited songs are snapshotted
+// automatically unless SNAPSHOT_INTERVAL says otherwise.
+const defaultSnapshotInterval = 10 * time.Minute
+
+// Snapshot is a saved copy of a song's settings, tracks and notes. Listings
+// leave Data out.
+type Snapshot struct {
+	ID         string        `json:"id"`
+	SongID     string        `json:"song_id"`
+	Name       string        `json:"name"`
+	Auto       bool          `json:"auto"`
+	TrackCount int           `json:"track_count"`
+	NoteCount  int           `json:"note_count"`
+	CreatedBy  string        `json:"created_by"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Data       *SnapshotData `json:"data,omitempty"`
+}
+
+// SnapshotData is the song content a snapshot restores.
+type SnapshotData struct {
+	Song   Song    `json:"song"`
+	Tracks []Track `json:"tracks"`
+	Notes  []Note  `json:"notes"`
+}
+
+// TakeSnapshot copies a song's current settings, tracks and notes into a new
+// snapshot. Automatic snapshots also prune the song's oldest automatic ones.
+func (st *Store) TakeSnapshot(songID, name, userID string, auto bool) (*Snapshot, error) {
+	song, err := st.Songs.GetSong(songID)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := st.Tracks.ListTracksBySong(songID)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := st.Notes.ListNotesBySong(songID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// The reads are not one transaction: leave out notes whose track was
+	// deleted in between, so the snapshot can always be restored.
+	known := make(map[string]bool, len(tracks))
+	for _, t := range tracks {
+		known[t.ID] = true
+	}
+	kept := notes[:0]
+	for _, n := range notes {
+		if known[n.TrackID] {
+			kept = append(kept, n)
+		}
+	}
+
+	snap, err := st.Snapshots.CreateSnapshot(Snapshot{
+		SongID:     songID,
+		Name:       name,
+		Auto:       auto,
+		TrackCount: len(tracks),
+		NoteCount:  len(kept),
+		CreatedBy:  userID,
+		Data:       &SnapshotData{Song: *song, Tracks: tracks, Notes: kept},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if auto {
+		if err := st.Snapshots.PruneAutoSnapshots(songID, maxAutoSnapshots); err != nil {
+			log.Printf("prune snapshots of song %s: %v", songID, err)
+		}
+	}
+	return snap, nil
+}
+
+// AutoSnapshotName names an automatic snapshot taken at t.
+func AutoSnapshotName(t time.Time) string {
+	return "Autosave " + t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+var (
+	editedSongs   = make(map[string]string) // song_id -> last editor, since the last autosave
+	editedSongsMu sync.Mutex
+)
+
+// MarkSongEdited queues a song for the next automatic snapshot.
+func MarkSongEdited(songID, userID string) {
+	editedSongsMu.Lock()
+	editedSongs[songID] = userID
+	editedSongsMu.Unlock()
+}
+
+// WatchAutoSnapshots snapshots every song edited since the previous tick,
+// once per SNAPSHOT_INTERVAL; it returns at once when the interval is 0.
+// Run it in its own goroutine.
+func WatchAutoSnapshots(st *Store) {
+	loadEnv()
+	if snapshotInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		editedSongsMu.Lock()
+		edited := editedSongs
+		editedSongs = make(map[string]string)
+		editedSongsMu.Unlock()
+
+		for songID, userID := range edited {
+			if _, err := st.TakeSnapshot(songID, AutoSnapshotName(now), userID, true); err != nil {
+				log.Printf("autosave song %s: %v", songID, err)
+			}
+		}
+	}
+}
+
+// CreateSnapshot inserts a song_snapshots row and returns it without its data.
+func (sb *Supabase) CreateSnapshot(s Snapshot) (*Snapshot, error) {
+	payload := map[string]interface{}{
+		"song_id":     s.SongID,
+		"name":        s.Name,
+		"auto":        s.Auto,
+		"track_count": s.TrackCount,
+		"note_count":  s.NoteCount,
+		"created_by":  s.CreatedBy,
+		"data":        s.Data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal snapshot payload: %w", err)
+	}
+
+	q := url.Values{}
+	q.Set("select", "id,song_id,name,auto,track_count,note_count,created_by,created_at")
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/song_snapshots?"+q.Encode(), bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("create snapshot failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var rows []Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode snapshot response: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("create snapshot returned no rows")
+	}
+
+	return &rows[0], nil
+}
+
+// ListSnapshots returns a song's snapshots without their data, newest first.
+func (sb *Supabase) ListSnapshots(songID string) ([]Snapshot, error) {
+	q := url.Values{}
+	q.Set("song_id", "eq."+songID)
+	q.Set("select", "id,song_id,name,auto,track_count,note_count,created_by,created_at")
+	q.Set("order", "created_at.desc")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/song_snapshots?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch snapshots: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch snapshots failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var snaps []Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snaps); err != nil {
+		return nil, fmt.Errorf("decode snapshots: %w", err)
+	}
+
+	return snaps, nil
+}
+
+// GetSnapshot fetches a snapshot with its data, or ErrNotFound.
+func (sb *Supabase) GetSnapshot(snapshotID string) (*Snapshot, error) {
+	q := url.Values{}
+	q.Set("id", "eq."+snapshotID)
+	q.Set("select", "id,song_id,name,auto,track_count,note_count,created_by,created_at,data")
+	q.Set("limit", "1")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/song_snapshots?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch snapshot failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var snaps []Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snaps); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if len(snaps) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &snaps[0], nil
+}
+
+// PruneAutoSnapshots deletes a song's automatic snapshots beyond the newest keep.
+func (sb *Supabase) PruneAutoSnapshots(songID string, keep int) error {
+	q := url.Values{}
+	q.Set("song_id", "eq."+songID)
+	q.Set("auto", "is.true")
+	q.Set("select", "id")
+	q.Set("order", "created_at.desc")
+	q.Set("offset", strconv.Itoa(keep))
+
+	endpoint := fmt.Sprintf("%s/rest/v1/song_snapshots?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch old snapshots: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("fetch old snapshots failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var old []Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&old); err != nil {
+		return fmt.Errorf("decode old snapshots: %w", err)
+	}
+	if len(old) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(old))
+	for _, s := range old {
+		ids = append(ids, s.ID)
+	}
+	del := url.Values{}
+	del.Set("id", "in.("+strings.Join(ids, ",")+")")
+
+	delReq, _ := http.NewRequest("DELETE", sb.url+"/rest/v1/song_snapshots?"+del.Encode(), nil)
+	delReq.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	delReq.Header.Set("apikey", sb.apiKey)
+
+	delResp, err := sb.client.Do(delReq)
+	if err != nil {
+		return fmt.Errorf("delete old snapshots: %w", err)
+	}
+	defer delResp.Body.Close()
+
+	if delResp.StatusCode != http.StatusNoContent && delResp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(delResp.Body)
+		return fmt.Errorf("delete old snapshots failed (status %d): %s", delResp.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+// RestoreSnapshot runs the restore_song_snapshot database function, which
+// puts back the snapshot's song settings and replaces the song's tracks and
+// notes in one transaction. An unknown snapshot is ErrNotFound.
+func (sb *Supabase) RestoreSnapshot(snapshotID string) error {
+	body, err := json.Marshal(map[string]interface{}{"_snapshot_id": snapshotID})
+	if err != nil {
+		return fmt.Errorf("marshal snapshot restore: %w", err)
+	}
+
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/rpc/restore_song_snapshot", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("restore snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound: // no_data_found raised for a missing snapshot
+		return ErrNotFound
+	default:
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("restore snapshot failed (status %d): %s", resp.StatusCode, respBody)
+	}
+}
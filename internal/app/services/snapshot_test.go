@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryRestoreSnapshot(t *testing.T) {
+	m, song, track := newTestSong(t)
+	st := &Store{Songs: m, Tracks: m, Notes: m, Snapshots: m}
+	n1, _ := m.CreateNote(song.ID, track.ID, 0, 30, 100, 1, "owner")
+	if _, err := m.UpdateNote(n1.ID, NotePatch{Velocity: intp(90)}, 0); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	before := songContent(t, m, song.ID)
+
+	snap, err := st.TakeSnapshot(song.ID, "verse", "owner", false)
+	if err != nil {
+		t.Fatalf("TakeSnapshot: %v", err)
+	}
+	if snap.Data != nil || snap.TrackCount != 1 || snap.NoteCount != 1 {
+		t.Fatalf("snapshot = %+v, want 1 track, 1 note and no data", snap)
+	}
+
+	// Change everything the snapshot covers.
+	if _, err := m.UpdateSong(song.ID, nil, intp(90), nil, nil, nil, nil, nil); err != nil {
+		t.Fatalf("UpdateSong: %v", err)
+	}
+	if _, err := m.UpdateNote(n1.ID, NotePatch{Step: intp(7)}, 0); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	drums, _ := m.CreateTrack(song.ID, "drums", "kit", nil, "")
+	m.CreateNote(song.ID, drums.ID, 1, 30, 100, 1, "owner")
+
+	if err := m.RestoreSnapshot(snap.ID); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if got, _ := m.GetSong(song.ID); got.BPM != song.BPM {
+		t.Fatalf("bpm after restore = %d, want %d", got.BPM, song.BPM)
+	}
+	if tracks, _ := m.ListTracksBySong(song.ID); len(tracks) != 1 || tracks[0].ID != track.ID {
+		t.Fatalf("tracks after restore = %+v, want only %s", tracks, track.ID)
+	}
+	if got := songContent(t, m, song.ID); !sameContent(got, before) {
+		t.Fatalf("notes after restore %v, want %v", got, before)
+	}
+
+	// Versions keep going up, so writers holding a pre-restore version lose.
+	restored, _ := m.GetNote(n1.ID)
+	if restored.Version != 4 {
+		t.Fatalf("restored note version = %d, want 4", restored.Version)
+	}
+	if _, err := m.UpdateNote(n1.ID, NotePatch{Velocity: intp(1)}, 3); !errors.Is(err, ErrStaleVersion) {
+		t.Fatalf("UpdateNote with a pre-restore version: err = %v, want ErrStaleVersion", err)
+	}
+
+	if err := m.RestoreSnapshot("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("RestoreSnapshot of an unknown id: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTakeSnapshotPrunesOnlyAutomatic(t *testing.T) {
+	m, song, _ := newTestSong(t)
+	st := &Store{Songs: m, Tracks: m, Notes: m, Snapshots: m}
+
+	named, err := st.TakeSnapshot(song.ID, "chorus", "owner", false)
+	if err != nil {
+		t.Fatalf("TakeSnapshot: %v", err)
+	}
+	for i := 0; i < maxAutoSnapshots+3; i++ {
+		if _, err := st.TakeSnapshot(song.ID, "auto", "owner", true); err != nil {
+			t.Fatalf("TakeSnapshot: %v", err)
+		}
+	}
+
+	snaps, _ := m.ListSnapshots(song.ID)
+	auto, keptNamed := 0, false
+	for _, s := range snaps {
+		if s.Data != nil {
+			t.Fatal("ListSnapshots returned snapshot data")
+		}
+		if s.Auto {
+			auto++
+		}
+		keptNamed = keptNamed || s.ID == named.ID
+	}
+	if auto != maxAutoSnapshots || !keptNamed {
+		t.Fatalf("kept %d automatic snapshots (named kept: %v), want %d and the named one", auto, keptNamed, maxAutoSnapshots)
+	}
+}
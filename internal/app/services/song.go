@@ -26,21 +26,53 @@ type Song struct {
 	CreatedAt       time.Time `json:"created_at"`
 }
 
-// ListSongsByRoom fetches songs for a given room from Supabase.
-func ListSongsByRoom(roomID string) ([]Song, error) {
-	loadEnv()
+// GetSong fetches a single song by id, or ErrNotFound.
+func (sb *Supabase) GetSong(songID string) (*Song, error) {
+	q := url.Values{}
+	q.Set("select", "id,room_id,title,bpm,steps,beats_per_measure,scale,start_pitch,octave_range,created_by,created_at")
+	q.Set("id", "eq."+songID)
+	q.Set("limit", "1")
 
+	endpoint := fmt.Sprintf("%s/rest/v1/songs?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch song: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch song failed (status %d): %s", resp.StatusCode, bodyBytes)
+	}
+
+	var songs []Song
+	if err := json.NewDecoder(resp.Body).Decode(&songs); err != nil {
+		return nil, fmt.Errorf("decode song: %w", err)
+	}
+	if len(songs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &songs[0], nil
+}
+
+// ListSongsByRoom fetches songs for a given room from Supabase.
+func (sb *Supabase) ListSongsByRoom(roomID string) ([]Song, error) {
 	q := url.Values{}
 	q.Set("select", "id,room_id,title,bpm,steps,beats_per_measure,scale,start_pitch,octave_range,created_by,created_at")
 	q.Set("room_id", "eq."+roomID)
 	q.Set("order", "created_at.asc")
 
-	endpoint := fmt.Sprintf("%s/rest/v1/songs?%s", supabaseURL, q.Encode())
+	endpoint := fmt.Sprintf("%s/rest/v1/songs?%s", sb.url, q.Encode())
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch songs: %w", err)
 	}
@@ -60,9 +92,7 @@ func ListSongsByRoom(roomID string) ([]Song, error) {
 }
 
 // CreateSong inserts a new song row and returns it.
-func CreateSong(roomID, title string, bpm, steps int, userID string) (*Song, error) {
-	loadEnv()
-
+func (sb *Supabase) CreateSong(roomID, title string, bpm, steps int, userID string) (*Song, error) {
 	if bpm <= 0 {
 		bpm = 120
 	}
@@ -84,13 +114,13 @@ func CreateSong(roomID, title string, bpm, steps int, userID string) (*Song, err
 		return nil, fmt.Errorf("marshal song payload: %w", err)
 	}
 
-	req, _ := http.NewRequest("POST", supabaseURL+"/rest/v1/songs", bytes.NewReader(body))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/songs", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Prefer", "return=representation")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("create song: %w", err)
 	}
@@ -113,13 +143,52 @@ func CreateSong(roomID, title string, bpm, steps int, userID string) (*Song, err
 }
 
 // UpdateSong updates song metadata and returns the updated row.
-func UpdateSong(songID string, title *string, bpm *int, steps *int, beatsPerMeasure *int, scale *string, startPitch *int, octaveRange *int) (*Song, error) {
-	loadEnv()
-
+func (sb *Supabase) UpdateSong(songID string, title *string, bpm *int, steps *int, beatsPerMeasure *int, scale *string, startPitch *int, octaveRange *int) (*Song, error) {
 	if songID == "" {
 		return nil, fmt.Errorf("song_id is required")
 	}
 
+	payload, err := songUpdatePayload(title, bpm, steps, beatsPerMeasure, scale, startPitch, octaveRange)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal song update payload: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/rest/v1/songs?id=eq.%s", sb.url, songID)
+	req, _ := http.NewRequest("PATCH", url, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("update song: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("update song failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var rows []Song
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode song update response: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("update song returned no rows")
+	}
+
+	return &rows[0], nil
+}
+
+// songUpdatePayload validates optional song settings and returns the columns to patch.
+func songUpdatePayload(title *string, bpm *int, steps *int, beatsPerMeasure *int, scale *string, startPitch *int, octaveRange *int) (map[string]interface{}, error) {
 	payload := map[string]interface{}{}
 
 	if title != nil {
@@ -176,36 +245,5 @@ func UpdateSong(songID string, title *string, bpm *int, steps *int, beatsPerMeas
 		return nil, fmt.Errorf("no fields to update")
 	}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("marshal song update payload: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/rest/v1/songs?id=eq.%s", supabaseURL, songID)
-	req, _ := http.NewRequest("PATCH", url, bytes.NewReader(body))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Prefer", "return=representation")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("update song: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("update song failed (status %d): %s", resp.StatusCode, respBody)
-	}
-
-	var rows []Song
-	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
-		return nil, fmt.Errorf("decode song update response: %w", err)
-	}
-	if len(rows) == 0 {
-		return nil, fmt.Errorf("update song returned no rows")
-	}
-
-	return &rows[0], nil
+	return payload, nil
 }
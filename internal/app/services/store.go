@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned by single-row lookups when no row matches.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a write would break a uniqueness rule, such as
+// two notes on the same track, step and pitch.
+var ErrConflict = errors.New("conflict")
+
+// ErrStaleVersion is returned when a note write names an expected version and
+// the note has moved on (or is gone).
+var ErrStaleVersion = errors.New("note version changed")
+
+// RoomStore persists rooms and room memberships.
+type RoomStore interface {
+	CreateRoom(ownerID, title string, isPrivate bool) (*Room, error)
+	// GetRoom returns ErrNotFound when the room does not exist.
+	GetRoom(roomID string) (*Room, error)
+	// UpdateRoom returns ErrNotFound when the room does not exist.
+	UpdateRoom(roomID string, title *string, isPrivate *bool, description *string) (*Room, error)
+	// DeleteRoom also removes the room's songs, tracks, notes, messages,
+	// invites, bans and memberships.
+	DeleteRoom(roomID string) error
+	ListRoomsByUser(userID string) ([]Room, error)
+	FindPublicRooms(name, userID string) ([]Room, error)
+	// JoinRoomByCode returns ErrBanned for banned users and ErrInviteRequired
+	// for newcomers to private rooms.
+	JoinRoomByCode(code, userID string) (*Room, error)
+	RegenerateRoomCode(roomID string) (string, error)
+	LeaveRoom(roomID, userID string) error
+	// GetMembership returns ErrNotFound when the user is not in the room.
+	GetMembership(roomID, userID string) (*RoomMember, error)
+	ListMembers(roomID string) ([]RoomMember, error)
+	// SetMemberRole returns ErrNotFound when the user is not in the room.
+	SetMemberRole(roomID, userID, role string) error
+	BanMember(roomID, userID, bannedBy string) error
+	UnbanMember(roomID, userID string) error
+	IsBanned(roomID, userID string) (bool, error)
+	// TransferOwnership returns ErrNotFound when fromID no longer owns the room.
+	TransferOwnership(roomID, fromID, toID string) error
+}
+
+// InviteStore persists expiring, revocable room invites.
+type InviteStore interface {
+	CreateInvite(roomID, createdBy, role string, maxUses int, expiresAt time.Time) (*Invite, error)
+	ListInvites(roomID string) ([]Invite, error)
+	// RevokeInvite returns ErrNotFound when the room has no such invite.
+	RevokeInvite(roomID, token string) error
+	// RedeemInvite returns ErrInviteNotFound, ErrInviteExpired, ErrInviteUsedUp or ErrBanned.
+	RedeemInvite(token, userID string) (*Room, error)
+}
+
+// MessageStore persists room chat messages.
+type MessageStore interface {
+	CreateMessage(roomID, senderID, senderName, body string) (*Message, error)
+	ListMessages(roomID, beforeID string, limit int, includeSystem bool) ([]Message, bool, error)
+}
+
+// SongStore persists songs and their settings.
+type SongStore interface {
+	GetSong(songID string) (*Song, error)
+	ListSongsByRoom(roomID string) ([]Song, error)
+	CreateSong(roomID, title string, bpm, steps int, userID string) (*Song, error)
+	UpdateSong(songID string, title *string, bpm *int, steps *int, beatsPerMeasure *int, scale *string, startPitch *int, octaveRange *int) (*Song, error)
+}
+
+// TrackStore persists song tracks.
+type TrackStore interface {
+	GetTrack(trackID string) (*Track, error)
+	CreateTrack(songID, name, instrument string, channel *int, color string) (*Track, error)
+	DeleteTrack(trackID, songID string) error
+	// DeleteTrackIfNotes deletes a track and its notes only if those notes
+	// are exactly versions (note ID -> version): ErrStaleVersion when a note
+	// was added, changed or removed, ErrNotFound when the track is gone.
+	DeleteTrackIfNotes(trackID, songID string, versions map[string]int) error
+	// RestoreTrack re-inserts a deleted track under its old ID; ErrConflict
+	// when it exists.
+	RestoreTrack(t Track) (*Track, error)
+	ListTracksBySong(songID string) ([]Track, error)
+}
+
+// NoteStore persists grid notes.
+type NoteStore interface {
+	// CreateNote returns ErrConflict when the position is taken.
+	CreateNote(songID, trackID string, step, pitch, velocity, lengthSteps int, userID string) (*Note, error)
+	// DeleteNote returns the deleted note. It returns ErrNotFound when no
+	// note sits at the position and, with expectedVersion > 0,
+	// ErrStaleVersion when the note's differs.
+	DeleteNote(songID, trackID string, step, pitch, expectedVersion int) (*Note, error)
+	// GetNote returns ErrNotFound when the note does not exist.
+	GetNote(noteID string) (*Note, error)
+	// UpdateNote returns ErrNotFound when the note does not exist,
+	// ErrConflict when another note already sits at the new position and,
+	// with expectedVersion > 0, ErrStaleVersion when the version differs.
+	// Every update bumps the note's version.
+	UpdateNote(noteID string, patch NotePatch, expectedVersion int) (*Note, error)
+	// ApplyNoteBatch applies ops to a song's notes in order, all or nothing,
+	// and returns one change per op. An unknown note_id is ErrNotFound, a
+	// taken position ErrConflict and a missed expected_version
+	// ErrStaleVersion (the database reports the last two alike).
+	ApplyNoteBatch(songID, userID string, ops []NoteOp) ([]NoteChange, error)
+	ListNotesBySong(songID, trackID string) ([]Note, error)
+}
+
+// SnapshotStore persists named and automatic song snapshots.
+type SnapshotStore interface {
+	CreateSnapshot(s Snapshot) (*Snapshot, error)
+	// ListSnapshots leaves out each snapshot's data.
+	ListSnapshots(songID string) ([]Snapshot, error)
+	// GetSnapshot returns ErrNotFound when the snapshot does not exist.
+	GetSnapshot(snapshotID string) (*Snapshot, error)
+	PruneAutoSnapshots(songID string, keep int) error
+	// RestoreSnapshot sets the song's settings back to the snapshot's and
+	// replaces its tracks and notes, all or nothing. Restored notes keep
+	// their IDs with a version above any they had, so stale writes fail.
+	RestoreSnapshot(snapshotID string) error
+}
+
+// PostStore persists community posts.
+type PostStore interface {
+	CreateCommunityPost(authorID, title, body string) (*CommunityPost, error)
+	DeleteCommunityPost(postID, authorID string) error
+	UpdateCommunityPost(postID, authorID string, title *string, body *string) (*CommunityPost, error)
+	ListCommunityPosts(before string, limit int, includeAttachments bool) ([]CommunityPostWithAttachments, bool, error)
+}
+
+// Store bundles the repositories handed to route handlers.
+// A backend may implement several (or all) of them with a single type.
+type Store struct {
+	Rooms     RoomStore
+	Invites   InviteStore
+	Messages  MessageStore
+	Songs     SongStore
+	Tracks    TrackStore
+	Notes     NoteStore
+	Snapshots SnapshotStore
+	Posts     PostStore
+}
+
+// NewStoreFromEnv picks the storage backend from STORE_BACKEND:
+// "supabase" (default) or "memory". The memory backend persists to
+// STORE_SNAPSHOT_PATH when it is set.
+func NewStoreFromEnv() (*Store, error) {
+	loadEnv()
+
+	switch storeBackend {
+	case "", "supabase":
+		return NewSupabaseStore(), nil
+	case "memory":
+		path := os.Getenv("STORE_SNAPSHOT_PATH")
+		if path == "" {
+			log.Printf("using in-memory store (no snapshot, data is lost on exit)")
+		} else {
+			log.Printf("using in-memory store with snapshot %s", path)
+		}
+		return NewMemoryStore(path)
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q", storeBackend)
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import "net/http"
+
+// Supabase implements every store interface on top of Supabase PostgREST.
+type Supabase struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+var (
+	_ RoomStore     = (*Supabase)(nil)
+	_ InviteStore   = (*Supabase)(nil)
+	_ MessageStore  = (*Supabase)(nil)
+	_ SongStore     = (*Supabase)(nil)
+	_ TrackStore    = (*Supabase)(nil)
+	_ NoteStore     = (*Supabase)(nil)
+	_ SnapshotStore = (*Supabase)(nil)
+	_ PostStore     = (*Supabase)(nil)
+)
+
+// NewSupabase returns a backend configured from SUPABASE_URL and SUPABASE_API_KEY.
+func NewSupabase() *Supabase {
+	loadEnv()
+	return &Supabase{
+		url:    supabaseURL,
+		apiKey: supabaseAPIKey,
+		client: http.DefaultClient,
+	}
+}
+
+// NewSupabaseStore wires a single Supabase backend into every repository.
+func NewSupabaseStore() *Store {
+	sb := NewSupabase()
+	return &Store{
+		Rooms:     sb,
+		Invites:   sb,
+		Messages:  sb,
+		Songs:     sb,
+		Tracks:    sb,
+		Notes:     sb,
+		Snapshots: sb,
+		Posts:     sb,
+	}
+}
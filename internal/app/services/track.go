@@ -21,10 +21,42 @@ type Track struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
-// CreateTrack inserts a new track and returns it.
-func CreateTrack(songID, name, instrument string, channel *int, color string) (*Track, error) {
-	loadEnv()
+// GetTrack fetches a single track by id, or ErrNotFound.
+func (sb *Supabase) GetTrack(trackID string) (*Track, error) {
+	q := url.Values{}
+	q.Set("id", "eq."+trackID)
+	q.Set("select", "id,song_id,name,instrument,channel,color,created_at")
+	q.Set("limit", "1")
+
+	endpoint := fmt.Sprintf("%s/rest/v1/tracks?%s", sb.url, q.Encode())
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch track: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetch track failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var tracks []Track
+	if err := json.NewDecoder(resp.Body).Decode(&tracks); err != nil {
+		return nil, fmt.Errorf("decode track: %w", err)
+	}
+	if len(tracks) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &tracks[0], nil
+}
 
+// CreateTrack inserts a new track and returns it.
+func (sb *Supabase) CreateTrack(songID, name, instrument string, channel *int, color string) (*Track, error) {
 	if songID == "" || name == "" {
 		return nil, fmt.Errorf("song_id and name are required")
 	}
@@ -44,13 +76,13 @@ func CreateTrack(songID, name, instrument string, channel *int, color string) (*
 		return nil, fmt.Errorf("marshal track payload: %w", err)
 	}
 
-	req, _ := http.NewRequest("POST", supabaseURL+"/rest/v1/tracks", bytes.NewReader(body))
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/tracks", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Prefer", "return=representation")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("create track: %w", err)
 	}
@@ -73,23 +105,21 @@ func CreateTrack(songID, name, instrument string, channel *int, color string) (*
 }
 
 // DeleteTrack removes a track by id (and optional song guard).
-func DeleteTrack(trackID, songID string) error {
-	loadEnv()
-
+func (sb *Supabase) DeleteTrack(trackID, songID string) error {
 	if trackID == "" {
 		return fmt.Errorf("track_id is required")
 	}
 
-	url := fmt.Sprintf("%s/rest/v1/tracks?id=eq.%s", supabaseURL, trackID)
+	url := fmt.Sprintf("%s/rest/v1/tracks?id=eq.%s", sb.url, trackID)
 	if songID != "" {
 		url += fmt.Sprintf("&song_id=eq.%s", songID)
 	}
 
 	req, _ := http.NewRequest("DELETE", url, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("delete track: %w", err)
 	}
@@ -103,10 +133,84 @@ func DeleteTrack(trackID, songID string) error {
 	return nil
 }
 
-// ListTracksBySong returns all tracks for a song ordered by created_at.
-func ListTracksBySong(songID string) ([]Track, error) {
-	loadEnv()
+// DeleteTrackIfNotes runs the delete_track_if_notes database function, which
+// deletes the track and its notes in one transaction after checking the notes
+// are still exactly versions. A mismatch fails with PT409 (HTTP 409) and a
+// missing track with no_data_found (404).
+func (sb *Supabase) DeleteTrackIfNotes(trackID, songID string, versions map[string]int) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"_track_id": trackID,
+		"_song_id":  songID,
+		"_versions": versions,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal track delete: %w", err)
+	}
+
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/rpc/delete_track_if_notes", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("delete track: %w", err)
+	}
+	defer resp.Body.Close()
 
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusConflict:
+		return ErrStaleVersion
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete track failed (status %d): %s", resp.StatusCode, respBody)
+	}
+}
+
+// RestoreTrack inserts t with its original id and created_at (undo of a delete).
+func (sb *Supabase) RestoreTrack(t Track) (*Track, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("marshal track payload: %w", err)
+	}
+
+	req, _ := http.NewRequest("POST", sb.url+"/rest/v1/tracks", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("restore track: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrConflict
+	}
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("restore track failed (status %d): %s", resp.StatusCode, respBody)
+	}
+
+	var rows []Track
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode track response: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("restore track returned no rows")
+	}
+
+	return &rows[0], nil
+}
+
+// ListTracksBySong returns all tracks for a song ordered by created_at.
+func (sb *Supabase) ListTracksBySong(songID string) ([]Track, error) {
 	if songID == "" {
 		return nil, fmt.Errorf("song_id is required")
 	}
@@ -116,12 +220,12 @@ func ListTracksBySong(songID string) ([]Track, error) {
 	q.Set("select", "id,song_id,name,instrument,channel,color,created_at")
 	q.Set("order", "created_at.asc")
 
-	endpoint := fmt.Sprintf("%s/rest/v1/tracks?%s", supabaseURL, q.Encode())
+	endpoint := fmt.Sprintf("%s/rest/v1/tracks?%s", sb.url, q.Encode())
 	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+supabaseAPIKey)
-	req.Header.Set("apikey", supabaseAPIKey)
+	req.Header.Set("Authorization", "Bearer "+sb.apiKey)
+	req.Header.Set("apikey", sb.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch tracks: %w", err)
 	}
@@ -0,0 +1,283 @@
+package app
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"musick-server/internal/app/services"
+
+	"github.com/DarthPestilane/easytcp"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket gateway: browsers can't speak easytcp's length-prefixed framing,
+// so each WebSocket message is turned into one easytcp frame (and back) and
+// the connection is served by an easytcp.Server with the same routes. Session
+// state and room subscriptions live in services, so WebSocket and TCP clients
+// share rooms.
+//
+// Frames from the client are either
+//   - binary: the route ID as a little-endian uint32 followed by the payload
+//     (JSON, or whatever codec the handshake negotiated), or
+//   - text: {"id": <route>, "data": <JSON payload>}.
+//
+// Replies and pushes use the same form as the client's latest frame; payloads
+// that aren't JSON always go out as binary.
+
+// wsPath is where the gateway accepts WebSocket upgrades.
+const wsPath = "/ws"
+
+// easytcpHeaderSize is the DefaultPacker header: data size then route ID,
+// both little-endian uint32.
+const easytcpHeaderSize = 8
+
+// wsPingWriteTimeout bounds sending one keepalive ping.
+const wsPingWriteTimeout = 5 * time.Second
+
+type wsTextFrame struct {
+	ID   uint32          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// wsListener hands upgraded WebSocket connections to easytcp's accept loop.
+type wsListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSListener(addr net.Addr) *wsListener {
+	return &wsListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *wsListener) Addr() net.Addr { return l.addr }
+
+// wsConn adapts a WebSocket connection to the byte stream easytcp expects.
+type wsConn struct {
+	ws      *websocket.Conn
+	packer  *easytcp.DefaultPacker
+	timeout time.Duration // read deadline, renewed by every frame and pong
+
+	done      chan struct{} // closed by Close; stops the pinger
+	closeOnce sync.Once
+
+	rbuf []byte // packed frame not yet consumed by Read
+
+	wmu  sync.Mutex
+	wbuf []byte // bytes written since the last complete frame
+
+	text atomic.Bool // the client's latest frame was a text frame
+}
+
+// newWSConn wraps an upgraded connection and starts pinging it every
+// HEARTBEAT_INTERVAL. Browsers answer pings on their own, so a client that
+// vanished without closing is dropped after HEARTBEAT_TIMEOUT without any
+// frame or pong, whether or not it negotiated the heartbeat feature.
+func newWSConn(ws *websocket.Conn, packer *easytcp.DefaultPacker) *wsConn {
+	interval, timeout := services.HeartbeatSettings()
+	c := &wsConn{ws: ws, packer: packer, timeout: timeout, done: make(chan struct{})}
+	ws.SetReadDeadline(time.Now().Add(timeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(timeout))
+	})
+	go c.ping(interval)
+	return c
+}
+
+func (c *wsConn) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPingWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Read serves the next client WebSocket message as one easytcp frame.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		typ, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.ws.SetReadDeadline(time.Now().Add(c.timeout))
+		msg, err := c.decodeFrame(typ, data)
+		if err != nil {
+			c.ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseUnsupportedData, err.Error()),
+				time.Now().Add(time.Second))
+			return 0, err
+		}
+		if c.rbuf, err = c.packer.Pack(msg); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *wsConn) decodeFrame(typ int, data []byte) (*easytcp.Message, error) {
+	switch typ {
+	case websocket.BinaryMessage:
+		if len(data) < 4 {
+			return nil, errors.New("binary frame shorter than its route ID")
+		}
+		c.text.Store(false)
+		return easytcp.NewMessage(int(binary.LittleEndian.Uint32(data)), data[4:]), nil
+	case websocket.TextMessage:
+		var f wsTextFrame
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("text frame: %w", err)
+		}
+		c.text.Store(true)
+		return easytcp.NewMessage(int(f.ID), []byte(f.Data)), nil
+	}
+	return nil, fmt.Errorf("unsupported frame type %d", typ)
+}
+
+// Write takes packed easytcp frames (from the session writer and the outbox)
+// and sends each as one WebSocket message.
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, p...)
+	for len(c.wbuf) >= easytcpHeaderSize {
+		size := int(binary.LittleEndian.Uint32(c.wbuf[:4]))
+		if len(c.wbuf) < easytcpHeaderSize+size {
+			break
+		}
+		id := binary.LittleEndian.Uint32(c.wbuf[4:8])
+		data := c.wbuf[easytcpHeaderSize : easytcpHeaderSize+size]
+		if err := c.writeFrame(id, data); err != nil {
+			return 0, err
+		}
+		c.wbuf = c.wbuf[easytcpHeaderSize+size:]
+	}
+	if len(c.wbuf) == 0 {
+		c.wbuf = nil
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) writeFrame(id uint32, data []byte) error {
+	if c.text.Load() && json.Valid(data) {
+		frame, err := json.Marshal(wsTextFrame{ID: id, Data: data})
+		if err != nil {
+			return err
+		}
+		return c.ws.WriteMessage(websocket.TextMessage, frame)
+	}
+	frame := make([]byte, 4+len(data))
+	binary.LittleEndian.PutUint32(frame, id)
+	copy(frame[4:], data)
+	return c.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr                { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr               { return c.ws.RemoteAddr() }
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+// wsUpgrader builds the upgrader; WS_ALLOWED_ORIGINS is a comma-separated
+// list of origins allowed to connect ("*" for any). Unset, only same-origin
+// pages may connect.
+func wsUpgrader() *websocket.Upgrader {
+	up := &websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+	raw := strings.TrimSpace(os.Getenv("WS_ALLOWED_ORIGINS"))
+	if raw == "" {
+		return up
+	}
+	allowed := make(map[string]bool)
+	for _, o := range strings.Split(raw, ",") {
+		allowed[strings.TrimRight(strings.TrimSpace(o), "/")] = true
+	}
+	up.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowed["*"] || allowed[origin]
+	}
+	return up
+}
+
+// runWebSocket listens for WebSocket upgrades on addr and serves them with srv.
+func runWebSocket(srv *easytcp.Server, packer *easytcp.DefaultPacker, addr string) error {
+	tcpLis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	lis := newWSListener(tcpLis.Addr())
+	up := wsUpgrader()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(wsPath, func(w http.ResponseWriter, r *http.Request) {
+		ws, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("websocket upgrade from %s failed: %v", r.RemoteAddr, err)
+			return
+		}
+		if packer.MaxDataSize > 0 {
+			ws.SetReadLimit(int64(packer.MaxDataSize) + 1024)
+		}
+		conn := newWSConn(ws, packer)
+		select {
+		case lis.conns <- conn:
+		case <-lis.done:
+			conn.Close()
+		}
+	})
+
+	httpSrv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := httpSrv.Serve(tcpLis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("websocket listener stopped: %v", err)
+		}
+		lis.Close()
+	}()
+
+	log.Printf("websocket gateway listening on ws://%s%s", addr, wsPath)
+	return srv.Serve(lis)
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DarthPestilane/easytcp"
+	"github.com/gorilla/websocket"
+)
+
+// wsPair returns the server side of a WebSocket connection, wrapped as the
+// gateway does, and the client side that talks to it.
+func wsPair(t *testing.T) (*wsConn, *websocket.Conn) {
+	t.Helper()
+	packer := easytcp.NewDefaultPacker()
+	conns := make(chan *wsConn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- newWSConn(ws, packer)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case conn := <-conns:
+		t.Cleanup(func() { conn.Close() })
+		return conn, client
+	case <-time.After(2 * time.Second):
+		t.Fatal("server never accepted the connection")
+	}
+	return nil, nil
+}
+
+func binaryFrame(id uint32, data string) []byte {
+	frame := make([]byte, 4+len(data))
+	binary.LittleEndian.PutUint32(frame, id)
+	copy(frame[4:], data)
+	return frame
+}
+
+// readClient reads the next message the client receives.
+func readClient(t *testing.T, client *websocket.Conn) (int, string) {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	typ, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	return typ, string(data)
+}
+
+func TestWSConnReadsClientFrames(t *testing.T) {
+	conn, client := wsPair(t)
+	packer := easytcp.NewDefaultPacker()
+
+	tests := []struct {
+		name   string
+		typ    int
+		frame  []byte
+		wantID int
+		want   string
+	}{
+		{"binary", websocket.BinaryMessage, binaryFrame(601, `{"step":1}`), 601, `{"step":1}`},
+		{"binary empty payload", websocket.BinaryMessage, binaryFrame(3, ""), 3, ""},
+		{"text", websocket.TextMessage, []byte(`{"id":10,"data":{"token":"t"}}`), 10, `{"token":"t"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := client.WriteMessage(tt.typ, tt.frame); err != nil {
+				t.Fatalf("client write: %v", err)
+			}
+			msg, err := packer.Unpack(conn)
+			if err != nil {
+				t.Fatalf("unpack: %v", err)
+			}
+			if msg.ID() != tt.wantID || string(msg.Data()) != tt.want {
+				t.Fatalf("frame = %v %q, want %d %q", msg.ID(), msg.Data(), tt.wantID, tt.want)
+			}
+		})
+	}
+}
+
+func TestWSConnRejectsBadFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		typ   int
+		frame []byte
+	}{
+		{"short binary", websocket.BinaryMessage, []byte{1, 2}},
+		{"text not JSON", websocket.TextMessage, []byte(`hello`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := wsPair(t)
+			if err := client.WriteMessage(tt.typ, tt.frame); err != nil {
+				t.Fatalf("client write: %v", err)
+			}
+			if _, err := conn.Read(make([]byte, 64)); err == nil {
+				t.Fatal("Read accepted a malformed frame")
+			}
+
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, _, err := client.ReadMessage()
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseUnsupportedData {
+				t.Fatalf("client got %v, want close %d", err, websocket.CloseUnsupportedData)
+			}
+		})
+	}
+}
+
+func TestWSConnWritesFrames(t *testing.T) {
+	conn, client := wsPair(t)
+	packer := easytcp.NewDefaultPacker()
+	pack := func(id int, data string) []byte {
+		b, err := packer.Pack(easytcp.NewMessage(id, []byte(data)))
+		if err != nil {
+			t.Fatalf("pack: %v", err)
+		}
+		return b
+	}
+
+	// Until the client sends text, everything goes out as binary. Two
+	// frames in one Write become two messages.
+	both := append(pack(302, `{"body":"hi"}`), pack(251, `{"event":"joined"}`)...)
+	if _, err := conn.Write(both); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, want := range []string{string(binaryFrame(302, `{"body":"hi"}`)), string(binaryFrame(251, `{"event":"joined"}`))} {
+		if typ, got := readClient(t, client); typ != websocket.BinaryMessage || got != want {
+			t.Fatalf("client got type %d %q, want binary %q", typ, got, want)
+		}
+	}
+
+	// After a text frame from the client, JSON replies come back as text; a
+	// frame split across writes is sent once it is complete.
+	if err := client.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"data":{}}`)); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+	if _, err := packer.Unpack(conn); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	frame := pack(1, `{"ok":true}`)
+	for _, part := range [][]byte{frame[:3], frame[3:10], frame[10:]} {
+		if _, err := conn.Write(part); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if typ, got := readClient(t, client); typ != websocket.TextMessage || got != `{"id":1,"data":{"ok":true}}` {
+		t.Fatalf("client got type %d %q, want a text frame", typ, got)
+	}
+
+	// Payloads that aren't JSON (msgpack, protobuf) stay binary.
+	if _, err := conn.Write(pack(603, "\x81\xa1a\x01")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if typ, got := readClient(t, client); typ != websocket.BinaryMessage || got != string(binaryFrame(603, "\x81\xa1a\x01")) {
+		t.Fatalf("client got type %d %q, want binary", typ, got)
+	}
+}
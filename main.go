@@ -2,13 +2,19 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"musick-server/internal/app"
+	"musick-server/internal/app/services"
 
 	"github.com/joho/godotenv"
 )
 
-const listenAddr = "0.0.0.0:5896"
+const (
+	listenAddr     = "0.0.0.0:5896"
+	wsListenAddr   = "0.0.0.0:5897"
+	httpListenAddr = "0.0.0.0:8080"
+)
 
 func main() {
 	// Load .env file
@@ -16,8 +22,33 @@ func main() {
 		log.Println("Warning: .env file not found, using system environment variables")
 	}
 
-	server := app.New()
-	if err := server.Run(listenAddr); err != nil {
+	store, err := services.NewStoreFromEnv()
+	if err != nil {
+		log.Fatalf("storage setup failed: %v", err)
+	}
+
+	// WS_LISTEN_ADDR and HTTP_LISTEN_ADDR move the WebSocket and REST
+	// gateways; "off" disables them.
+	wsAddr := envAddr("WS_LISTEN_ADDR", wsListenAddr)
+	httpAddr := envAddr("HTTP_LISTEN_ADDR", httpListenAddr)
+
+	server, err := app.New(store)
+	if err != nil {
+		log.Fatalf("server setup failed: %v", err)
+	}
+	if err := server.Run(listenAddr, wsAddr, httpAddr); err != nil {
 		log.Fatalf("server stopped: %v", err)
 	}
 }
+
+// envAddr reads a listen address from key, falling back to def; "off" yields "".
+func envAddr(key, def string) string {
+	switch addr := os.Getenv(key); addr {
+	case "":
+		return def
+	case "off":
+		return ""
+	default:
+		return addr
+	}
+}
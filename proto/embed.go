@@ -0,0 +1,11 @@
+// Package proto embeds the Protobuf definitions of the core messages so the
+// server can compile its message descriptors at startup (see
+// services.LoadProtoSchemas) without generated code.
+package proto
+
+import "embed"
+
+// Files holds every .proto file in this directory.
+//
+//go:embed *.proto
+var Files embed.FS